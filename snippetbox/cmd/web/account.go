@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"snippetbox.audryhsu.com/internal/models"
+	"snippetbox.audryhsu.com/internal/validator"
+	"strconv"
+	"time"
+)
+
+// sessionTouchInterval is how often a session's last seen time is updated. Updating it on every request would be a database write per page view.
+const sessionTouchInterval = time.Minute
+
+// startSession logs the user in to a fresh session: the session gets a new token, so one planted before login is useless, and is tracked on the user's account page.
+// A remembered session gets a persistent cookie and lasts for -remember-lifetime; others end when the browser is closed, after -session-lifetime, or after -session-idle-timeout without use.
+func (app *application) startSession(r *http.Request, userID int, remember bool) error {
+	// Good practice to generate a new session ID when auth state or priv levels change for a user (e.g. login/logout)
+	if err := app.sessionManager.RenewToken(r.Context()); err != nil {
+		return err
+	}
+	// add ID of current user to session so they are 'logged in'
+	app.sessionManager.Put(r.Context(), "authenticatedUserID", userID)
+	app.sessionManager.Put(r.Context(), "authenticatedAt", time.Now().Unix())
+	app.sessionManager.RememberMe(r.Context(), remember)
+
+	expires := app.sessionManager.Deadline(r.Context())
+	if !remember {
+		expires = time.Now().Add(app.config.sessionLifetime)
+	}
+	_, err := app.sessions.Insert(r.Context(), &models.Session{
+		UserID:    userID,
+		Token:     app.sessionManager.Token(r.Context()),
+		Expires:   expires,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		Remember:  remember,
+	})
+	return err
+}
+
+// revokeSessions deletes sessions from the session store, which logs them out on their next request.
+func (app *application) revokeSessions(tokens []string) error {
+	for _, token := range tokens {
+		if err := app.sessionManager.Store.Delete(token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trackSession checks that the request's logged in session is still tracked and hasn't expired, and updates when and where it was last seen. Sessions that aren't tracked were revoked, or started before sessions were tracked, and are logged out, as are expired ones.
+// The idle timeout is measured from the last seen time, so it is only as precise as sessionTouchInterval.
+func (app *application) trackSession(r *http.Request) (bool, error) {
+	session, err := app.sessions.Get(r.Context(), app.sessionManager.Token(r.Context()))
+	if errors.Is(err, models.ErrNoRecord) {
+		app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	if !session.Remember && (time.Since(session.Created) > app.config.sessionLifetime || time.Since(session.LastSeen) > app.config.sessionIdleTimeout) {
+		if err := app.sessions.Remove(r.Context(), session.Token); err != nil {
+			return false, err
+		}
+		app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+		app.sessionManager.Put(r.Context(), "flash", "flash.session_expired")
+		return false, nil
+	}
+	if time.Since(session.LastSeen) > sessionTouchInterval || session.IP != clientIP(r) || session.UserAgent != r.UserAgent() {
+		if err := app.sessions.Touch(r.Context(), session.ID, clientIP(r), r.UserAgent()); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// account shows the logged in user's sessions, with buttons to revoke them.
+func (app *application) account(w http.ResponseWriter, r *http.Request) {
+	sessions, err := app.sessions.List(r.Context(), app.authenticatedUser(r).ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	data := app.NewTemplateData(r)
+	data.Sessions = sessions
+	token := app.sessionManager.Token(r.Context())
+	for _, s := range sessions {
+		if s.Token == token {
+			data.CurrentSession = s.ID
+		}
+	}
+	app.render(w, r, http.StatusOK, "account.html", data)
+}
+
+// accountSessionRevoke logs one of the user's other sessions out, e.g. on a lost phone. The current session is logged out with the logout button instead.
+func (app *application) accountSessionRevoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	id, err := strconv.Atoi(r.PostForm.Get("id"))
+	if err != nil || id < 1 {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	userID := app.authenticatedUser(r).ID
+	current, err := app.sessions.Get(r.Context(), app.sessionManager.Token(r.Context()))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if id == current.ID {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	token, err := app.sessions.Revoke(r.Context(), userID, id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	if err := app.revokeSessions([]string{token}); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.audit(r, models.AuditSessionRevoke, userID, fmt.Sprintf("session %d", id))
+	app.sessionManager.Put(r.Context(), "flash", "flash.session_revoked")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// accountSessionRevokeOthers logs out all of the user's sessions except the current one.
+func (app *application) accountSessionRevokeOthers(w http.ResponseWriter, r *http.Request) {
+	userID := app.authenticatedUser(r).ID
+	n, err := app.revokeOtherSessions(r, userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.audit(r, models.AuditSessionRevoke, userID, fmt.Sprintf("%d other sessions", n))
+	app.sessionManager.Put(r.Context(), "flash", "flash.other_sessions_revoked")
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+}
+
+// revokeOtherSessions logs out all of a user's sessions except the request's, and returns how many there were.
+func (app *application) revokeOtherSessions(r *http.Request, userID int) (int, error) {
+	tokens, err := app.sessions.RevokeOthers(r.Context(), userID, app.sessionManager.Token(r.Context()))
+	if err != nil {
+		return 0, err
+	}
+	return len(tokens), app.revokeSessions(tokens)
+}
+
+// requireRecentAuth is middleware for sensitive actions, such as changing the password. If the user last entered their password more than -reauth-after ago, they are sent to enter it again first, and then brought back.
+// It goes after requireAuthentication in a chain.
+func (app *application) requireRecentAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// authenticatedAt is a Unix time, which the session codec can store without registering time.Time with gob
+		authenticatedAt := time.Unix(app.sessionManager.GetInt64(r.Context(), "authenticatedAt"), 0)
+		if time.Since(authenticatedAt) > app.config.reauthAfter {
+			// a POST's form is lost, so come back to the page rather than the request
+			http.Redirect(w, r, "/user/confirm?next="+url.QueryEscape(r.URL.Path), http.StatusSeeOther)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// userConfirmForm asks a logged in user for their password again. Next is where to go once it is confirmed.
+type userConfirmForm struct {
+	Password            string `form:"password" validate:"required"`
+	Next                string `form:"next"`
+	validator.Validator `form:"-"`
+}
+
+// userConfirm displays the password confirmation form that requireRecentAuth sends users to.
+func (app *application) userConfirm(w http.ResponseWriter, r *http.Request) {
+	data := app.NewTemplateData(r)
+	data.Form = userConfirmForm{Next: localRedirect(r.URL.Query().Get("next"))}
+	app.render(w, r, http.StatusOK, "confirm.html", data)
+}
+
+// userConfirmPost checks the password and, if it is right, counts the user as just authenticated.
+func (app *application) userConfirmPost(w http.ResponseWriter, r *http.Request) {
+	var form userConfirmForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	form.Validate(form)
+	form.Next = localRedirect(form.Next)
+
+	user := app.authenticatedUser(r)
+	if form.Valid() {
+		// check the password with the same rules as logging in, so a disabled account can't be confirmed
+		id, err := app.users.Authenticate(r.Context(), user.Email, form.Password)
+		switch {
+		case errors.Is(err, models.ErrInvalidCredentials), err == nil && id != user.ID:
+			app.audit(r, models.AuditLoginFailed, user.ID, user.Email+" (password confirmation)")
+			form.AddFieldError("password", validator.Msg("validation.wrong_password"))
+		case err != nil:
+			app.serverError(w, r, err)
+			return
+		}
+	}
+	if !form.Valid() {
+		form.Password = ""
+		data := app.NewTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "confirm.html", data)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "authenticatedAt", time.Now().Unix())
+	app.audit(r, models.AuditReauth, user.ID, "")
+	http.Redirect(w, r, form.Next, http.StatusSeeOther)
+}
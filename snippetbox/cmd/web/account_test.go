@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"snippetbox.audryhsu.com/internal/assert"
+	"snippetbox.audryhsu.com/internal/models"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loggedIn reports whether the test server's client is logged in.
+func (ts *testServer) loggedIn(t *testing.T) bool {
+	code, _, _ := ts.get(t, "/snippet/create")
+	return code == http.StatusOK
+}
+
+// userSessions returns the sessions tracked for the mock user, newest first.
+func userSessions(t *testing.T, app *application) []*models.Session {
+	t.Helper()
+	sessions, err := app.sessions.List(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sessions
+}
+
+func TestAccountSessions(t *testing.T) {
+	app := newTestApplication(t)
+	laptop := newTestServer(t, app.routes())
+	defer laptop.Close()
+	phone := laptop.newClient(t)
+
+	laptop.login(t)
+	phone.login(t)
+	sessions := userSessions(t, app)
+	assert.Equal(t, len(sessions), 2)
+	phoneSession, laptopSession := sessions[0].ID, sessions[1].ID
+	assert.Equal(t, sessions[0].IP, "127.0.0.1")
+
+	code, _, body := laptop.get(t, "/account")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<strong>This session</strong>")
+	assert.StringContains(t, body, "<input type='hidden' name='id' value='"+strconv.Itoa(phoneSession)+"'>")
+	assert.Equal(t, strings.Contains(body, "<input type='hidden' name='id' value='"+strconv.Itoa(laptopSession)+"'>"), false)
+	assert.StringContains(t, body, "Sign out all other sessions")
+	csrfToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name     string
+		id       string
+		wantCode int
+	}{
+		{name: "Not a number", id: "abc", wantCode: http.StatusBadRequest},
+		{name: "Current session", id: strconv.Itoa(laptopSession), wantCode: http.StatusBadRequest},
+		{name: "Someone else's session", id: "99", wantCode: http.StatusNotFound},
+		{name: "Other session", id: strconv.Itoa(phoneSession), wantCode: http.StatusSeeOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, _ := laptop.postForm(t, "/account/sessions/revoke", url.Values{"id": {tt.id}, "csrf_token": {csrfToken}})
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+
+	assert.Equal(t, phone.loggedIn(t), false)
+	assert.Equal(t, laptop.loggedIn(t), true)
+	assert.Equal(t, len(userSessions(t, app)), 1)
+
+	// sign out everywhere else
+	phone.login(t)
+	tablet := laptop.newClient(t)
+	tablet.login(t)
+	code, header, _ := laptop.postForm(t, "/account/sessions/revoke-others", url.Values{"csrf_token": {csrfToken}})
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/account")
+	assert.Equal(t, phone.loggedIn(t), false)
+	assert.Equal(t, tablet.loggedIn(t), false)
+	assert.Equal(t, laptop.loggedIn(t), true)
+	sessions = userSessions(t, app)
+	assert.Equal(t, len(sessions), 1)
+	assert.Equal(t, sessions[0].ID, laptopSession)
+
+	events := auditEvents(t, app)
+	assert.Equal(t, events[len(events)-1].Action, models.AuditSessionRevoke)
+	assert.Equal(t, events[len(events)-1].Details, "2 other sessions")
+}
+
+func TestLogoutEndsTracking(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t)
+	assert.Equal(t, len(userSessions(t, app)), 1)
+	_, _, body := ts.get(t, "/")
+	code, _, _ := ts.postForm(t, "/user/logout", url.Values{"csrf_token": {extractCSRFToken(t, body)}})
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, len(userSessions(t, app)), 0)
+}
+
+// TestUntrackedSessionLoggedOut checks that a session isn't accepted once it stops being tracked, even if it is still in the session store.
+func TestUntrackedSessionLoggedOut(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t)
+	assert.Equal(t, ts.loggedIn(t), true)
+	if _, err := app.sessions.RevokeOthers(context.Background(), 1, ""); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ts.loggedIn(t), false)
+}
+
+func TestPasswordChangeRevokesSessions(t *testing.T) {
+	app := newTestApplication(t)
+	laptop := newTestServer(t, app.routes())
+	defer laptop.Close()
+	phone := laptop.newClient(t)
+
+	laptop.login(t)
+	phone.login(t)
+	before := userSessions(t, app)
+
+	_, _, body := laptop.get(t, "/account/password")
+	form := url.Values{"new_password": {"n3w b4ttery staple"}, "confirmation": {"n3w b4ttery staple"}, "csrf_token": {extractCSRFToken(t, body)}}
+	code, _, _ := laptop.postForm(t, "/account/password", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	assert.Equal(t, phone.loggedIn(t), false)
+	assert.Equal(t, laptop.loggedIn(t), true)
+	// the laptop carries on in a new session
+	after := userSessions(t, app)
+	assert.Equal(t, len(after), 1)
+	for _, s := range before {
+		assert.Equal(t, after[0].Token != s.Token, true)
+	}
+
+	events := auditEvents(t, app)
+	assert.Equal(t, events[len(events)-1].Action, models.AuditPasswordChange)
+	assert.Equal(t, events[len(events)-1].Details, "1 other sessions signed out")
+}
+
+// loginWith logs the test server's client in like login, ticking "remember me" or not, and returns the session cookie it was given.
+func (ts *testServer) loginWith(t *testing.T, remember bool) *http.Cookie {
+	_, _, body := ts.get(t, "/user/login")
+	form := url.Values{"email": {"alice@example.com"}, "password": {"pa$$word"}, "csrf_token": {extractCSRFToken(t, body)}}
+	if remember {
+		form.Set("remember", "true")
+	}
+	code, header, _ := ts.postForm(t, "/user/login", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("login failed with status %d", code)
+	}
+	for _, c := range (&http.Response{Header: header}).Cookies() {
+		if c.Name == "session" {
+			return c
+		}
+	}
+	t.Fatal("no session cookie set on login")
+	return nil
+}
+
+func TestRememberMe(t *testing.T) {
+	app := newTestApplication(t)
+	laptop := newTestServer(t, app.routes())
+	defer laptop.Close()
+	library := laptop.newClient(t)
+
+	// only "remember me" logins outlive the browser
+	cookie := laptop.loginWith(t, true)
+	assert.Equal(t, cookie.Expires.After(time.Now().Add(29*24*time.Hour)), true)
+	cookie = library.loginWith(t, false)
+	assert.Equal(t, cookie.Expires.IsZero(), true)
+	assert.Equal(t, cookie.MaxAge, 0)
+
+	// the library's login times out when left alone; the laptop's doesn't
+	app.config.sessionIdleTimeout = time.Nanosecond
+	assert.Equal(t, library.loggedIn(t), false)
+	_, _, body := library.get(t, "/")
+	assert.StringContains(t, body, "Your session has expired, please log in again")
+	assert.Equal(t, laptop.loggedIn(t), true)
+	assert.Equal(t, len(userSessions(t, app)), 1)
+
+	// and so does a login that has lasted for -session-lifetime
+	app.config.sessionIdleTimeout = time.Hour
+	library.loginWith(t, false)
+	assert.Equal(t, library.loggedIn(t), true)
+	app.config.sessionLifetime = time.Nanosecond
+	assert.Equal(t, library.loggedIn(t), false)
+	assert.Equal(t, laptop.loggedIn(t), true)
+}
+
+func TestRequireRecentAuth(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	app.config.reauthAfter = 2 * time.Second
+
+	ts.login(t)
+	code, _, body := ts.get(t, "/account/password")
+	assert.Equal(t, code, http.StatusOK)
+	csrfToken := extractCSRFToken(t, body)
+
+	time.Sleep(2100 * time.Millisecond)
+	for _, send := range []func() (int, http.Header, string){
+		func() (int, http.Header, string) { return ts.get(t, "/account/password") },
+		func() (int, http.Header, string) {
+			return ts.postForm(t, "/account/password", url.Values{"new_password": {"n3w b4ttery staple"}, "confirmation": {"n3w b4ttery staple"}, "csrf_token": {csrfToken}})
+		},
+	} {
+		code, header, _ := send()
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, header.Get("Location"), "/user/confirm?next=%2Faccount%2Fpassword")
+	}
+
+	code, _, body = ts.get(t, "/user/confirm?next=%2Faccount%2Fpassword")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "<input type='hidden' name='next' value='/account/password'>")
+
+	tests := []struct {
+		name         string
+		password     string
+		next         string
+		wantCode     int
+		wantLocation string
+	}{
+		{name: "Wrong password", password: "wrong", next: "/account/password", wantCode: http.StatusUnprocessableEntity},
+		{name: "Offsite next", password: "pa$$word", next: "https://evil.example/", wantCode: http.StatusSeeOther, wantLocation: "/"},
+		{name: "Valid", password: "pa$$word", next: "/account/password", wantCode: http.StatusSeeOther, wantLocation: "/account/password"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"password": {tt.password}, "next": {tt.next}, "csrf_token": {csrfToken}}
+			code, header, _ := ts.postForm(t, "/user/confirm", form)
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+		})
+	}
+
+	code, _, _ = ts.get(t, "/account/password")
+	assert.Equal(t, code, http.StatusOK)
+	events := auditEvents(t, app)
+	assert.Equal(t, events[len(events)-1].Action, models.AuditReauth)
+	assert.Equal(t, events[len(events)-3].Action, models.AuditLoginFailed)
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"snippetbox.audryhsu.com/internal/models"
+	"snippetbox.audryhsu.com/internal/validator"
+	"strconv"
+)
+
+// adminUsersLimit is the most users the admin user list shows at once; narrow it down with a search.
+const adminUsersLimit = 50
+
+// adminDashboard shows the site statistics. Moderators and admins can see it.
+func (app *application) adminDashboard(w http.ResponseWriter, r *http.Request) {
+	userCounts, err := app.users.Counts(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	snippetCounts, err := app.snippets.Counts(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	queueLength, err := app.moderation.QueueLength(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.NewTemplateData(r)
+	data.Stats = &siteStats{Users: userCounts, Snippets: snippetCounts, Reported: queueLength}
+	if cache, ok := app.snippets.(*models.CachedSnippetModel); ok {
+		stats := cache.Stats()
+		data.Stats.Cache = &stats
+	}
+	app.render(w, r, http.StatusOK, "admin.html", data)
+}
+
+// adminUsers lists the users whose name or email contains the "q" query parameter, or every user without one. Admins only.
+func (app *application) adminUsers(w http.ResponseWriter, r *http.Request) {
+	search := r.URL.Query().Get("q")
+	// ask for one extra user to find out whether the list goes on
+	users, err := app.users.List(r.Context(), search, adminUsersLimit+1)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.NewTemplateData(r)
+	if len(users) > adminUsersLimit {
+		users = users[:adminUsersLimit]
+		data.MoreUsers = true
+	}
+	data.Users = users
+	data.Search = search
+	app.render(w, r, http.StatusOK, "admin_users.html", data)
+}
+
+// adminUserForm holds the changes an admin can make to another user's account on the user list.
+type adminUserForm struct {
+	Role                string `form:"role" validate:"oneof=user moderator admin"`
+	Disabled            bool   `form:"disabled"`
+	validator.Validator `form:"-"`
+}
+
+// adminUserUpdate changes a user's role and disables or re-enables their account, recording each change in the audit log. Admins only.
+// Admins can't change their own account, so the site can't be left without one by accident.
+func (app *application) adminUserUpdate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(httprouter.ParamsFromContext(r.Context()).ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+	if user := app.authenticatedUser(r); user != nil && user.ID == id {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	var form adminUserForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	// the form is a row of the user list rather than a page of its own, so there is nowhere to re-render errors
+	form.Validate(form)
+	if !form.Valid() {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	// the account as it was, to audit what changes
+	user, err := app.users.Get(r.Context(), id)
+	if err == nil {
+		err = app.users.SetAccess(r.Context(), id, models.Role(form.Role), form.Disabled)
+	}
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	admin := app.authenticatedUser(r)
+	for _, event := range accessChangeEvents(user, models.Role(form.Role), form.Disabled) {
+		app.audit(r, event.Action, admin.ID, event.Details)
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "flash.user_updated")
+	http.Redirect(w, r, localRedirect(r.Referer()), http.StatusSeeOther)
+}
+
+// moderationLimit is the most snippets the moderation queue, and the most decisions the audit trail, show at once.
+const moderationLimit = 50
+
+// adminModeration shows the snippets waiting for a moderator's decision, oldest report first, and the latest decisions. Moderators and admins can see it.
+func (app *application) adminModeration(w http.ResponseWriter, r *http.Request) {
+	queue, err := app.moderation.Queue(r.Context(), moderationLimit)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	decisions, err := app.moderation.Decisions(r.Context(), moderationLimit)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.NewTemplateData(r)
+	data.Queue = queue
+	data.Decisions = decisions
+	app.render(w, r, http.StatusOK, "admin_moderation.html", data)
+}
+
+// moderationForm is a moderator's decision on a snippet in the moderation queue.
+type moderationForm struct {
+	Action              string `form:"action" validate:"oneof=hide delete dismiss"`
+	validator.Validator `form:"-"`
+}
+
+// moderationFlash is the flash message shown after each decision.
+var moderationFlash = map[models.ModerationAction]string{
+	models.ActionHide:    "flash.snippet_hidden",
+	models.ActionDelete:  "flash.snippet_deleted",
+	models.ActionDismiss: "flash.reports_dismissed",
+}
+
+// adminSnippetModerate hides, deletes or dismisses the reports about a snippet. Moderators and admins can do it from the moderation queue.
+func (app *application) adminSnippetModerate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(httprouter.ParamsFromContext(r.Context()).ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	var form moderationForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	// the buttons are the whole form, so there are no errors to show
+	form.Validate(form)
+	if !form.Valid() {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	action := models.ModerationAction(form.Action)
+	if !app.moderate(w, r, id, action) {
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", moderationFlash[action])
+	http.Redirect(w, r, "/admin/moderation", http.StatusSeeOther)
+}
+
+// adminSnippetDelete deletes any snippet, expired or not. Moderators and admins can do it from the snippet's page.
+func (app *application) adminSnippetDelete(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(httprouter.ParamsFromContext(r.Context()).ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	if !app.moderate(w, r, id, models.ActionDelete) {
+		return
+	}
+	app.sessionManager.Put(r.Context(), "flash", moderationFlash[models.ActionDelete])
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// moderate carries out the logged in moderator's decision on snippet id, which also resolves its reports and goes into the audit trail.
+// It writes the error response and returns false if the decision couldn't be made.
+func (app *application) moderate(w http.ResponseWriter, r *http.Request, id int, action models.ModerationAction) bool {
+	if err := app.moderation.Decide(r.Context(), id, app.authenticatedUser(r).ID, action); err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return false
+	}
+	// the decision changed the snippet behind the cache's back
+	if cache, ok := app.snippets.(*models.CachedSnippetModel); ok {
+		cache.Invalidate(id)
+	}
+	if action == models.ActionDelete {
+		app.audit(r, models.AuditSnippetDelete, app.authenticatedUser(r).ID, fmt.Sprintf("snippet %d", id))
+	}
+	return true
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"snippetbox.audryhsu.com/internal/assert"
+	"snippetbox.audryhsu.com/internal/models"
+	"snippetbox.audryhsu.com/internal/password"
+	"testing"
+)
+
+// TestAdminAccess checks which roles can reach each part of the admin area.
+func TestAdminAccess(t *testing.T) {
+	tests := []struct {
+		name     string
+		role     models.Role // empty means not logged in
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{name: "Anonymous dashboard", urlPath: "/admin", wantCode: http.StatusSeeOther},
+		{name: "User dashboard", role: models.RoleUser, urlPath: "/admin", wantCode: http.StatusForbidden, wantBody: "You don&#39;t have permission to do that."},
+		{name: "Moderator dashboard", role: models.RoleModerator, urlPath: "/admin", wantCode: http.StatusOK, wantBody: "<h2>Site statistics</h2>"},
+		{name: "Moderator users", role: models.RoleModerator, urlPath: "/admin/users", wantCode: http.StatusForbidden},
+		{name: "Admin dashboard", role: models.RoleAdmin, urlPath: "/admin", wantCode: http.StatusOK, wantBody: "<a href='/admin/users'>Manage users</a>"},
+		{name: "Admin users", role: models.RoleAdmin, urlPath: "/admin/users", wantCode: http.StatusOK, wantBody: "alice@example.com"},
+		{name: "Admin search", role: models.RoleAdmin, urlPath: "/admin/users?q=bob", wantCode: http.StatusOK, wantBody: "No users found."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			if tt.role != "" {
+				if err := app.users.SetRole(context.Background(), 1, tt.role); err != nil {
+					t.Fatal(err)
+				}
+				ts.login(t)
+			}
+
+			code, header, body := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.role == "" {
+				assert.Equal(t, header.Get("Location"), "/user/login")
+			}
+			assert.StringContains(t, body, tt.wantBody)
+		})
+	}
+}
+
+// TestRoleCheckedEachRequest checks that role changes and disabled accounts take effect on the next request of a session that is already logged in.
+func TestRoleCheckedEachRequest(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ctx := context.Background()
+
+	app.users.SetRole(ctx, 1, models.RoleAdmin)
+	ts.login(t)
+	code, _, body := ts.get(t, "/admin")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, `<a href="/admin">Admin</a>`)
+
+	app.users.SetRole(ctx, 1, models.RoleUser)
+	code, _, _ = ts.get(t, "/admin")
+	assert.Equal(t, code, http.StatusForbidden)
+
+	app.users.SetDisabled(ctx, 1, true)
+	code, header, _ := ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+}
+
+func TestLoginDisabledAccount(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	app.users.SetDisabled(context.Background(), 1, true)
+
+	_, _, body := ts.get(t, "/user/login")
+	form := url.Values{}
+	form.Add("email", "alice@example.com")
+	form.Add("password", "pa$$word")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, body := ts.postForm(t, "/user/login", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.StringContains(t, body, "This account has been disabled")
+}
+
+func TestAdminSnippetDelete(t *testing.T) {
+	tests := []struct {
+		name         string
+		role         models.Role
+		urlPath      string
+		wantCode     int
+		wantLocation string
+	}{
+		{name: "User", role: models.RoleUser, urlPath: "/admin/snippets/1/delete", wantCode: http.StatusForbidden},
+		{name: "Moderator", role: models.RoleModerator, urlPath: "/admin/snippets/1/delete", wantCode: http.StatusSeeOther, wantLocation: "/"},
+		{name: "Missing snippet", role: models.RoleModerator, urlPath: "/admin/snippets/99/delete", wantCode: http.StatusNotFound},
+		{name: "Bad id", role: models.RoleAdmin, urlPath: "/admin/snippets/abc/delete", wantCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+			app.users.SetRole(context.Background(), 1, tt.role)
+			ts.login(t)
+
+			// the delete button is only on the snippet page for moderators
+			_, _, body := ts.get(t, "/snippet/view/1")
+			form := url.Values{}
+			form.Add("csrf_token", extractCSRFToken(t, body))
+			code, header, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+		})
+	}
+}
+
+func TestAdminUserUpdate(t *testing.T) {
+	tests := []struct {
+		name     string
+		urlPath  string
+		role     string
+		wantCode int
+	}{
+		{name: "Own account", urlPath: "/admin/users/1", role: "user", wantCode: http.StatusBadRequest},
+		{name: "Unknown role", urlPath: "/admin/users/2", role: "superuser", wantCode: http.StatusBadRequest},
+		{name: "Missing user", urlPath: "/admin/users/2", role: "moderator", wantCode: http.StatusNotFound},
+	}
+
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	app.users.SetRole(context.Background(), 1, models.RoleAdmin)
+	ts.login(t)
+	_, _, body := ts.get(t, "/admin/users")
+	csrfToken := extractCSRFToken(t, body)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("role", tt.role)
+			form.Add("disabled", "true")
+			form.Add("csrf_token", csrfToken)
+			code, _, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+		})
+	}
+
+	// the admin's own account was left alone
+	user, err := app.users.Get(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Role, models.RoleAdmin)
+	assert.Equal(t, user.Disabled, false)
+
+	// changes to another account are audited, one event for each
+	if err := app.users.Insert(context.Background(), "Bob", "bob@example.com", "pa$$word"); err != nil {
+		t.Fatal(err)
+	}
+	before := len(auditEvents(t, app))
+	form := url.Values{"role": {"moderator"}, "disabled": {"true"}, "csrf_token": {csrfToken}}
+	code, _, _ := ts.postForm(t, "/admin/users/2", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	events := auditEvents(t, app)[before:]
+	assert.Equal(t, len(events), 2)
+	assert.Equal(t, events[0].Action, models.AuditRoleChange)
+	assert.Equal(t, events[0].UserID, 1)
+	assert.Equal(t, events[0].Details, "user 2 (bob@example.com): user to moderator")
+	assert.Equal(t, events[1].Action, models.AuditUserDisable)
+	assert.Equal(t, events[1].Details, "user 2 (bob@example.com)")
+
+	// saving the form unchanged audits nothing
+	code, _, _ = ts.postForm(t, "/admin/users/2", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, len(auditEvents(t, app)), before+2)
+}
+
+// TestModeration follows a snippet from a user's report through the moderation queue to a moderator's decision.
+func TestModeration(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ts.login(t)
+
+	_, _, body := ts.get(t, "/snippet/view/1")
+	csrfToken := extractCSRFToken(t, body)
+	form := url.Values{"reason": {"secret"}, "details": {"An AWS key"}, "csrf_token": {csrfToken}}
+	code, _, _ := ts.postForm(t, "/snippet/report/1", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	// only moderators can see the queue and decide
+	code, _, _ = ts.get(t, "/admin/moderation")
+	assert.Equal(t, code, http.StatusForbidden)
+	code, _, _ = ts.postForm(t, "/admin/snippets/1/moderate", url.Values{"action": {"hide"}, "csrf_token": {csrfToken}})
+	assert.Equal(t, code, http.StatusForbidden)
+
+	app.users.SetRole(context.Background(), 1, models.RoleModerator)
+	code, _, body = ts.get(t, "/admin/moderation")
+	assert.Equal(t, code, http.StatusOK)
+	assert.StringContains(t, body, "Contains a password or other secret")
+	assert.StringContains(t, body, "An AWS key")
+	assert.StringContains(t, body, "<form action='/admin/snippets/1/moderate' method='POST' class='moderate'>")
+	assert.StringContains(t, body, "No decisions yet.")
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		action       string
+		wantCode     int
+		wantLocation string
+	}{
+		{name: "Unknown action", urlPath: "/admin/snippets/1/moderate", action: "ban", wantCode: http.StatusBadRequest},
+		{name: "Missing snippet", urlPath: "/admin/snippets/99/moderate", action: "hide", wantCode: http.StatusNotFound},
+		{name: "Hide", urlPath: "/admin/snippets/1/moderate", action: "hide", wantCode: http.StatusSeeOther, wantLocation: "/admin/moderation"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"action": {tt.action}, "csrf_token": {csrfToken}}
+			code, header, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+		})
+	}
+
+	// the report is resolved and the decision is in the audit trail
+	_, _, body = ts.get(t, "/admin/moderation")
+	assert.StringContains(t, body, "Snippet hidden")
+	assert.StringContains(t, body, "Nothing to moderate.")
+	assert.StringContains(t, body, "<td>Hidden</td>")
+	assert.StringContains(t, body, "#1 An old silent pond")
+}
+
+// TestRunUser checks that the "snippetbox user" command audits the changes it makes, against a real SQLite database.
+func TestRunUser(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.dbDriver = "sqlite"
+	cfg.dsn = "file:" + filepath.Join(t.TempDir(), "test.db") + "?_pragma=foreign_keys(1)&_time_format=sqlite"
+
+	db, err := openDB(models.SQLite, cfg.dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := autoMigrate(db, models.SQLite, log.New(io.Discard, "", 0)); err != nil {
+		t.Fatal(err)
+	}
+	store := models.Store{DB: db, Dialect: models.SQLite}
+	users := &models.UserModel{Store: store, Hasher: &password.Hasher{Target: password.Bcrypt{Cost: bcrypt.MinCost}}}
+	if err := users.Insert(context.Background(), "Alice", "alice@example.com", "pa$$word"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{{"role", "alice@example.com", "admin"}, {"role", "alice@example.com", "admin"}, {"disable", "alice@example.com"}, {"enable", "alice@example.com"}} {
+		cfg.args = args
+		if err := runUser(cfg, io.Discard); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := (&models.AuditModel{Store: store}).List(context.Background(), models.AuditFilter{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// newest first; making Alice an admin twice is only one change
+	assert.Equal(t, len(events), 3)
+	assert.Equal(t, events[2].Action, models.AuditRoleChange)
+	assert.Equal(t, events[2].UserID, 0)
+	assert.Equal(t, events[2].Details, "user 1 (alice@example.com): user to admin (command line)")
+	assert.Equal(t, events[1].Action, models.AuditUserDisable)
+	assert.Equal(t, events[0].Action, models.AuditUserEnable)
+}
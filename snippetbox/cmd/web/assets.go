@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// staticRoot is the folder of the ui files that is served under /static/
+const staticRoot = "static"
+
+// immutableCacheControl is sent with fingerprinted URLs: their content can never change, so browsers may keep them for a year without revalidating.
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// asset is one file in ui/static.
+type asset struct {
+	name       string // path below static/, e.g. "css/main.css"
+	hashedName string // name with a content hash before the extension, e.g. "css/main.3f2a9c1b0d4e.css"
+	hash       string
+	// gzipped is the gzip-compressed content, computed at start-up for compressible files without a precompressed .gz next to them
+	gzipped []byte
+}
+
+// assetManifest maps the files in ui/static to fingerprinted URLs, so they can be cached forever and still update as soon as they change.
+type assetManifest struct {
+	fsys     fs.FS
+	byName   map[string]*asset
+	byHashed map[string]*asset
+	// dev disables fingerprinting, because in -dev mode the files on disk change while the server is running
+	dev bool
+	// notFound answers requests for missing files and directories. Nil means http.NotFound.
+	notFound http.Handler
+}
+
+// newAssetManifest hashes every file in the static folder of fsys. Files ending in .gz or .br are precompressed variants and are not assets in their own right.
+func newAssetManifest(fsys fs.FS, dev bool) (*assetManifest, error) {
+	m := &assetManifest{fsys: fsys, byName: map[string]*asset{}, byHashed: map[string]*asset{}, dev: dev}
+	if dev {
+		return m, nil
+	}
+
+	err := fs.WalkDir(fsys, staticRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(p, ".gz") || strings.HasSuffix(p, ".br") {
+			return err
+		}
+		content, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(content)
+		name := strings.TrimPrefix(p, staticRoot+"/")
+		ext := path.Ext(name)
+		a := &asset{name: name, hash: hex.EncodeToString(sum[:6])}
+		a.hashedName = strings.TrimSuffix(name, ext) + "." + a.hash + ext
+
+		if _, err := fs.Stat(fsys, p+".gz"); err != nil && isCompressible(ext) {
+			if a.gzipped, err = gzipBytes(content); err != nil {
+				return err
+			}
+			// not worth it if compression doesn't make the file smaller
+			if len(a.gzipped) >= len(content) {
+				a.gzipped = nil
+			}
+		}
+
+		m.byName[a.name] = a
+		m.byHashed[a.hashedName] = a
+		return nil
+	})
+	return m, err
+}
+
+// URL returns the URL of a static file, e.g. asset "css/main.css" => /static/css/main.3f2a9c1b0d4e.css. It is available in templates as the "asset" function. Unknown files, and every file in -dev mode, get their plain URL.
+func (m *assetManifest) URL(name string) string {
+	if m != nil && !m.dev {
+		if a, ok := m.byName[name]; ok {
+			return "/" + staticRoot + "/" + a.hashedName
+		}
+	}
+	return "/" + staticRoot + "/" + name
+}
+
+// withNotFound returns a copy of the manifest that answers requests for missing files and directories with notFound, e.g. the site's error page.
+func (m *assetManifest) withNotFound(notFound http.Handler) *assetManifest {
+	c := *m
+	c.notFound = notFound
+	return &c
+}
+
+// ServeHTTP serves /static/*filepath. Fingerprinted paths are cached forever, plain paths must be revalidated, and a precompressed variant is sent when the client accepts it. Directories are never listed.
+func (m *assetManifest) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/"+staticRoot+"/")
+
+	cacheControl := "no-cache"
+	var a *asset
+	if hashed, ok := m.byHashed[name]; ok {
+		a, name, cacheControl = hashed, hashed.name, immutableCacheControl
+	} else {
+		a = m.byName[name]
+	}
+
+	p := path.Join(staticRoot, name)
+	info, err := fs.Stat(m.fsys, p)
+	if err != nil || info.IsDir() || strings.HasSuffix(name, ".gz") || strings.HasSuffix(name, ".br") {
+		m.serveNotFound(w, r)
+		return
+	}
+
+	content, encoding, err := m.variant(a, p, r.Header.Get("Accept-Encoding"))
+	if err != nil {
+		m.serveNotFound(w, r)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Cache-Control", cacheControl)
+	h.Add("Vary", "Accept-Encoding")
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		h.Set("Content-Type", contentType)
+	}
+	if encoding != "" {
+		h.Set("Content-Encoding", encoding)
+	}
+	if a != nil {
+		// each encoding is a different representation, so it needs its own ETag
+		etag := a.hash
+		if encoding != "" {
+			etag += "-" + encoding
+		}
+		h.Set("ETag", `"`+etag+`"`)
+	}
+
+	// ServeContent handles Range and conditional requests; the modification time is only meaningful for files on disk
+	modTime := time.Time{}
+	if a == nil {
+		modTime = info.ModTime()
+	}
+	http.ServeContent(w, r, name, modTime, bytes.NewReader(content))
+}
+
+// serveNotFound answers a request for a missing file or a directory.
+func (m *assetManifest) serveNotFound(w http.ResponseWriter, r *http.Request) {
+	if m.notFound == nil {
+		http.NotFound(w, r)
+		return
+	}
+	m.notFound.ServeHTTP(w, r)
+}
+
+// variant picks the best encoding of a file for the client: a precompressed .br file, then a precompressed .gz file or the gzip variant computed at start-up, then the file itself.
+func (m *assetManifest) variant(a *asset, p, acceptEncoding string) ([]byte, string, error) {
+	if accepts(acceptEncoding, "br") {
+		if content, err := fs.ReadFile(m.fsys, p+".br"); err == nil {
+			return content, "br", nil
+		}
+	}
+	if accepts(acceptEncoding, "gzip") {
+		if content, err := fs.ReadFile(m.fsys, p+".gz"); err == nil {
+			return content, "gzip", nil
+		}
+		if a != nil && a.gzipped != nil {
+			return a.gzipped, "gzip", nil
+		}
+	}
+	content, err := fs.ReadFile(m.fsys, p)
+	return content, "", err
+}
+
+// accepts reports whether an Accept-Encoding header allows the given coding.
+func accepts(acceptEncoding, coding string) bool {
+	return acceptEncodingQ(acceptEncoding, coding) > 0
+}
+
+// isCompressible reports whether files with this extension are text, which is worth compressing (images and fonts already are compressed).
+func isCompressible(ext string) bool {
+	switch ext {
+	case ".css", ".js", ".html", ".svg", ".json", ".txt", ".xml", ".ico", ".map":
+		return true
+	}
+	return false
+}
+
+// gzipBytes compresses b with the best gzip compression, since it only happens once at start-up.
+func gzipBytes(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := zw.Write(b); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
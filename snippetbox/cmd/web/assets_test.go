@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"snippetbox.audryhsu.com/internal/assert"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestAssetURL(t *testing.T) {
+	css := strings.Repeat("body { color: red; }\n", 50)
+	fsys := fstest.MapFS{"static/css/main.css": {Data: []byte(css)}}
+
+	m, err := newAssetManifest(fsys, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed := m.URL("css/main.css")
+	assert.StringContains(t, hashed, "/static/css/main.")
+	if hashed == "/static/css/main.css" || !strings.HasSuffix(hashed, ".css") {
+		t.Errorf("got %q; want a fingerprinted URL", hashed)
+	}
+
+	// changing the content changes the URL
+	fsys["static/css/main.css"] = &fstest.MapFile{Data: []byte(css + "p {}\n")}
+	changed, err := newAssetManifest(fsys, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.URL("css/main.css") == hashed {
+		t.Errorf("URL didn't change with the content: %q", hashed)
+	}
+
+	// unknown files, dev mode and a nil manifest use plain URLs
+	assert.Equal(t, m.URL("js/missing.js"), "/static/js/missing.js")
+	dev, err := newAssetManifest(fsys, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, dev.URL("css/main.css"), "/static/css/main.css")
+	var none *assetManifest
+	assert.Equal(t, none.URL("css/main.css"), "/static/css/main.css")
+}
+
+func TestServeAssets(t *testing.T) {
+	css := strings.Repeat("body { color: red; }\n", 50)
+	fsys := fstest.MapFS{
+		"static/css/main.css":   {Data: []byte(css)},
+		"static/js/main.js":     {Data: []byte("console.log('plain')"), ModTime: time.Now()},
+		"static/js/main.js.br":  {Data: []byte("brotli bytes")},
+		"static/js/main.js.gz":  {Data: []byte("gzip bytes")},
+		"static/img/logo.png":   {Data: []byte("\x89PNG not compressible")},
+		"static/img/other.webp": {Data: []byte("webp")},
+	}
+	m, err := newAssetManifest(fsys, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name             string
+		urlPath          string
+		acceptEncoding   string
+		wantCode         int
+		wantCacheControl string
+		wantEncoding     string
+		wantBody         string
+	}{
+		{
+			name:             "Fingerprinted",
+			urlPath:          m.URL("css/main.css"),
+			wantCode:         http.StatusOK,
+			wantCacheControl: immutableCacheControl,
+			wantBody:         css,
+		},
+		{
+			name:             "Fingerprinted gzip generated at start-up",
+			urlPath:          m.URL("css/main.css"),
+			acceptEncoding:   "gzip, deflate",
+			wantCode:         http.StatusOK,
+			wantCacheControl: immutableCacheControl,
+			wantEncoding:     "gzip",
+			wantBody:         css,
+		},
+		{
+			name:             "Plain path",
+			urlPath:          "/static/css/main.css",
+			wantCode:         http.StatusOK,
+			wantCacheControl: "no-cache",
+			wantBody:         css,
+		},
+		{
+			name:             "Precompressed brotli preferred",
+			urlPath:          m.URL("js/main.js"),
+			acceptEncoding:   "gzip, br",
+			wantCode:         http.StatusOK,
+			wantCacheControl: immutableCacheControl,
+			wantEncoding:     "br",
+			wantBody:         "brotli bytes",
+		},
+		{
+			name:             "Precompressed gzip",
+			urlPath:          m.URL("js/main.js"),
+			acceptEncoding:   "gzip, br;q=0",
+			wantCode:         http.StatusOK,
+			wantCacheControl: immutableCacheControl,
+			wantEncoding:     "gzip",
+			wantBody:         "gzip bytes",
+		},
+		{
+			name:             "Identity only",
+			urlPath:          m.URL("js/main.js"),
+			acceptEncoding:   "identity, *;q=0",
+			wantCode:         http.StatusOK,
+			wantCacheControl: immutableCacheControl,
+			wantBody:         "console.log('plain')",
+		},
+		{
+			name:             "Not compressible",
+			urlPath:          m.URL("img/logo.png"),
+			acceptEncoding:   "gzip",
+			wantCode:         http.StatusOK,
+			wantCacheControl: immutableCacheControl,
+			wantBody:         "\x89PNG not compressible",
+		},
+		{
+			name:     "Directory",
+			urlPath:  "/static/js/",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Root directory",
+			urlPath:  "/static/",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Precompressed variant requested directly",
+			urlPath:  "/static/js/main.js.gz",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Missing",
+			urlPath:  "/static/css/missing.css",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.urlPath, nil)
+			if tt.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rr := httptest.NewRecorder()
+			m.ServeHTTP(rr, req)
+
+			assert.Equal(t, rr.Code, tt.wantCode)
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			assert.Equal(t, rr.Header().Get("Cache-Control"), tt.wantCacheControl)
+			assert.Equal(t, rr.Header().Get("Content-Encoding"), tt.wantEncoding)
+			assert.Equal(t, rr.Header().Get("Vary"), "Accept-Encoding")
+
+			body := rr.Body.Bytes()
+			if tt.wantEncoding == "gzip" && tt.wantBody == css {
+				zr, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if body, err = io.ReadAll(zr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			assert.Equal(t, string(body), tt.wantBody)
+		})
+	}
+}
+
+func TestServeAssetsNotModified(t *testing.T) {
+	fsys := fstest.MapFS{"static/css/main.css": {Data: []byte("body {}")}}
+	m, err := newAssetManifest(fsys, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	m.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, m.URL("css/main.css"), nil))
+	etag := rr.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("no ETag")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, m.URL("css/main.css"), nil)
+	req.Header.Set("If-None-Match", etag)
+	rr = httptest.NewRecorder()
+	m.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, http.StatusNotModified)
+}
+
+func TestStaticAssetsInPage(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/")
+	cssURL := app.assets.URL("css/main.css")
+	assert.StringContains(t, body, "href='"+cssURL+"'")
+	assert.StringContains(t, body, "src='"+app.assets.URL("js/main.js")+"'")
+
+	code, headers, _ := ts.get(t, cssURL)
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, headers.Get("Cache-Control"), immutableCacheControl)
+	assert.StringContains(t, headers.Get("Content-Type"), "text/css")
+
+	// missing files and directories get the site's 404 page, or JSON for clients that ask for it
+	for _, urlPath := range []string{"/static/css/missing.css", "/static/css/"} {
+		code, _, body = ts.get(t, urlPath)
+		assert.Equal(t, code, http.StatusNotFound)
+		assert.StringContains(t, body, "<h2>404 Not Found</h2>")
+	}
+	code, _, body = ts.getWithHeader(t, "/static/css/missing.css", http.Header{"Accept": {"application/json"}})
+	assert.Equal(t, code, http.StatusNotFound)
+	assert.StringContains(t, body, `"status":404`)
+}
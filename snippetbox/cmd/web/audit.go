@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"snippetbox.audryhsu.com/internal/models"
+	"snippetbox.audryhsu.com/internal/validator"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requestIDHeader carries the request id in responses, so a user reporting a problem can quote it and it can be found in the logs and the audit log.
+const requestIDHeader = "X-Request-ID"
+
+const (
+	// auditPageSize is the number of events on each page of the audit log viewer.
+	auditPageSize = 100
+	// auditExportLimit is the most events a single export holds; narrow the filters down for more.
+	auditExportLimit = 10000
+	// auditDateLayout is the format of the viewer's from and to dates.
+	auditDateLayout = "2006-01-02"
+)
+
+// requestID gives each request a random id, sets it as the X-Request-ID response header and stores it in the request context. An X-Request-ID sent by the client is ignored, as anyone can make one up.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		id := hex.EncodeToString(b)
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDContextKey, id)))
+	})
+}
+
+// requestIDFrom returns the id requestID gave the request, or "" if there is none.
+func requestIDFrom(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+// clientIP returns the IP address the request came from, without the port.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// audit records a security-relevant event in the audit log, along with where the request came from. userID is the acting user, or 0 if nobody is logged in.
+// A failed write is logged rather than failing the request: users shouldn't be locked out because the audit log is unavailable.
+func (app *application) audit(r *http.Request, action models.AuditAction, userID int, details string) {
+	err := app.auditLog.Record(r.Context(), &models.AuditEvent{
+		Action:    action,
+		UserID:    userID,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+		RequestID: requestIDFrom(r),
+		Details:   details,
+	})
+	if err != nil {
+		app.errorLog.Printf("audit log: recording %s by user %d (request %s): %s", action, userID, requestIDFrom(r), err)
+	}
+}
+
+// accessChangeEvents returns the audit events for changing user's role to role and their account to disabled or not: one for each that differs from before. The acting user and where the change came from are left for the caller to fill in.
+func accessChangeEvents(user *models.User, role models.Role, disabled bool) []*models.AuditEvent {
+	target := fmt.Sprintf("user %d (%s)", user.ID, user.Email)
+	var events []*models.AuditEvent
+	if user.Role != role {
+		events = append(events, &models.AuditEvent{Action: models.AuditRoleChange, Details: fmt.Sprintf("%s: %s to %s", target, user.Role, role)})
+	}
+	if user.Disabled != disabled {
+		action := models.AuditUserEnable
+		if disabled {
+			action = models.AuditUserDisable
+		}
+		events = append(events, &models.AuditEvent{Action: action, Details: target})
+	}
+	return events
+}
+
+// auditFilterForm holds the audit log viewer's filters, decoded from the query string.
+type auditFilterForm struct {
+	Action              string `form:"action"`
+	User                int    `form:"user" validate:"min=0"`
+	IP                  string `form:"ip" validate:"max=45"`
+	From                string `form:"from"`
+	To                  string `form:"to"`
+	Before              int    `form:"before"`
+	validator.Validator `form:"-"`
+}
+
+// parseAuditFilter decodes and checks the audit log filters in the query string. The to date is inclusive. Invalid filters are reported on the form.
+func (app *application) parseAuditFilter(r *http.Request) (auditFilterForm, models.AuditFilter) {
+	var form auditFilterForm
+	var filter models.AuditFilter
+	if err := app.decode(r.URL.Query(), &form); err != nil {
+		// only a programming error gets here; decoding errors are reported on the form
+		panic(err)
+	}
+	form.Validate(form)
+
+	if form.Action != "" {
+		permitted := make([]string, len(models.AuditActions))
+		for i, action := range models.AuditActions {
+			permitted[i] = string(action)
+		}
+		form.CheckField(validator.PermittedValue(form.Action, permitted...), "action", validator.Msg("validation.permitted", "values", strings.Join(permitted, ", ")))
+	}
+	parseDate := func(field, value string) time.Time {
+		if value == "" {
+			return time.Time{}
+		}
+		t, err := time.Parse(auditDateLayout, value)
+		form.CheckField(err == nil, field, validator.Msg("validation.date"))
+		return t
+	}
+	filter.Since = parseDate("from", form.From)
+	if until := parseDate("to", form.To); !until.IsZero() {
+		filter.Until = until.AddDate(0, 0, 1)
+	}
+
+	filter.Action = models.AuditAction(form.Action)
+	filter.UserID = form.User
+	filter.IP = form.IP
+	filter.Before = form.Before
+	return form, filter
+}
+
+// adminAudit shows the audit log, newest first, a page at a time. Admins only.
+func (app *application) adminAudit(w http.ResponseWriter, r *http.Request) {
+	form, filter := app.parseAuditFilter(r)
+	data := app.NewTemplateData(r)
+	data.Form = form
+	data.AuditActions = models.AuditActions
+	if !form.Valid() {
+		app.render(w, r, http.StatusUnprocessableEntity, "admin_audit.html", data)
+		return
+	}
+
+	// ask for one extra event to find out whether there are older ones
+	filter.Limit = auditPageSize + 1
+	events, err := app.auditLog.List(r.Context(), filter)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// the links keep the filters
+	link := func(path, key, value string) string {
+		query := r.URL.Query()
+		query.Del("before")
+		query.Set(key, value)
+		return path + "?" + query.Encode()
+	}
+	if len(events) > auditPageSize {
+		events = events[:auditPageSize]
+		data.OlderEvents = link("/admin/audit", "before", strconv.Itoa(events[len(events)-1].ID))
+	}
+	data.AuditEvents = events
+	data.AuditExports = map[string]string{
+		"csv":  link("/admin/audit/export", "format", "csv"),
+		"json": link("/admin/audit/export", "format", "json"),
+	}
+	app.render(w, r, http.StatusOK, "admin_audit.html", data)
+}
+
+// adminAuditExport downloads the events matching the viewer's filters as CSV or JSON, chosen with the "format" query parameter. Admins only.
+func (app *application) adminAuditExport(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format != "csv" && format != "json" {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	form, filter := app.parseAuditFilter(r)
+	if !form.Valid() {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	filter.Limit = auditExportLimit
+	events, err := app.auditLog.List(r.Context(), filter)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	filename := "audit-events-" + time.Now().UTC().Format("20060102T150405Z") + "." + format
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+
+	if format == "json" {
+		if events == nil {
+			events = []*models.AuditEvent{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(events); err != nil {
+			app.errorLog.Printf("audit export: %s", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"id", "created", "action", "user_id", "ip", "user_agent", "request_id", "details"})
+	for _, e := range events {
+		cw.Write([]string{
+			strconv.Itoa(e.ID),
+			e.Created.UTC().Format(time.RFC3339),
+			string(e.Action),
+			strconv.Itoa(e.UserID),
+			csvSafe(e.IP),
+			csvSafe(e.UserAgent),
+			e.RequestID,
+			csvSafe(e.Details),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		app.errorLog.Printf("audit export: %s", err)
+	}
+}
+
+// csvSafe stops spreadsheets from running client-supplied text (user agents, emails of failed logins) as a formula, by prefixing a ' to cells that start like one.
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
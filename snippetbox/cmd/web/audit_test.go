@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"regexp"
+	"snippetbox.audryhsu.com/internal/assert"
+	"snippetbox.audryhsu.com/internal/models"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, first, _ := ts.get(t, "/ping")
+	_, second, _ := ts.getWithHeader(t, "/ping", http.Header{"X-Request-Id": {"chosen-by-client"}})
+	assert.Equal(t, regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(first.Get("X-Request-ID")), true)
+	assert.Equal(t, regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(second.Get("X-Request-ID")), true)
+	assert.Equal(t, first.Get("X-Request-ID") != second.Get("X-Request-ID"), true)
+}
+
+// auditEvents returns the events the handlers recorded, oldest first.
+func auditEvents(t *testing.T, app *application) []*models.AuditEvent {
+	t.Helper()
+	events, err := app.auditLog.List(context.Background(), models.AuditFilter{Limit: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, j := 0, len(events)-1; i < j; i, j = i+1, j-1 {
+		events[i], events[j] = events[j], events[i]
+	}
+	return events
+}
+
+func TestAuditEvents(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+	ts.postForm(t, "/user/login", url.Values{"email": {"alice@example.com"}, "password": {"wrong"}, "csrf_token": {csrfToken}})
+	ts.login(t)
+	_, _, body = ts.get(t, "/snippet/create")
+	csrfToken = extractCSRFToken(t, body)
+	code, header, _ := ts.postForm(t, "/snippet/create", url.Values{"title": {"Haiku"}, "content": {"An old silent pond"}, "expires": {"7"}, "csrf_token": {csrfToken}})
+	assert.Equal(t, code, http.StatusSeeOther)
+	requestID := header.Get("X-Request-ID")
+	ts.postForm(t, "/user/logout", url.Values{"csrf_token": {csrfToken}})
+
+	events := auditEvents(t, app)
+	want := []struct {
+		action  models.AuditAction
+		userID  int
+		details string
+	}{
+		{models.AuditLoginFailed, 0, "alice@example.com"},
+		{models.AuditLogin, 1, ""},
+		{models.AuditSnippetCreate, 1, "snippet 2"},
+		{models.AuditLogout, 1, ""},
+	}
+	assert.Equal(t, len(events), len(want))
+	for i, w := range want {
+		assert.Equal(t, events[i].Action, w.action)
+		assert.Equal(t, events[i].UserID, w.userID)
+		assert.Equal(t, events[i].Details, w.details)
+		assert.Equal(t, events[i].IP, "127.0.0.1")
+		assert.Equal(t, events[i].UserAgent, "Go-http-client/1.1")
+	}
+	// the event can be matched to the response the user saw
+	assert.Equal(t, events[2].RequestID, requestID)
+}
+
+// TestAuditSessionRejected checks that a session whose account gets disabled is logged out, and that this is recorded once.
+func TestAuditSessionRejected(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t)
+	app.users.SetDisabled(context.Background(), 1, true)
+	ts.get(t, "/")
+	ts.get(t, "/")
+
+	events := auditEvents(t, app)
+	assert.Equal(t, len(events), 2)
+	assert.Equal(t, events[1].Action, models.AuditSessionRejected)
+	assert.Equal(t, events[1].UserID, 1)
+}
+
+func TestAdminAudit(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ctx := context.Background()
+
+	ts.login(t)
+	// only admins can see the audit log
+	for _, role := range []models.Role{models.RoleUser, models.RoleModerator} {
+		app.users.SetRole(ctx, 1, role)
+		code, _, _ := ts.get(t, "/admin/audit")
+		assert.Equal(t, code, http.StatusForbidden)
+		code, _, _ = ts.get(t, "/admin/audit/export?format=csv")
+		assert.Equal(t, code, http.StatusForbidden)
+	}
+
+	app.users.SetRole(ctx, 1, models.RoleAdmin)
+	app.auditLog.Record(ctx, &models.AuditEvent{Action: models.AuditLoginFailed, IP: "192.0.2.1", Details: "mallory@example.com"})
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []string
+		skipBody []string
+	}{
+		{
+			name:     "Everything",
+			urlPath:  "/admin/audit",
+			wantCode: http.StatusOK,
+			wantBody: []string{"<td>Login</td>", "<td>Failed login</td>", "mallory@example.com", "<a href='/admin/audit/export?format=csv'>"},
+		},
+		{
+			name:     "Filtered",
+			urlPath:  "/admin/audit?action=login_failed",
+			wantCode: http.StatusOK,
+			wantBody: []string{"mallory@example.com", "<option value='login_failed' selected>", "<a href='/admin/audit/export?action=login_failed&amp;format=csv'>"},
+			skipBody: []string{"<td>Login</td>"},
+		},
+		{
+			name:     "No matches",
+			urlPath:  "/admin/audit?user=7",
+			wantCode: http.StatusOK,
+			wantBody: []string{"No events match these filters."},
+		},
+		{
+			name:     "Invalid filters",
+			urlPath:  "/admin/audit?action=reboot&from=yesterday&user=x",
+			wantCode: http.StatusUnprocessableEntity,
+			wantBody: []string{"This field must equal signup, login", "This field must be a date", "This field must be a number"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			assert.Equal(t, code, tt.wantCode)
+			for _, want := range tt.wantBody {
+				assert.StringContains(t, body, want)
+			}
+			for _, skip := range tt.skipBody {
+				assert.Equal(t, strings.Contains(body, skip), false)
+			}
+		})
+	}
+}
+
+func TestAdminAuditExport(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	ctx := context.Background()
+
+	app.users.SetRole(ctx, 1, models.RoleAdmin)
+	ts.login(t)
+	// a failed login's email is whatever the client typed, so it can't be trusted not to be a formula
+	app.auditLog.Record(ctx, &models.AuditEvent{Action: models.AuditLoginFailed, IP: "192.0.2.1", Details: "=HYPERLINK(\"http://evil.example\")"})
+
+	code, header, body := ts.get(t, "/admin/audit/export?format=csv&action=login_failed")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "text/csv; charset=utf-8")
+	assert.StringContains(t, header.Get("Content-Disposition"), "attachment; filename=\"audit-events-")
+	records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(records), 2)
+	assert.Equal(t, strings.Join(records[0], ","), "id,created,action,user_id,ip,user_agent,request_id,details")
+	assert.Equal(t, records[1][2], "login_failed")
+	assert.Equal(t, records[1][7], "'=HYPERLINK(\"http://evil.example\")")
+
+	code, header, body = ts.get(t, "/admin/audit/export?format=json&user=1")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, header.Get("Content-Type"), "application/json")
+	var events []models.AuditEvent
+	if err := json.Unmarshal([]byte(body), &events); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(events), 1)
+	assert.Equal(t, events[0].Action, models.AuditLogin)
+
+	// nothing matches: still a JSON array
+	_, _, body = ts.get(t, "/admin/audit/export?format=json&action=logout")
+	assert.Equal(t, strings.TrimSpace(body), "[]")
+
+	for _, urlPath := range []string{"/admin/audit/export", "/admin/audit/export?format=xml", "/admin/audit/export?format=csv&to=tomorrow"} {
+		code, _, _ := ts.get(t, urlPath)
+		assert.Equal(t, code, http.StatusBadRequest)
+	}
+}
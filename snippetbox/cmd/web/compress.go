@@ -0,0 +1,277 @@
+package main
+
+import (
+	"compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compressMinSize is the smallest response worth compressing; below it the encoding overhead outweighs the savings.
+const compressMinSize = 1024
+
+// compressEncodings are the codings compressResponse can produce, in order of preference when the client likes them equally.
+var compressEncodings = []string{"zstd", "gzip"}
+
+// encoder is what gzip.Writer and zstd.Encoder have in common, so they can be pooled and used the same way.
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// encoderPools reuse encoders between responses, because creating one (zstd in particular) allocates a lot.
+var encoderPools = map[string]*sync.Pool{
+	"gzip": {New: func() any {
+		zw, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return zw
+	}},
+	"zstd": {New: func() any {
+		// one goroutine per encoder, and a window small enough for every browser that supports zstd
+		zw, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithWindowSize(1<<20))
+		return zw
+	}},
+}
+
+// compressResponse compresses response bodies with zstd or gzip, whichever the client's Accept-Encoding prefers.
+// Responses smaller than compressMinSize, content types that are already compressed (images, fonts, archives), responses that already have a Content-Encoding (e.g. precompressed static files) and partial content are sent as they are.
+func (app *application) compressResponse(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: negotiateEncoding(r.Header.Get("Accept-Encoding"), compressEncodings...)}
+		next.ServeHTTP(cw, r)
+		if err := cw.close(); err != nil {
+			// the status and probably some of the body are already sent, so all we can do is log it
+			app.errorLog.Printf("compressing response for %s: %s", r.URL.RequestURI(), err)
+		}
+	})
+}
+
+// compressWriter buffers the start of a response until it knows whether compressing it is worthwhile, then either streams it through an encoder or passes it on unchanged.
+// Handlers such as render() call WriteHeader before writing the body, so the status code is held back too: headers can't change once it has been sent.
+type compressWriter struct {
+	http.ResponseWriter
+	// encoding is the negotiated coding; empty if the client accepts none, in which case responses pass through (but still get Vary)
+	encoding string
+
+	status  int
+	buf     []byte
+	started bool
+	enc     encoder
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.started || cw.status != 0 {
+		return
+	}
+	// informational responses (103 Early Hints) aren't the final status, pass them straight on
+	if status < 200 {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status = status
+	// nothing to gain from waiting for a body that can't or won't be compressed
+	if !cw.eligible() {
+		cw.start(false)
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.started {
+		if cw.status == 0 {
+			cw.WriteHeader(http.StatusOK)
+		}
+		if !cw.started {
+			cw.buf = append(cw.buf, b...)
+			if len(cw.buf) >= compressMinSize {
+				if err := cw.start(true); err != nil {
+					return 0, err
+				}
+			}
+			return len(b), nil
+		}
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// Flush sends what has been written so far. A handler that flushes is streaming, so the response is compressed even if it is still small.
+func (cw *compressWriter) Flush() {
+	if !cw.started {
+		if cw.status == 0 {
+			cw.WriteHeader(http.StatusOK)
+		}
+		if err := cw.start(true); err != nil {
+			return
+		}
+	}
+	if cw.enc != nil {
+		if err := cw.enc.Flush(); err != nil {
+			return
+		}
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying ResponseWriter.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// eligible reports whether the response could be compressed, judging by its status and headers.
+func (cw *compressWriter) eligible() bool {
+	h := cw.Header()
+	switch {
+	case cw.status == http.StatusNoContent, cw.status == http.StatusNotModified, cw.status == http.StatusPartialContent:
+		return false
+	case h.Get("Content-Encoding") != "", h.Get("Content-Range") != "":
+		return false
+	}
+	contentType := h.Get("Content-Type")
+	return contentType == "" || isCompressibleType(contentType)
+}
+
+// start sends the status and the buffered body, compressed if compress is true and the response turns out to be compressible.
+func (cw *compressWriter) start(compress bool) error {
+	cw.started = true
+	h := cw.Header()
+
+	if compress && cw.eligible() {
+		// net/http would sniff the Content-Type from the compressed bytes, so sniff it from the real ones now
+		if h.Get("Content-Type") == "" {
+			h.Set("Content-Type", http.DetectContentType(cw.buf))
+		}
+		if isCompressibleType(h.Get("Content-Type")) {
+			addVary(h, "Accept-Encoding")
+			if cw.encoding != "" {
+				h.Set("Content-Encoding", cw.encoding)
+				// byte ranges would refer to the uncompressed body
+				h.Del("Content-Length")
+				h.Del("Accept-Ranges")
+				// the compressed body is a different byte sequence, so a strong ETag no longer holds
+				if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+					h.Set("ETag", "W/"+etag)
+				}
+				cw.enc = encoderPools[cw.encoding].Get().(encoder)
+				cw.enc.Reset(cw.ResponseWriter)
+			}
+		}
+	}
+
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.enc != nil {
+		_, err = cw.enc.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// close sends whatever is still buffered (small responses go out uncompressed) and finishes the compressed stream.
+func (cw *compressWriter) close() error {
+	if !cw.started {
+		// the handler wrote nothing at all; leave the response to net/http
+		if cw.status == 0 && len(cw.buf) == 0 {
+			return nil
+		}
+		if err := cw.start(false); err != nil {
+			return err
+		}
+	}
+	if cw.enc == nil {
+		return nil
+	}
+	err := cw.enc.Close()
+	// don't keep a reference to this response in the pool
+	cw.enc.Reset(nil)
+	encoderPools[cw.encoding].Put(cw.enc)
+	cw.enc = nil
+	return err
+}
+
+// isCompressibleType reports whether a Content-Type is worth compressing. Images (other than SVG), audio, video, fonts and archives are already compressed.
+func isCompressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/javascript", "application/xml", "application/csp-report", "image/svg+xml", "image/x-icon", "image/vnd.microsoft.icon":
+		return true
+	}
+	return false
+}
+
+// addVary adds value to the Vary header unless it is already listed.
+func addVary(h http.Header, value string) {
+	for _, v := range h.Values("Vary") {
+		for _, field := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), value) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", value)
+}
+
+// negotiateEncoding returns the offered coding the Accept-Encoding header rates highest, or "" if it accepts none of them. Ties go to the earlier offer.
+func negotiateEncoding(acceptEncoding string, offers ...string) string {
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := acceptEncodingQ(acceptEncoding, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// acceptEncodingQ returns the quality value an Accept-Encoding header gives a coding: 0 means not acceptable. The coding named explicitly wins over "*".
+func acceptEncodingQ(acceptEncoding, coding string) float64 {
+	wildcard := 0.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.TrimSpace(name)
+		q := 1.0
+		if v, found := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q="); found {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				q = 0
+			}
+		}
+		switch {
+		case strings.EqualFold(name, coding):
+			return q
+		case name == "*":
+			wildcard = q
+		}
+	}
+	return wildcard
+}
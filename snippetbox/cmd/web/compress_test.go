@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"snippetbox.audryhsu.com/internal/assert"
+	"strings"
+	"testing"
+)
+
+// decompress decodes a response body according to its Content-Encoding.
+func decompress(t *testing.T, encoding string, body []byte) string {
+	t.Helper()
+	var r io.Reader = bytes.NewReader(body)
+	switch encoding {
+	case "gzip":
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	case "zstd":
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestCompressResponse(t *testing.T) {
+	large := strings.Repeat("<p>Hello, snippetbox!</p>\n", 100)
+	small := "<p>Hello</p>"
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		encoding       string // Content-Encoding set by the handler
+		status         int
+		body           string
+		wantEncoding   string
+		wantVary       string
+	}{
+		{
+			name:           "gzip",
+			acceptEncoding: "gzip, deflate",
+			contentType:    "text/html; charset=utf-8",
+			body:           large,
+			wantEncoding:   "gzip",
+			wantVary:       "Accept-Encoding",
+		},
+		{
+			name:           "zstd preferred",
+			acceptEncoding: "gzip, deflate, br, zstd",
+			contentType:    "text/html; charset=utf-8",
+			body:           large,
+			wantEncoding:   "zstd",
+			wantVary:       "Accept-Encoding",
+		},
+		{
+			name:           "q values",
+			acceptEncoding: "zstd;q=0.5, gzip",
+			contentType:    "text/html; charset=utf-8",
+			body:           large,
+			wantEncoding:   "gzip",
+			wantVary:       "Accept-Encoding",
+		},
+		{
+			name:           "Sniffed content type",
+			acceptEncoding: "gzip",
+			body:           large,
+			wantEncoding:   "gzip",
+			wantVary:       "Accept-Encoding",
+		},
+		{
+			name:           "Status kept",
+			acceptEncoding: "gzip",
+			contentType:    "application/json",
+			status:         http.StatusUnprocessableEntity,
+			body:           `{"error": "` + strings.Repeat("x", 2000) + `"}`,
+			wantEncoding:   "gzip",
+			wantVary:       "Accept-Encoding",
+		},
+		{
+			name:           "No accepted encoding",
+			acceptEncoding: "br",
+			contentType:    "text/html; charset=utf-8",
+			body:           large,
+			wantVary:       "Accept-Encoding",
+		},
+		{
+			name:           "Too small",
+			acceptEncoding: "gzip",
+			contentType:    "text/html; charset=utf-8",
+			body:           small,
+		},
+		{
+			name:           "Already compressed type",
+			acceptEncoding: "gzip",
+			contentType:    "image/png",
+			body:           large,
+		},
+		{
+			name:           "Already encoded",
+			acceptEncoding: "gzip",
+			contentType:    "text/css",
+			encoding:       "br",
+			body:           large,
+			wantEncoding:   "br",
+		},
+		{
+			name:           "No content",
+			acceptEncoding: "gzip",
+			status:         http.StatusNoContent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.contentType != "" {
+					w.Header().Set("Content-Type", tt.contentType)
+				}
+				if tt.encoding != "" {
+					w.Header().Set("Content-Encoding", tt.encoding)
+				}
+				if tt.status != 0 {
+					w.WriteHeader(tt.status)
+				}
+				// write in two pieces, like a handler that streams its output
+				half := len(tt.body) / 2
+				w.Write([]byte(tt.body[:half]))
+				w.Write([]byte(tt.body[half:]))
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			rr := httptest.NewRecorder()
+			app.compressResponse(next).ServeHTTP(rr, req)
+
+			wantStatus := tt.status
+			if wantStatus == 0 {
+				wantStatus = http.StatusOK
+			}
+			assert.Equal(t, rr.Code, wantStatus)
+			assert.Equal(t, rr.Header().Get("Content-Encoding"), tt.wantEncoding)
+			assert.Equal(t, rr.Header().Get("Vary"), tt.wantVary)
+
+			body := rr.Body.String()
+			if tt.wantEncoding != tt.encoding {
+				body = decompress(t, tt.wantEncoding, rr.Body.Bytes())
+			}
+			assert.Equal(t, body, tt.body)
+		})
+	}
+}
+
+func TestCompressResponseFlush(t *testing.T) {
+	app := newTestApplication(t)
+	flushed := ""
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("first chunk\n"))
+		w.(http.Flusher).Flush()
+		flushed = w.(*compressWriter).ResponseWriter.(*httptest.ResponseRecorder).Body.String()
+		w.Write([]byte("second chunk\n"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	rr := httptest.NewRecorder()
+	app.compressResponse(next).ServeHTTP(rr, req)
+
+	// a flushed response is compressed even though it is small, and the first chunk has reached the client before the handler returns
+	assert.Equal(t, rr.Header().Get("Content-Encoding"), "gzip")
+	if flushed == "" {
+		t.Error("nothing was sent by Flush")
+	}
+	assert.Equal(t, decompress(t, "gzip", rr.Body.Bytes()), "first chunk\nsecond chunk\n")
+}
+
+func TestCompressedPage(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/snippet/view/1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// setting Accept-Encoding ourselves stops the client from decompressing transparently
+	req.Header.Set("Accept-Encoding", "zstd")
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, res.StatusCode, http.StatusOK)
+	assert.Equal(t, res.Header.Get("Content-Encoding"), "zstd")
+	assert.StringContains(t, decompress(t, "zstd", body), "An old silent pond...")
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, zstd", "zstd"},
+		{"gzip;q=1.0, zstd;q=0.8", "gzip"},
+		{"zstd;q=0, gzip;q=0.1", "gzip"},
+		{"*", "zstd"},
+		{"*;q=0.5, zstd;q=0", "gzip"},
+		{"identity", ""},
+		{"gzip;q=0", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			assert.Equal(t, negotiateEncoding(tt.acceptEncoding, compressEncodings...), tt.want)
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/justinas/nosurf"
+	"io/fs"
+	"net/http"
+	"snippetbox.audryhsu.com/internal/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// fsVersion returns a hash of every file in fsys. It identifies the templates and static files a page was rendered with, so pages cached by browsers are invalidated by a deploy that changes them.
+func fsVersion(fsys fs.FS) (string, error) {
+	h := sha256.New()
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return err
+		}
+		// WalkDir visits files in lexical order, so the hash is stable
+		h.Write([]byte(path + "\x00"))
+		h.Write(b)
+		h.Write([]byte{0})
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:12]), nil
+}
+
+// snippetETag returns the ETag of a snippet page for this request. The snippet never changes once created, but the rendered page also depends on who is asking:
+// the page is in the user's language, the nav shows the logged in user's links (and moderators get a delete button), the logout and language forms carry a token derived from their CSRF cookie, and the footer shows the current year. All of those are part of the hash, so a cached page is only reused by the same user in the same session and language.
+// The CSP nonce differs on every request and can't be part of the hash; see writeNotModified for how cached pages keep a matching policy.
+func (app *application) snippetETag(r *http.Request, snippet *models.Snippet) string {
+	var csrfCookie string
+	var role models.Role
+	if user := app.authenticatedUser(r); user != nil {
+		role = user.Role
+	}
+	if cookie, err := r.Cookie(nosurf.CookieName); err == nil {
+		csrfCookie = cookie.Value
+	}
+
+	h := sha256.New()
+	for _, part := range []string{
+		app.pageVersion,
+		strconv.Itoa(time.Now().Year()),
+		strconv.Itoa(snippet.ID),
+		snippet.Title,
+		snippet.Content,
+		snippet.Created.UTC().Format(time.RFC3339Nano),
+		snippet.Expires.UTC().Format(time.RFC3339Nano),
+		strconv.FormatBool(app.isAuthenticated(r)),
+		strconv.Itoa(app.sessionManager.GetInt(r.Context(), "authenticatedUserID")),
+		string(role),
+		app.locale(r),
+		csrfCookie,
+	} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return `"` + base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// notModified reports whether the client's cached copy of a page with the given validators is still current.
+// If-None-Match takes precedence over If-Modified-Since (RFC 9110 section 13.2.2). A bare If-Modified-Since can't tell whose page the client has cached, so it is only trusted when modifiedOK is true.
+func notModified(r *http.Request, etag string, lastModified time.Time, modifiedOK bool) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			// If-None-Match uses weak comparison; the compression middleware turns our ETags into weak ones
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !modifiedOK || lastModified.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	// the page also shows the current year, so a copy cached last year is stale
+	return !lastModified.Truncate(time.Second).After(since) && since.Year() == time.Now().Year()
+}
+
+// writeNotModified sends a 304 Not Modified response.
+// Browsers merge the headers of a 304 into their cached response, and a fresh CSP nonce would no longer match the nonce in the cached page's script tags. So the CSP headers are dropped and the cached page keeps the policy it was served with.
+func writeNotModified(w http.ResponseWriter) {
+	h := w.Header()
+	h.Del("Content-Security-Policy")
+	h.Del("Content-Security-Policy-Report-Only")
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	w.WriteHeader(http.StatusNotModified)
+}
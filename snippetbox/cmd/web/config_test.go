@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"snippetbox.audryhsu.com/internal/assert"
+	"testing"
+	"time"
+)
+
+// TestLoadConfig checks that settings are resolved as defaults < config file < env vars < flags.
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	tomlFile := filepath.Join(dir, "snippetbox.toml")
+	writeFile(t, tomlFile, "addr = \":5000\"\nsession-lifetime = \"1h\"\nbcrypt-cost = 10\n")
+	yamlFile := filepath.Join(dir, "snippetbox.yaml")
+	writeFile(t, yamlFile, "addr: \":6000\"\ndebug: true\n")
+
+	tests := []struct {
+		name                string
+		args                []string
+		env                 map[string]string
+		wantAddr            string
+		wantDSN             string
+		wantDebug           bool
+		wantSessionLifetime time.Duration
+		wantBcryptCost      int
+	}{
+		{
+			name:                "defaults",
+			wantAddr:            ":4000",
+			wantDSN:             "web:password@/snippetbox?parseTime=true",
+			wantSessionLifetime: 12 * time.Hour,
+			wantBcryptCost:      12,
+		},
+		{
+			name:                "driver default dsn",
+			args:                []string{"-db-driver", "sqlite"},
+			wantAddr:            ":4000",
+			wantDSN:             defaultDSNs["sqlite"],
+			wantSessionLifetime: 12 * time.Hour,
+			wantBcryptCost:      12,
+		},
+		{
+			name:                "toml file overrides defaults",
+			args:                []string{"-config", tomlFile},
+			wantAddr:            ":5000",
+			wantDSN:             "web:password@/snippetbox?parseTime=true",
+			wantSessionLifetime: time.Hour,
+			wantBcryptCost:      10,
+		},
+		{
+			name:                "yaml file from env",
+			env:                 map[string]string{"SNIPPETBOX_CONFIG": yamlFile},
+			wantAddr:            ":6000",
+			wantDSN:             "web:password@/snippetbox?parseTime=true",
+			wantDebug:           true,
+			wantSessionLifetime: 12 * time.Hour,
+			wantBcryptCost:      12,
+		},
+		{
+			name:                "env overrides file",
+			args:                []string{"-config", tomlFile},
+			env:                 map[string]string{"SNIPPETBOX_ADDR": ":7000", "SNIPPETBOX_DSN": "secret-dsn"},
+			wantAddr:            ":7000",
+			wantDSN:             "secret-dsn",
+			wantSessionLifetime: time.Hour,
+			wantBcryptCost:      10,
+		},
+		{
+			name:                "flags override env",
+			args:                []string{"-config", tomlFile, "-addr", ":8000", "-bcrypt-cost", "11"},
+			env:                 map[string]string{"SNIPPETBOX_ADDR": ":7000", "SNIPPETBOX_BCRYPT_COST": "13"},
+			wantAddr:            ":8000",
+			wantDSN:             "web:password@/snippetbox?parseTime=true",
+			wantSessionLifetime: time.Hour,
+			wantBcryptCost:      11,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := loadConfig(test.args, func(key string) string { return test.env[key] })
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, cfg.addr, test.wantAddr)
+			assert.Equal(t, cfg.dsn, test.wantDSN)
+			assert.Equal(t, cfg.debug, test.wantDebug)
+			assert.Equal(t, cfg.sessionLifetime, test.wantSessionLifetime)
+			assert.Equal(t, cfg.bcryptCost, test.wantBcryptCost)
+		})
+	}
+}
+
+// TestLoadConfigInvalid checks that bad settings are rejected at startup.
+func TestLoadConfigInvalid(t *testing.T) {
+	dir := t.TempDir()
+	unknownFile := filepath.Join(dir, "unknown.toml")
+	writeFile(t, unknownFile, "adr = \":5000\"\n")
+
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+	}{
+		{name: "bcrypt cost too high", args: []string{"-bcrypt-cost", "99"}},
+		{name: "unknown password hash", args: []string{"-password-hash", "md5"}},
+		{name: "argon2 memory too low", args: []string{"-argon2-memory", "8", "-argon2-parallelism", "2"}},
+		{name: "zero argon2 iterations", args: []string{"-argon2-iterations", "0"}},
+		{name: "argon2 parallelism too high", args: []string{"-argon2-parallelism", "256"}},
+		{name: "password min score too high", args: []string{"-password-min-score", "5"}},
+		{name: "oidc issuer without client id", args: []string{"-oidc-issuer", "https://login.example.com", "-oidc-redirect-url", "https://snippetbox.example.com/user/oidc/callback"}},
+		{name: "oidc issuer without redirect url", args: []string{"-oidc-issuer", "https://login.example.com", "-oidc-client-id", "snippetbox"}},
+		{name: "relative oidc issuer", args: []string{"-oidc-issuer", "login.example.com", "-oidc-client-id", "snippetbox", "-oidc-redirect-url", "https://snippetbox.example.com/user/oidc/callback"}},
+		{name: "rate limit without period", args: []string{"-rate-limit-auth", "10"}},
+		{name: "zero rate limit", args: []string{"-rate-limit-create", "0/m"}},
+		{name: "CSP rate limit without count", args: []string{"-rate-limit-csp", "/m"}},
+		{name: "bad rate limit period", env: map[string]string{"SNIPPETBOX_RATE_LIMIT_VIEW": "10/fortnight"}},
+		{name: "bad trusted proxy", args: []string{"-trusted-proxies", "10.0.0.1, proxy.example.com"}},
+		{name: "bad trusted proxy range", args: []string{"-trusted-proxies", "10.0.0.0/33"}},
+		{name: "negative session lifetime", env: map[string]string{"SNIPPETBOX_SESSION_LIFETIME": "-1h"}},
+		{name: "empty dsn", args: []string{"-dsn", ""}},
+		{name: "unknown db driver", args: []string{"-db-driver", "oracle"}},
+		{name: "unknown file setting", args: []string{"-config", unknownFile}},
+		{name: "unsupported file type", args: []string{"-config", filepath.Join(dir, "config.ini")}},
+		{name: "bad env value", env: map[string]string{"SNIPPETBOX_DEBUG": "maybe"}},
+		{name: "negative snippet cache size", args: []string{"-snippet-cache-size", "-1"}},
+		{name: "snippet cache without ttl", args: []string{"-snippet-cache-ttl", "0s"}},
+		{name: "zero max form bytes", args: []string{"-max-form-bytes", "0"}},
+		{name: "unknown secret scan mode", args: []string{"-secret-scan", "warn"}},
+		{name: "remember me shorter than a session", args: []string{"-session-lifetime", "48h", "-remember-lifetime", "24h"}},
+		{name: "zero idle timeout", args: []string{"-session-idle-timeout", "0s"}},
+		{name: "negative reauth-after", args: []string{"-reauth-after", "-5m"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := loadConfig(test.args, func(key string) string { return test.env[key] })
+			if err == nil {
+				t.Error("expected an error, got nil")
+			}
+		})
+	}
+}
+
+// TestPrintConfig checks that -print-config never shows the DSN password.
+func TestPrintConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		dsn     string
+		wantDSN string
+	}{
+		{
+			name:    "mysql",
+			dsn:     "web:hunter2@tcp(db:3306)/snippetbox?parseTime=true",
+			wantDSN: `dsn = "web:REDACTED@tcp(db:3306)/snippetbox?parseTime=true"`,
+		},
+		{
+			name:    "postgres url",
+			dsn:     "postgres://web:hunter2@db/snippetbox?sslmode=disable",
+			wantDSN: `dsn = "postgres://web:REDACTED@db/snippetbox?sslmode=disable"`,
+		},
+		{
+			name:    "postgres key value",
+			dsn:     "host=db user=web password=hunter2 dbname=snippetbox",
+			wantDSN: `dsn = "host=db user=web password=REDACTED dbname=snippetbox"`,
+		},
+		{
+			name:    "postgres url query",
+			dsn:     "postgres://db/snippetbox?sslmode=verify-full&password=hunter2&sslpassword=hunter2&sslkey=/etc/hunter2.key",
+			wantDSN: `dsn = "postgres://db/snippetbox?sslmode=verify-full&password=REDACTED&sslpassword=REDACTED&sslkey=REDACTED"`,
+		},
+		{
+			name:    "postgres key value quoted",
+			dsn:     `host=db password='hunter2 \'hunter2\'' sslpassword = hunter2 dbname='snippet box'`,
+			wantDSN: `dsn = "host=db password=REDACTED sslpassword=REDACTED dbname='snippet box'"`,
+		},
+		{
+			name:    "postgres key value unterminated quote",
+			dsn:     "host=db password='hunter2",
+			wantDSN: `dsn = "REDACTED"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := defaultConfig()
+			cfg.dsn = test.dsn
+			cfg.oidcClientSecret = "hunter2"
+
+			var buf bytes.Buffer
+			cfg.print(&buf)
+
+			if bytes.Contains(buf.Bytes(), []byte("hunter2")) {
+				t.Errorf("printed config contains a secret:\n%s", buf.String())
+			}
+			assert.StringContains(t, buf.String(), test.wantDSN)
+			assert.StringContains(t, buf.String(), `oidc-client-secret = "REDACTED"`)
+			assert.StringContains(t, buf.String(), `bcrypt-cost = 12`)
+		})
+	}
+}
+
+func writeFile(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -4,3 +4,15 @@ package main
 type contextKey string
 
 const isAuthenticatedContextKey = contextKey("isAuthenticated")
+
+// cspNonceContextKey holds the per-request nonce that secureHeaders adds to the Content-Security-Policy
+const cspNonceContextKey = contextKey("cspNonce")
+
+// authenticatedUserContextKey holds the *models.User that authenticate loaded for the logged in user
+const authenticatedUserContextKey = contextKey("authenticatedUser")
+
+// localeContextKey holds the language negotiateLocale picked for the request
+const localeContextKey = contextKey("locale")
+
+// requestIDContextKey holds the id requestID gave the request
+const requestIDContextKey = contextKey("requestID")
@@ -6,6 +6,7 @@ import (
 	"github.com/julienschmidt/httprouter"
 	"log"
 	"snippetbox.audryhsu.com/internal/models"
+	"snippetbox.audryhsu.com/internal/secrets"
 	"snippetbox.audryhsu.com/internal/validator"
 	// "log"
 	"net/http"
@@ -14,15 +15,15 @@ import (
 
 // Change signature of home handler as a method against *application.
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
-	snippets, err := app.snippets.Latest()
+	snippets, err := app.snippets.Latest(r.Context())
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	data := app.NewTemplateData(r)
 	data.Snippets = snippets
 	// render template passing in templateData of the latest snippets
-	app.render(w, http.StatusOK, "home.html", data)
+	app.render(w, r, http.StatusOK, "home.html", data)
 }
 func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 	// http-router stores named parameters in request context.
@@ -31,30 +32,119 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 	// use ByName() method to get value of "id" named param from slice and validate
 	id, err := strconv.Atoi(params.ByName("id"))
 	if err != nil || id < 1 {
-		app.notFound(w)
+		app.notFound(w, r)
 		return
 	}
-	// use SnippetModel object's Get method to retrieve snipped by ID. Return 404 not found if no matching record.
-	snippet, err := app.snippets.Get(id)
+	// use SnippetModel object's Get method to retrieve snipped by ID. Return 404 not found if no matching record, or if it's someone else's private snippet.
+	snippet, err := app.snippets.Get(r.Context(), id)
 	if err != nil {
 		if errors.Is(err, models.ErrNoRecord) {
-			app.notFound(w)
+			app.notFound(w, r)
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 		}
 		return
 	}
+	if !app.canView(r, snippet) {
+		app.notFound(w, r)
+		return
+	}
+
+	// Snippets never change, so browsers can revalidate the page with a conditional GET and get a 304 Not Modified instead of a re-render.
+	// The ETag covers the per-user parts of the page too (see snippetETag). Pages showing a flash message are one-off, so they get no validators and aren't stored.
+	// Conditional GETs are off in -dev mode (empty pageVersion), where templates change on disk.
+	if app.pageVersion != "" && !app.sessionManager.Exists(r.Context(), "flash") {
+		etag := app.snippetETag(r, snippet)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", snippet.Created.UTC().Format(http.TimeFormat))
+		// private: the page is per-user, so shared caches must not store it; no-cache: browsers must revalidate before reusing it
+		w.Header().Set("Cache-Control", "private, no-cache")
+		w.Header().Add("Vary", "Cookie")
+		// If-Modified-Since alone is only trusted for anonymous users. Apart from the year, their page only depends on the session cookie and Accept-Language header it varies on (for its language and the switcher's CSRF token).
+		if notModified(r, etag, snippet.Created, !app.isAuthenticated(r)) {
+			writeNotModified(w)
+			return
+		}
+	} else {
+		w.Header().Set("Cache-Control", "no-store")
+	}
+
 	data := app.NewTemplateData(r)
 	data.Snippet = snippet
+	// logged in users can report the snippet to the moderators
+	if app.isAuthenticated(r) {
+		data.Form = snippetReportForm{}
+	}
 
 	// render an instance of templateData struct holding snippet data
-	app.render(w, http.StatusOK, "view.html", data)
+	app.render(w, r, http.StatusOK, "view.html", data)
+}
+
+// snippetReportForm is the report form at the bottom of a snippet's page.
+type snippetReportForm struct {
+	Reason              string `form:"reason" validate:"oneof=spam abuse secret other"`
+	Details             string `form:"details" validate:"max=500"`
+	validator.Validator `form:"-"`
+}
+
+// snippetReport files the logged in user's report about a snippet for the moderation queue.
+func (app *application) snippetReport(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(httprouter.ParamsFromContext(r.Context()).ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w, r)
+		return
+	}
+
+	var form snippetReportForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	form.Validate(form)
+	if !form.Valid() {
+		// show the snippet's page again, with the errors on the report form
+		snippet, err := app.snippets.Get(r.Context(), id)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.notFound(w, r)
+			} else {
+				app.serverError(w, r, err)
+			}
+			return
+		}
+		if !app.canView(r, snippet) {
+			app.notFound(w, r)
+			return
+		}
+		data := app.NewTemplateData(r)
+		data.Snippet = snippet
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "view.html", data)
+		return
+	}
+
+	err = app.moderation.Report(r.Context(), id, app.authenticatedUser(r).ID, models.ReportReason(form.Reason), form.Details)
+	switch {
+	case errors.Is(err, models.ErrNoRecord):
+		app.notFound(w, r)
+		return
+	case errors.Is(err, models.ErrDuplicateReport):
+		// the first report is still waiting for a moderator, which is all a second one would do
+		app.sessionManager.Put(r.Context(), "flash", "flash.already_reported")
+	case err != nil:
+		app.serverError(w, r, err)
+		return
+	default:
+		app.sessionManager.Put(r.Context(), "flash", "flash.reported")
+	}
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
 }
 
 type snippetCreateForm struct {
-	Title               string     `form:"title"`
-	Content             string     `form:"content"`
-	Expires             int        `form:"expires"`
+	Title               string     `form:"title" validate:"required,max=100"`
+	Content             string     `form:"content" validate:"required"`
+	Expires             int        `form:"expires" validate:"oneof=1 7 365"`
+	Private             bool       `form:"private"`
 	validator.Validator `form:"-"` // anonymous Validator type; "-" means ignore field during decoding
 }
 
@@ -64,33 +154,54 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 
 	if err := app.decodePostForm(r, &form); err != nil {
 		log.Print("couldn't decode post form")
-		app.clientError(w, http.StatusBadRequest)
+		app.clientError(w, r, http.StatusBadRequest)
 		return
 	}
 
-	form.CheckField(form.NotBlank(form.Title), "title", "This field cannot be blank")
-	form.CheckField(form.MaxChars(form.Title, 100), "title", "This field cannot be more than 100 characters long")
-	form.CheckField(form.NotBlank(form.Content), "content", "This field cannot be blank")
-	form.CheckField(validator.PermittedValue(form.Expires, 1, 7, 365), "expires", "This field must equal 1,7, or 365")
+	// check the fields against the rules in the form's validate tags
+	form.Validate(form)
+
+	// look for keys and tokens before anything is stored; what happens to them depends on -secret-scan
+	var findings []secrets.Finding
+	if app.config.secretScan != secretScanOff {
+		findings = app.secretScanner.Scan(form.Content)
+	}
+	if len(findings) > 0 {
+		app.logSecrets(r, findings)
+		if app.config.secretScan == secretScanBlock {
+			form.AddFieldError("content", validator.Msg("validation.secret", "line", findings[0].Line))
+		}
+	}
 
 	if !form.Valid() {
 		log.Println("failed form validation")
 		data := app.NewTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "create.html", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "create.html", data)
 		return
 	}
 
-	id, err := app.snippets.Insert(form.Title, form.Content, form.Expires)
+	content, private, flash := form.Content, form.Private, "flash.snippet_created"
+	if len(findings) > 0 {
+		switch app.config.secretScan {
+		case secretScanPrivate:
+			private, flash = true, "flash.snippet_made_private"
+		case secretScanRedact:
+			content, flash = secrets.Redact(content, findings), "flash.snippet_redacted"
+		}
+	}
+
+	id, err := app.snippets.Insert(r.Context(), app.authenticatedUser(r).ID, form.Title, content, form.Expires, private)
 	log.Println("trying to insert the snippet")
 	if err != nil {
 		log.Println("couldn't insert snippet into database")
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
+	app.audit(r, models.AuditSnippetCreate, app.authenticatedUser(r).ID, fmt.Sprintf("snippet %d", id))
 
 	// use Put() method to add key/value pair to session data.
-	app.sessionManager.Put(r.Context(), "flash", "Snippet successfully created!")
+	app.sessionManager.Put(r.Context(), "flash", flash)
 	// use clean URL format in redirects
 	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
 }
@@ -103,13 +214,13 @@ func (app *application) snippetCreateForm(w http.ResponseWriter, r *http.Request
 	// Without initializing the form field, the server will error out bc template cannot render nil as .Form in HTML
 	data.Form = snippetCreateForm{Expires: 365}
 
-	app.render(w, http.StatusOK, "create.html", data)
+	app.render(w, r, http.StatusOK, "create.html", data)
 }
 
 type UserSignupForm struct {
-	Name                string `form:"name"`
-	Email               string `form:"email"`
-	Password            string `form:"password"`
+	Name                string `form:"name" validate:"required"`
+	Email               string `form:"email" validate:"required,email"`
+	Password            string `form:"password" validate:"required,min=8"`
 	validator.Validator `form:"-"`
 }
 
@@ -118,53 +229,54 @@ func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
 	data := app.NewTemplateData(r)
 	data.Form = UserSignupForm{}
 
-	app.render(w, http.StatusOK, "signup.html", data)
+	app.render(w, r, http.StatusOK, "signup.html", data)
 }
 
 func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 	var form UserSignupForm
 	// parse form data into UserSignup struct
 	if err := app.decodePostForm(r, &form); err != nil {
-		app.clientError(w, http.StatusBadRequest)
+		app.clientError(w, r, http.StatusBadRequest)
 		return
 	}
 	// validate data
-	form.CheckField(form.MinChars(form.Password, 8), "password", "Password must be at least 8 characters long")
-	form.CheckField(form.NotBlank(form.Password), "password", "Password cannot be blank")
-	form.CheckField(form.NotBlank(form.Name), "name", "name cannot be blank")
-	form.CheckField(form.NotBlank(form.Email), "email", "email cannot be blank")
-	form.CheckField(form.Matches(form.Email, validator.EmailRX), "email", "email must be valid address")
+	form.Validate(form)
+	app.checkPassword(&form.Validator, "password", form.Password, form.Name, form.Email)
 
 	if !form.Valid() {
 		data := app.NewTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "signup.html", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "signup.html", data)
 		return
 	}
 
 	// insert new user into db; if email already exists, re-render form with field error
-	err := app.users.Insert(form.Name, form.Email, form.Password)
+	err := app.users.Insert(r.Context(), form.Name, form.Email, form.Password)
 	if err != nil {
 		if errors.Is(err, models.ErrDuplicateEmail) {
-			form.AddFieldError("email", "Email address already in use")
+			form.AddFieldError("email", validator.Msg("validation.duplicate_email"))
 			data := app.NewTemplateData(r)
 			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "signup.html", data)
+			app.render(w, r, http.StatusUnprocessableEntity, "signup.html", data)
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 		}
 		return
 	}
+	// nobody is logged in yet, so the event has no user; the email says whose account it was
+	app.audit(r, models.AuditSignup, 0, form.Email)
 
 	// Otherwise,add confirmation flash to session and redirect to login page
-	app.sessionManager.Put(r.Context(), "flash", "User signed up successfully")
+	app.sessionManager.Put(r.Context(), "flash", "flash.signed_up")
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
 // userLoginForm represents and holds the form data
 type userLoginForm struct {
-	Email               string `form:"email"`
-	Password            string `form:"password"`
+	Email    string `form:"email" validate:"required,email"`
+	Password string `form:"password" validate:"required"`
+	// Remember keeps the user logged in across browser restarts, for -remember-lifetime
+	Remember            bool `form:"remember"`
 	validator.Validator `form:"-"`
 }
 
@@ -172,7 +284,7 @@ type userLoginForm struct {
 func (app *application) userLogin(w http.ResponseWriter, r *http.Request) {
 	data := app.NewTemplateData(r)
 	data.Form = userLoginForm{}
-	app.render(w, http.StatusOK, "login.html", data)
+	app.render(w, r, http.StatusOK, "login.html", data)
 }
 
 // userLoginPost authenticates and logs in user
@@ -183,59 +295,153 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 	err := app.decodePostForm(r, &form)
 	if err != nil {
 		log.Println("form decode error on user login")
-		app.clientError(w, http.StatusBadRequest)
+		app.clientError(w, r, http.StatusBadRequest)
 		return
 	}
 	// validation checks -- email and password are provided and formats are correct
-	form.CheckField(form.NotBlank(form.Email), "email", "This field cannot be blank")
-	form.CheckField(form.NotBlank(form.Password), "password", "This field field cannot be blank")
-	form.CheckField(form.Matches(form.Email, validator.EmailRX), "email", "This field must be valid email")
+	form.Validate(form)
 
 	if !form.Valid() {
 		log.Println("form failed validation")
 		data := app.NewTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "login.html", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "login.html", data)
 		return
 	}
 
-	id, err := app.users.Authenticate(form.Email, form.Password)
+	id, err := app.users.Authenticate(r.Context(), form.Email, form.Password)
 	if err != nil {
-		if errors.Is(err, models.ErrInvalidCredentials) {
-			form.AddNonFieldError("Email or password is incorrect")
-			data := app.NewTemplateData(r)
-			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "login.html", data)
-			return
-		} else {
-			app.serverError(w, err)
+		switch {
+		case errors.Is(err, models.ErrInvalidCredentials):
+			app.audit(r, models.AuditLoginFailed, 0, form.Email)
+			form.AddNonFieldError(validator.Msg("validation.invalid_credentials"))
+		case errors.Is(err, models.ErrAccountDisabled):
+			app.audit(r, models.AuditLoginFailed, 0, form.Email+" (account disabled)")
+			form.AddNonFieldError(validator.Msg("validation.account_disabled"))
+		default:
+			app.serverError(w, r, err)
 			return
 		}
+		data := app.NewTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "login.html", data)
+		return
 	}
-	// Good practice to generate a new session ID when auth state or priv levels change for a user (e.g. login/logout)
-	err = app.sessionManager.RenewToken(r.Context())
-	if err != nil {
-		app.serverError(w, err)
+	if err := app.startSession(r, id, form.Remember); err != nil {
+		app.serverError(w, r, err)
 		return
 	}
-	// add ID of current user to session so they are 'logged in'
-	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
+	app.audit(r, models.AuditLogin, id, "")
 	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
 }
 
 // userLogoutPost renews the session ID and removes userid from session store
 func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
+	if err := app.sessions.Remove(r.Context(), app.sessionManager.Token(r.Context())); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
 	err := app.sessionManager.RenewToken(r.Context())
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 	app.sessionManager.Remove(r.Context(), "authenticatedUserID")
-	app.sessionManager.Put(r.Context(), "flash", "Logged out successfully")
+	app.audit(r, models.AuditLogout, app.authenticatedUser(r).ID, "")
+	app.sessionManager.Put(r.Context(), "flash", "flash.logged_out")
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// accountPasswordForm is the password change form, where the new password is typed twice. The user has recently entered their current one, see requireRecentAuth.
+type accountPasswordForm struct {
+	NewPassword         string `form:"new_password" validate:"required,min=8"`
+	Confirmation        string `form:"confirmation" validate:"required"`
+	validator.Validator `form:"-"`
+}
+
+// accountPassword displays the password change form
+func (app *application) accountPassword(w http.ResponseWriter, r *http.Request) {
+	data := app.NewTemplateData(r)
+	data.Form = accountPasswordForm{}
+	app.render(w, r, http.StatusOK, "password.html", data)
+}
+
+// accountPasswordPost changes the logged in user's password
+func (app *application) accountPasswordPost(w http.ResponseWriter, r *http.Request) {
+	var form accountPasswordForm
+	if err := app.decodePostForm(r, &form); err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	form.Validate(form)
+	form.CheckField(form.NewPassword == form.Confirmation, "confirmation", validator.Msg("validation.password_mismatch"))
+	user := app.authenticatedUser(r)
+	app.checkPassword(&form.Validator, "new_password", form.NewPassword, user.Name, user.Email)
+
+	if !form.Valid() {
+		// never send passwords back to the browser
+		form.NewPassword, form.Confirmation = "", ""
+		data := app.NewTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "password.html", data)
+		return
+	}
+
+	userID := user.ID
+	if err := app.users.SetPassword(r.Context(), userID, form.NewPassword); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// whoever knew the old password may be logged in elsewhere: log out the other sessions, and give this one a new token
+	n, err := app.revokeOtherSessions(r, userID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	current, err := app.sessions.Get(r.Context(), app.sessionManager.Token(r.Context()))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if err := app.sessions.Remove(r.Context(), current.Token); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if err := app.startSession(r, userID, current.Remember); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.audit(r, models.AuditPasswordChange, userID, fmt.Sprintf("%d other sessions signed out", n))
+	app.sessionManager.Put(r.Context(), "flash", "flash.password_changed")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// setLocale saves the language picked in the footer's language switcher: on the user's account when logged in, otherwise in the session. It then sends the user back to the page they were on.
+func (app *application) setLocale(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	locale := r.PostForm.Get("locale")
+	if !app.translations.Supports(locale) {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	if user := app.authenticatedUser(r); user != nil {
+		if err := app.users.SetLocale(r.Context(), user.ID, locale); err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	} else {
+		app.sessionManager.Put(r.Context(), "locale", locale)
+	}
+
+	http.Redirect(w, r, localRedirect(r.Referer()), http.StatusSeeOther)
+}
+
 func ping(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write([]byte("OK"))
 }
@@ -243,5 +449,5 @@ func ping(w http.ResponseWriter, r *http.Request) {
 // about displays the about page
 func (app *application) about(w http.ResponseWriter, r *http.Request) {
 	data := app.NewTemplateData(r)
-	app.render(w, http.StatusOK, "about.html", data)
+	app.render(w, r, http.StatusOK, "about.html", data)
 }
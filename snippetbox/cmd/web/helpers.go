@@ -2,59 +2,154 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/go-playground/form/v4"
 	"github.com/justinas/nosurf"
+	"html/template"
 	"net/http"
+	"net/url"
+	"reflect"
 	"runtime/debug"
+	"snippetbox.audryhsu.com/internal/models"
+	"snippetbox.audryhsu.com/internal/password"
+	"snippetbox.audryhsu.com/internal/secrets"
+	"snippetbox.audryhsu.com/internal/validator"
+	"strings"
 	"time"
 )
 
 // Logs the stack trace using errorLog and responds with a 500 Internal Server error
-func (app *application) serverError(w http.ResponseWriter, err error) {
+// Query timeouts get a 503 Service Unavailable instead, as the request may well succeed if retried.
+// Queries canceled because the client went away get no response and no stack trace, as nothing is wrong with the server and nobody is left to read it.
+func (app *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, models.ErrQueryCanceled) {
+		app.infoLog.Printf("client went away during %s %s (request %s)", r.Method, r.URL.Path, requestIDFrom(r))
+		return
+	}
+
 	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
 	app.errorLog.Output(2, trace)
 
-	// in debug mode, show user the entire error stack trace in browser
-	if *app.debugMode {
-		http.Error(w, trace, http.StatusInternalServerError)
-		return
+	status := http.StatusInternalServerError
+	if errors.Is(err, models.ErrQueryTimeout) {
+		status = http.StatusServiceUnavailable
 	}
 
-	// replies to request with HTTP code and message
-	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	// in debug mode, show user the entire error stack trace in browser
+	if !app.config.debug {
+		trace = ""
+	}
+	app.renderError(w, r, status, trace)
 }
 
 // ex: 400 "Bad Request" when there's a problem with user request.
 // clientError sends a specific status code and corresponding description to the user.
-func (app *application) clientError(w http.ResponseWriter, status int) {
-	http.Error(w, http.StatusText(status), status)
+func (app *application) clientError(w http.ResponseWriter, r *http.Request, status int) {
+	app.renderError(w, r, status, "")
 }
 
 // notFound helper is a convenience wrapper around clientError which sends 404 Not Found to user.
-func (app *application) notFound(w http.ResponseWriter) {
-	app.clientError(w, http.StatusNotFound)
+func (app *application) notFound(w http.ResponseWriter, r *http.Request) {
+	app.clientError(w, r, http.StatusNotFound)
+}
+
+// methodNotAllowed helper is a convenience wrapper around clientError which sends 405 Method Not Allowed to user.
+func (app *application) methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	app.clientError(w, r, http.StatusMethodNotAllowed)
+}
+
+// renderError sends an error response in the site's layout, or as JSON to clients that ask for it. trace is only non-empty in debug mode.
+// It never calls serverError, so a broken error template falls back to a plain-text response instead of looping.
+func (app *application) renderError(w http.ResponseWriter, r *http.Request, status int, trace string) {
+	if wantsJSON(r) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(errorData{Status: status, Message: http.StatusText(status), Trace: trace})
+		return
+	}
+
+	data := app.errorTemplateData(r)
+	data.Error = &errorData{Status: status, Message: http.StatusText(status), Trace: trace}
+
+	// the plain-text fallback still includes the trace, so template parse errors show up in the browser in -dev mode
+	plain := http.StatusText(status)
+	if trace != "" {
+		plain += "\n\n" + trace
+	}
+
+	buf := new(bytes.Buffer)
+	ts, err := app.lookupTemplate(app.locale(r), "error.html")
+	if err != nil {
+		app.errorLog.Output(2, fmt.Sprintf("rendering error page: %s", err))
+		http.Error(w, plain, status)
+		return
+	}
+	if err := ts.ExecuteTemplate(buf, "base", data); err != nil {
+		app.errorLog.Output(2, fmt.Sprintf("rendering error page: %s", err))
+		http.Error(w, plain, status)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	buf.WriteTo(w)
 }
 
-// methodNotAllowed helper is a convenience wrapper around clientError which sends 404 Not Found to user.
-func (app *application) methodNotAllowed(w http.ResponseWriter) {
-	app.clientError(w, http.StatusMethodNotAllowed)
+// errorTemplateData is NewTemplateData for error pages. Errors can happen outside the "dynamic" middleware chain (e.g. in recoverPanic), where there is no session to read the flash from.
+func (app *application) errorTemplateData(r *http.Request) *templateData {
+	if app.sessionLoaded(r) {
+		return app.NewTemplateData(r)
+	}
+	data := &templateData{
+		CurrentYear:     time.Now().Year(),
+		IsAuthenticated: app.isAuthenticated(r),
+		CSPNonce:        cspNonce(r),
+		Locale:          app.locale(r),
+		Locales:         app.localeOptions(),
+	}
+	data.setUser(app.authenticatedUser(r))
+	return data
+}
+
+// sessionLoaded reports whether the LoadAndSave middleware has run for this request. scs panics when a session is used without it, and offers no other way to check.
+func (app *application) sessionLoaded(r *http.Request) (loaded bool) {
+	defer func() {
+		if recover() != nil {
+			loaded = false
+		}
+	}()
+	app.sessionManager.Status(r.Context())
+	return true
+}
+
+// wantsJSON reports whether the client prefers JSON over HTML, judged by which of the two comes first in the Accept header.
+func wantsJSON(r *http.Request) bool {
+	for _, mediaRange := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, _ := strings.Cut(strings.TrimSpace(mediaRange), ";")
+		switch mediaType {
+		case "application/json":
+			return true
+		case "text/html":
+			return false
+		}
+	}
+	return false
 }
 
 // render method will retrieve appropriate template set from cache based on page (e.g. home.html). If no entry exists in cache with name, create a new error and call serverError()
-func (app *application) render(w http.ResponseWriter, status int, page string, data *templateData) {
-	ts, ok := app.templateCache[page]
-	if !ok {
-		err := fmt.Errorf("template %s does not exist", page)
-		app.serverError(w, err)
+func (app *application) render(w http.ResponseWriter, r *http.Request, status int, page string, data *templateData) {
+	ts, err := app.lookupTemplate(app.locale(r), page)
+	if err != nil {
+		app.serverError(w, r, err)
 		return
 	}
 	// Write template to a buffer first to check for error.
 	buf := new(bytes.Buffer)
-	err := ts.ExecuteTemplate(buf, "base", data)
+	err = ts.ExecuteTemplate(buf, "base", data)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
@@ -65,25 +160,87 @@ func (app *application) render(w http.ResponseWriter, status int, page string, d
 	buf.WriteTo(w)
 }
 
+// lookupTemplate returns the template set for a page in a locale. In -dev mode the templates are re-parsed from disk first if they have changed.
+func (app *application) lookupTemplate(locale, page string) (*template.Template, error) {
+	cache := app.templateCache
+	if app.templateReloader != nil {
+		var err error
+		if cache, err = app.templateReloader.get(); err != nil {
+			return nil, err
+		}
+	}
+	ts, ok := cache[locale][page]
+	if !ok {
+		return nil, fmt.Errorf("template %s does not exist", page)
+	}
+	return ts, nil
+}
+
 // NewTemplateData returns a templateData with information about whether a user is authenticated and stores the CSRF token from the http request.
 func (app *application) NewTemplateData(r *http.Request) *templateData {
-	return &templateData{
+	data := &templateData{
 		CurrentYear:     time.Now().Year(),
 		Flash:           app.sessionManager.PopString(r.Context(), "flash"), // flash message is automatically included next any page is rendered
 		IsAuthenticated: app.isAuthenticated(r),                             // add auth status to template data
 		CSRFToken:       nosurf.Token(r),                                    // add CSRF token
+		SSOEnabled:      app.oidc != nil,                                    // offer single sign-on next to the password forms
+		CSPNonce:        cspNonce(r),                                        // add CSP nonce for inline scripts
+		Locale:          app.locale(r),                                      // language of the page and its translated messages
+		Locales:         app.localeOptions(),                                // choices for the language switcher
+	}
+	data.setUser(app.authenticatedUser(r))
+	return data
+}
+
+// setUser adds the logged in user, and what their role lets them do, to the template data.
+func (data *templateData) setUser(user *models.User) {
+	if user == nil {
+		return
 	}
+	data.User = user
+	data.IsModerator = user.Role.AtLeast(models.RoleModerator)
+	data.IsAdmin = user.Role.AtLeast(models.RoleAdmin)
 }
 
+// fieldErrorAdder is implemented by forms that embed validator.Validator.
+type fieldErrorAdder interface {
+	AddFieldError(key string, message validator.Message)
+}
+
+// checkPassword adds an error for field to v if password, a new password, has leaked in a data breach or is too easy to guess. userInputs are things about the user, such as their name and email address, which make poor passwords.
+func (app *application) checkPassword(v *validator.Validator, field, pw string, userInputs ...string) {
+	// a list that can't be read shouldn't stop signups, so only the other checks are made
+	if breached, err := app.breachedPasswords.Contains(pw); err != nil {
+		app.errorLog.Print(err)
+	} else if breached {
+		v.AddFieldError(field, validator.Msg("validation.password_breached"))
+		return
+	}
+	if strength := password.Estimate(pw, userInputs...); strength.Score < app.config.passwordMinScore {
+		// say what makes it weak when a pattern stands out
+		key := "validation.password_weak"
+		if strength.Weakness != "" {
+			key += "_" + string(strength.Weakness)
+		}
+		v.AddFieldError(field, validator.Msg(key))
+	}
+}
+
+// decodePostForm parses the request body and decodes it into dest, a pointer to a form struct.
+// Fields whose values can't be decoded (e.g. expires=abc for an int) are added to the form's FieldErrors, so the handler re-renders the form with the user's other input and a message for each bad field. Only a body that can't be parsed at all returns an error.
 func (app *application) decodePostForm(r *http.Request, dest any) error {
 	// Parse request body to check it is well-formed, and if so, stores form data in r.PostForm map.
 	err := r.ParseForm()
 	if err != nil {
 		return err
 	}
+	return app.decode(r.PostForm, dest)
+}
 
+// decode decodes form values, from a request body or a query string, into dest, a pointer to a form struct. Fields that can't be decoded are handled as in decodePostForm.
+func (app *application) decode(values url.Values, dest any) error {
 	// call Decode() on decoder instance, passing the target destination as the first parameter
-	if err := app.formDecoder.Decode(dest, r.PostForm); err != nil {
+	if err := app.formDecoder.Decode(dest, values); err != nil {
 		// If we try to use an invalid target destination, Decode() method will return an error with the type *form.InvalidDecoderError. Use errors.As() to check for this specific error and panic instead of returning error.
 		// why? if we pass something that isn't a non-nil pointer, this is a problem with our app code, not the user input, so we should handle this differently than returning 400.
 		var invalidDecoderError *form.InvalidDecoderError
@@ -91,12 +248,47 @@ func (app *application) decodePostForm(r *http.Request, dest any) error {
 			app.errorLog.Print("####### INVALID DECODER ERROR #####")
 			panic(err)
 		}
+		// errors in individual fields become validation errors, as long as the form has somewhere to put them
+		var decodeErrors form.DecodeErrors
+		if v, ok := dest.(fieldErrorAdder); ok && errors.As(err, &decodeErrors) {
+			for field := range decodeErrors {
+				v.AddFieldError(field, decodeErrorMessage(dest, field))
+			}
+			return nil
+		}
 		// return err for all other types
 		return err
 	}
 	return nil
 }
 
+// decodeErrorMessage returns the validation error for a form field whose value couldn't be decoded. field is the field's name in the form (its `form` tag), as reported by form.DecodeErrors.
+func decodeErrorMessage(dest any, field string) validator.Message {
+	t := reflect.TypeOf(dest)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("form"), ",")
+		if name == "" {
+			name = f.Name
+		}
+		if name != field {
+			continue
+		}
+		switch f.Type.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			return validator.Msg("validation.number")
+		case reflect.Bool:
+			return validator.Msg("validation.boolean")
+		}
+	}
+	return validator.Msg("validation.invalid")
+}
+
 // isAuthenticated returns true if the current request is from an auth user, otherwise return false.
 func (app *application) isAuthenticated(r *http.Request) bool {
 	isAuthenticated, ok := r.Context().Value(isAuthenticatedContextKey).(bool)
@@ -106,3 +298,60 @@ func (app *application) isAuthenticated(r *http.Request) bool {
 	}
 	return isAuthenticated
 }
+
+// authenticatedUser returns the logged in user, or nil if the request isn't authenticated.
+func (app *application) authenticatedUser(r *http.Request) *models.User {
+	user, _ := r.Context().Value(authenticatedUserContextKey).(*models.User)
+	return user
+}
+
+// canView reports whether the request's user may see snippet. Private snippets are only shown to their author.
+func (app *application) canView(r *http.Request, snippet *models.Snippet) bool {
+	if !snippet.Private {
+		return true
+	}
+	user := app.authenticatedUser(r)
+	return user != nil && user.ID == snippet.UserID
+}
+
+// logSecrets records what the secret scanner found in a new snippet: which rules matched on which lines, but never the secrets themselves.
+func (app *application) logSecrets(r *http.Request, findings []secrets.Finding) {
+	var found []string
+	for _, f := range findings {
+		found = append(found, fmt.Sprintf("%s on line %d", f.Rule, f.Line))
+	}
+	var userID int
+	if user := app.authenticatedUser(r); user != nil {
+		userID = user.ID
+	}
+	app.infoLog.Printf("secret scan (%s): snippet from user %d contains %s", app.config.secretScan, userID, strings.Join(found, ", "))
+}
+
+// locale returns the language to render the response in. Requests that didn't go through negotiateLocale (e.g. errors in recoverPanic) get the best match for their Accept-Language header.
+func (app *application) locale(r *http.Request) string {
+	if locale, ok := r.Context().Value(localeContextKey).(string); ok {
+		return locale
+	}
+	return app.translations.Match(r.Header.Get("Accept-Language"))
+}
+
+// localeOptions lists the supported languages for the language switcher, each named in its own language.
+func (app *application) localeOptions() []localeOption {
+	var options []localeOption
+	for _, locale := range app.translations.Locales() {
+		options = append(options, localeOption{Code: locale, Name: app.translations.Name(locale)})
+	}
+	return options
+}
+
+// localRedirect returns the path and query of the URL ref (usually the Referer header), so that redirecting to it can never leave the site. Anything that isn't a plain local path gives "/".
+func localRedirect(ref string) string {
+	u, err := url.Parse(ref)
+	if err != nil || !strings.HasPrefix(u.Path, "/") || strings.HasPrefix(u.Path, "//") || strings.ContainsRune(u.Path, '\\') {
+		return "/"
+	}
+	if u.RawQuery != "" {
+		return u.EscapedPath() + "?" + u.RawQuery
+	}
+	return u.EscapedPath()
+}
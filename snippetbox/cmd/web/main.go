@@ -1,18 +1,28 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"flag"
 	"github.com/alexedwards/scs/mysqlstore"
+	"github.com/alexedwards/scs/postgresstore"
+	"github.com/alexedwards/scs/sqlite3store"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
 	_ "github.com/go-sql-driver/mysql"
-	"html/template"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"io/fs"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"snippetbox.audryhsu.com/internal/i18n"
 	"snippetbox.audryhsu.com/internal/models"
-	"time"
+	"snippetbox.audryhsu.com/internal/oidc"
+	"snippetbox.audryhsu.com/internal/password"
+	"snippetbox.audryhsu.com/internal/secrets"
+	"snippetbox.audryhsu.com/ui"
 )
 
 // Define an application struct to hold app-wide dependencies.
@@ -20,27 +30,84 @@ type application struct {
 	errorLog *log.Logger
 	infoLog  *log.Logger
 	// inject SnippetModel & UserModel in app to make available to handlers
-	snippets       models.SnippetModelInterface
-	users          models.UserModelInterface
-	templateCache  map[string]*template.Template
+	snippets   models.SnippetModelInterface
+	users      models.UserModelInterface
+	moderation models.ModerationModelInterface
+	// auditLog records security-relevant events; handlers write to it with app.audit
+	auditLog models.AuditModelInterface
+	// sessions tracks which sessions in sessionManager's store belong to which user
+	sessions models.SessionModelInterface
+	// identities links users to their accounts at the single sign-on provider
+	identities models.IdentityModelInterface
+	// oidc is the single sign-on provider users can log in with; nil if -oidc-issuer isn't set
+	oidc *oidc.Provider
+	// secretScanner looks for keys and tokens in new snippets; config.secretScan decides what happens to them
+	secretScanner *secrets.Scanner
+	// breachedPasswords are refused as new passwords; nil if -breached-passwords isn't set
+	breachedPasswords *password.BreachList
+	templateCache     templateCache
+	// translations holds the message catalogs of the supported languages
+	translations *i18n.Bundle
+	// templateReloader is only set in -dev mode, where it replaces templateCache
+	templateReloader *templateReloader
+	// pageVersion identifies the templates and static files, and is part of the ETag of cacheable pages. It is empty in -dev mode, which turns conditional GETs off.
+	pageVersion string
+	// assets serves the static files from ui.Files (or the ui folder on disk in -dev mode) and gives templates their fingerprinted URLs
+	assets         *assetManifest
 	formDecoder    *form.Decoder
 	sessionManager *scs.SessionManager
-	debugMode      *bool
+	config         config
+	securityPolicy securityPolicy
+	// rateLimiters limit how often each client can log in, create snippets and so on (see routes)
+	rateLimiters rateLimiters
+	// trustedProxies are the reverse proxies whose X-Forwarded-For header realIP believes
+	trustedProxies []*net.IPNet
 }
 
 func main() {
-	addr := flag.String("addr", ":4000", "HTTP network address")
-	// Define a new command-line flag for MySQL DSN string
-	dsn := flag.String("dsn", "web:password@/snippetbox?parseTime=true", "MySQL data source name")
-	debug := flag.Bool("debug", false, "denote whether detailed errors and stack traces should be displayed in browser")
-
-	// parse cmd line flags and assign to addr variable.
-	flag.Parse()
-
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	// Pass in the DSN from command line flag
-	db, err := openDB(*dsn)
+
+	// "snippetbox migrate ..." manages the database schema and "snippetbox user ..." user accounts, instead of starting the server
+	args := os.Args[1:]
+	var command string
+	if len(args) > 0 && (args[0] == "migrate" || args[0] == "user") {
+		command, args = args[0], args[1:]
+	}
+
+	// load settings from flags, SNIPPETBOX_* env vars and an optional config file (see config.go for precedence)
+	cfg, err := loadConfig(args, os.Getenv)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		errorLog.Fatal(err)
+	}
+	if cfg.printConfig {
+		cfg.print(os.Stdout)
+		return
+	}
+	switch command {
+	case "migrate":
+		if err := runMigrate(cfg, os.Stdout); err != nil {
+			errorLog.Fatal(err)
+		}
+		return
+	case "user":
+		if err := runUser(cfg, os.Stdout); err != nil {
+			errorLog.Fatal(err)
+		}
+		return
+	}
+
+	// look up the SQL dialect for the configured database driver
+	dialect, err := models.DialectFor(cfg.dbDriver)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	// Pass in the driver and DSN from config
+	db, err := openDB(dialect, cfg.dsn)
 	if err != nil {
 		errorLog.Print("Couldn't connect to DB")
 		errorLog.Fatal(err)
@@ -48,8 +115,43 @@ func main() {
 
 	// close connection pool before main() function exits.
 	defer db.Close()
+
+	// optionally bring the schema up to date before serving any requests
+	if cfg.autoMigrate {
+		if err := autoMigrate(db, dialect, infoLog); err != nil {
+			errorLog.Fatal(err)
+		}
+	}
+	// production builds serve the templates and static files embedded in ui.Files; -dev reads them from disk so edits show up without a rebuild
+	var uiFiles fs.FS = ui.Files
+	if cfg.dev {
+		uiFiles = os.DirFS(cfg.uiDir)
+		infoLog.Printf("Development mode: serving templates and static files from %s", cfg.uiDir)
+	}
+
+	// hash the static files so templates can link to them with URLs that are cached forever (fingerprinting is off in -dev mode)
+	assets, err := newAssetManifest(uiFiles, cfg.dev)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	var pageVersion string
+	if !cfg.dev {
+		if pageVersion, err = fsVersion(uiFiles); err != nil {
+			errorLog.Fatal(err)
+		}
+	}
+	// load the message catalogs that templates and validation errors are translated with
+	translations, err := i18n.New()
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	var reloader *templateReloader
+	if cfg.dev {
+		reloader = &templateReloader{fsys: uiFiles, assets: assets, translations: translations}
+	}
+
 	// initialize new template cache to add to app dependencies
-	templateCache, err := NewTemplateCache()
+	templateCache, err := NewTemplateCache(uiFiles, assets, translations)
 	if err != nil {
 		errorLog.Fatal(err)
 	}
@@ -57,37 +159,88 @@ func main() {
 	// create an instance of form decoder and inject as app dependency
 	formDecoder := form.NewDecoder()
 
-	// initialize a new session manager and configure it to use our MySQL db as session store
-	// set lifetime of 12 hours (automatic expiry)
+	// initialize a new session manager and configure it to use our db as session store
+	// The store keeps sessions for as long as a "remember me" login lasts. Only those get a persistent cookie; the shorter lifetime and idle timeout of other logins are enforced by authenticate (see trackSession).
 	sessionManager := scs.New()
-	sessionManager.Store = mysqlstore.New(db)
-	sessionManager.Lifetime = 12 * time.Hour
+	sessionManager.Store = newSessionStore(dialect, db)
+	sessionManager.Lifetime = cfg.rememberLifetime
+	sessionManager.Cookie.Persist = false
+
+	// open the leaked passwords that can't be used as new ones, searched on disk
+	var breachedPasswords *password.BreachList
+	if cfg.breachedPasswords != "" {
+		if breachedPasswords, err = password.OpenBreachList(cfg.breachedPasswords); err != nil {
+			errorLog.Fatal(err)
+		}
+		defer breachedPasswords.Close()
+		infoLog.Printf("Checking new passwords against the breached passwords in %s", cfg.breachedPasswords)
+	}
+
+	// look up the single sign-on provider's endpoints
+	var provider *oidc.Provider
+	if cfg.oidcIssuer != "" {
+		provider, err = oidc.Discover(context.Background(), oidc.Config{
+			Issuer:       cfg.oidcIssuer,
+			ClientID:     cfg.oidcClientID,
+			ClientSecret: cfg.oidcClientSecret,
+			RedirectURL:  cfg.oidcRedirectURL,
+		})
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		infoLog.Printf("Single sign-on with %s", cfg.oidcIssuer)
+	}
+
+	// validate has checked the proxies
+	trustedProxies, _ := parseTrustedProxies(cfg.trustedProxies)
+
+	store := models.Store{DB: db, Dialect: dialect, QueryTimeout: cfg.queryTimeout}
+
+	// initialize a SnippetModel instance, behind a read-through cache unless -snippet-cache-size is 0. The cache's hits and misses are shown on the admin dashboard.
+	var snippets models.SnippetModelInterface = &models.SnippetModel{Store: store}
+	if cfg.snippetCacheSize > 0 {
+		snippets = models.NewCachedSnippetModel(snippets, cfg.snippetCacheSize, cfg.snippetCacheTTL)
+	}
 
 	app := &application{
-		infoLog:        infoLog,
-		errorLog:       errorLog,
-		snippets:       &models.SnippetModel{DB: db}, // initialize a SnippetModel instance
-		users:          &models.UserModel{DB: db},    // initialize a UserModel instance
-		templateCache:  templateCache,
-		formDecoder:    formDecoder,
-		sessionManager: sessionManager,
-		debugMode:      debug,
+		infoLog:           infoLog,
+		errorLog:          errorLog,
+		snippets:          snippets,
+		users:             &models.UserModel{Store: store, Hasher: cfg.passwordHasher(), ErrorLog: errorLog}, // initialize a UserModel instance
+		moderation:        &models.ModerationModel{Store: store},
+		auditLog:          &models.AuditModel{Store: store},
+		sessions:          &models.SessionModel{Store: store},
+		identities:        &models.IdentityModel{Store: store},
+		oidc:              provider,
+		secretScanner:     secrets.New(),
+		breachedPasswords: breachedPasswords,
+		templateCache:     templateCache,
+		translations:      translations,
+		templateReloader:  reloader,
+		assets:            assets,
+		pageVersion:       pageVersion,
+		formDecoder:       formDecoder,
+		sessionManager:    sessionManager,
+		config:            cfg,
+		securityPolicy:    newSecurityPolicy(cfg),
+		rateLimiters:      newRateLimiters(cfg),
+		trustedProxies:    trustedProxies,
 	}
 
 	srv := &http.Server{
-		Addr:     *addr,
+		Addr:     cfg.addr,
 		ErrorLog: errorLog,
 		Handler:  app.routes(), // Call app.routes() to get servemux containing our routes
 	}
-	infoLog.Printf("Starting server on %s", *addr)
+	infoLog.Printf("Starting server on %s", cfg.addr)
 	// call ListenAndServe() method on new http.Server struct
 	err = srv.ListenAndServe()
 	errorLog.Fatal(err)
 }
 
-// openDB() function wraps sql.Open() and returns a sql.DB connection pool for a given DSN
-func openDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("mysql", dsn)
+// openDB() function wraps sql.Open() and returns a sql.DB connection pool for a given dialect and DSN
+func openDB(dialect models.Dialect, dsn string) (*sql.DB, error) {
+	db, err := sql.Open(dialect.DriverName(), dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -97,3 +250,15 @@ func openDB(dsn string) (*sql.DB, error) {
 	}
 	return db, nil
 }
+
+// newSessionStore returns the scs session store that keeps sessions in the "sessions" table of the given database.
+func newSessionStore(dialect models.Dialect, db *sql.DB) scs.Store {
+	switch dialect {
+	case models.SQLite:
+		return sqlite3store.New(db)
+	case models.Postgres:
+		return postgresstore.New(db)
+	default:
+		return mysqlstore.New(db)
+	}
+}
@@ -2,24 +2,46 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"github.com/justinas/alice"
 	"github.com/justinas/nosurf"
 	"net/http"
+	"snippetbox.audryhsu.com/internal/models"
 )
 
-// secureHeaders sets Http security heads
-func secureHeaders(next http.Handler) http.Handler {
+// secureHeaders sets Http security heads from app.securityPolicy. Each request gets a fresh CSP nonce, which is stored in the request context for NewTemplateData.
+func (app *application) secureHeaders(next http.Handler) http.Handler {
 	// http.HandlerFunc adapts a regular function into a http handler
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce, err := newNonce()
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		r = withCSPNonce(r, nonce)
 
-		w.Header().Set("Content-Security-Policy",
-			"default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com")
-
-		w.Header().Set("Referer-Policy", "origin-when-cross-origin")
+		policy := app.securityPolicy
+		w.Header().Set(policy.cspHeader(nonce))
+		w.Header().Set("Referrer-Policy", policy.referrerPolicy)
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.Header().Set("X-Frame-Options", "deny")
 		w.Header().Set("X-XSS-Protection", "0")
 
+		// optional headers are only sent when configured
+		if policy.permissionsPolicy != "" {
+			w.Header().Set("Permissions-Policy", policy.permissionsPolicy)
+		}
+		if policy.coop != "" {
+			w.Header().Set("Cross-Origin-Opener-Policy", policy.coop)
+		}
+		if policy.coep != "" {
+			w.Header().Set("Cross-Origin-Embedder-Policy", policy.coep)
+		}
+		if policy.hsts != "" {
+			w.Header().Set("Strict-Transport-Security", policy.hsts)
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
@@ -27,7 +49,7 @@ func secureHeaders(next http.Handler) http.Handler {
 // logRequest records the IP address of user and URL and method being requested. Method on app struct.
 func (app *application) logRequest(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		app.infoLog.Printf("%s - %s %s %s", r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI())
+		app.infoLog.Printf("%s - %s %s %s (request %s)", r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI(), requestIDFrom(r))
 
 		next.ServeHTTP(w, r)
 	})
@@ -43,7 +65,7 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 				// set a Connection: close header on response
 				w.Header().Set("Connection", "close")
 				// Call app.serverError helper method to return 500 response, and pass in a new error object
-				app.serverError(w, fmt.Errorf("%s", err))
+				app.serverError(w, r, fmt.Errorf("%s", err))
 			}
 		}()
 		next.ServeHTTP(w, r)
@@ -65,7 +87,8 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
-// authenticate is middleware that authenticates a user request by checking if the `authenticatedUserID` is in the session store and a valid user id in the users table. If so, it updates the isAuthenticatedContextKey to `true`.
+// authenticate is middleware that authenticates a user request by checking if the `authenticatedUserID` is in the session store and a valid user id in the users table. If so, it updates the isAuthenticatedContextKey to `true` and stores the user in the request context.
+// The user is loaded from the database on every request, never cached in the session, so disabling an account or changing its role takes effect immediately.
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// get authenticatedUserID from session data
@@ -77,14 +100,23 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		// if there is an auth user ID in session data, check db to see if user id exists in database
-		if exists, err := app.users.Exists(userId); err != nil {
-			app.serverError(w, err)
+		// if there is an auth user ID in session data, look the user up in the database; a deleted or disabled user is no longer authenticated, and neither is a revoked session
+		user, err := app.users.Get(r.Context(), userId)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, r, err)
 			return
-		} else if exists {
+		} else if err != nil || user.Disabled {
+			// log the session out, so this is recorded once rather than on every request it makes
+			app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+			app.audit(r, models.AuditSessionRejected, userId, "")
+		} else if tracked, err := app.trackSession(r); err != nil {
+			app.serverError(w, r, err)
+			return
+		} else if tracked {
 			// update request context to include new context key indicated auth is good
 			// create a copy of the request with new context
 			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+			ctx = context.WithValue(ctx, authenticatedUserContextKey, user)
 			r = r.WithContext(ctx)
 		}
 
@@ -92,6 +124,53 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 	})
 }
 
+// requireRole returns middleware that only lets through users with at least the given role; others get 403 Forbidden. It goes after requireAuthentication in a chain, e.g. protected.Append(app.requireRole(models.RoleAdmin)).
+func (app *application) requireRole(role models.Role) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := app.authenticatedUser(r)
+			if user == nil {
+				http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+				return
+			}
+			if !user.Role.AtLeast(role) {
+				app.clientError(w, r, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// negotiateLocale picks the language of the response: the logged in user's saved choice, then the choice of an anonymous visitor saved in their session, then the browser's Accept-Language header. It must come after authenticate.
+func (app *application) negotiateLocale(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var userLocale string
+		if user := app.authenticatedUser(r); user != nil {
+			userLocale = user.Locale
+		}
+		locale := app.translations.Match(userLocale, app.sessionManager.GetString(r.Context(), "locale"), r.Header.Get("Accept-Language"))
+
+		w.Header().Set("Content-Language", locale)
+		addVary(w.Header(), "Accept-Language")
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), localeContextKey, locale)))
+	})
+}
+
+// limitRequestBody caps request bodies at -max-form-bytes. It must run before anything reads the form (noSurf reads it to find the CSRF token), so oversized bodies are never buffered.
+// Bodies that declare a larger Content-Length are refused up front with 413 Request Entity Too Large. Bodies of unknown length are cut off at the limit, which fails the request with a 400.
+func (app *application) limitRequestBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > app.config.maxFormBytes {
+			w.Header().Set("Connection", "close")
+			app.clientError(w, r, http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, app.config.maxFormBytes)
+		next.ServeHTTP(w, r)
+	})
+}
+
 // noSurf middleware function creates a CSRF handler that will call the next middleware if the check passes. Check uses a customized CSRF http cookie
 func (app *application) noSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
@@ -100,5 +179,16 @@ func (app *application) noSurf(next http.Handler) http.Handler {
 		Path:     "/",
 		Secure:   true,
 	})
+	// failed CSRF checks get the same styled 400 page as other client errors
+	csrfHandler.SetFailureHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.clientError(w, r, http.StatusBadRequest)
+	}))
+	return csrfHandler
+}
+
+// noSurfExempt is noSurf without the token check. It is used by the 404/405 handlers, which must answer any method and only need the token to render the logout form in the nav.
+func (app *application) noSurfExempt(next http.Handler) http.Handler {
+	csrfHandler := app.noSurf(next).(*nosurf.CSRFHandler)
+	csrfHandler.ExemptFunc(func(r *http.Request) bool { return true })
 	return csrfHandler
 }
@@ -3,10 +3,15 @@ package main
 import (
 	"bytes"
 	"io"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
 	"snippetbox.audryhsu.com/internal/assert"
+	"snippetbox.audryhsu.com/internal/ratelimit"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestSecureHeaders(t *testing.T) {
@@ -15,15 +20,22 @@ func TestSecureHeaders(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	// create and pass a mock HTTP handler to secureHeaders middleware
+	// create and pass a mock HTTP handler to secureHeaders middleware, which records the nonce it was given
+	var nonce string
 	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = cspNonce(r)
 		w.Write([]byte("next handler was called"))
 	})
 	// Mock middleware chain
 	// Because secureHeaders returns a http.Handler, call the ServeHTTP() method with response recorder and dummy req
-	secureHeaders(next).ServeHTTP(rr, req)
+	app := newTestApplication(t)
+	app.secureHeaders(next).ServeHTTP(rr, req)
 	res := rr.Result()
 
+	if nonce == "" {
+		t.Fatal("no CSP nonce in request context")
+	}
+
 	// Check that middleware correctly set headers on responses
 	tests := []struct {
 		header   string
@@ -31,10 +43,10 @@ func TestSecureHeaders(t *testing.T) {
 	}{
 		{
 			header:   "Content-Security-Policy",
-			expected: "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com",
+			expected: "default-src 'self'; style-src 'self' fonts.googleapis.com; font-src fonts.gstatic.com; script-src 'self' 'nonce-" + nonce + "'; report-uri /csp-report",
 		},
 		{
-			header:   "Referer-Policy",
+			header:   "Referrer-Policy",
 			expected: "origin-when-cross-origin",
 		},
 		{
@@ -49,6 +61,19 @@ func TestSecureHeaders(t *testing.T) {
 			header:   "X-XSS-Protection",
 			expected: "0",
 		},
+		{
+			header:   "Permissions-Policy",
+			expected: "camera=(), microphone=(), geolocation=(), payment=()",
+		},
+		{
+			header:   "Cross-Origin-Opener-Policy",
+			expected: "same-origin",
+		},
+		{
+			// optional headers are left out unless configured
+			header:   "Strict-Transport-Security",
+			expected: "",
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.header, func(t *testing.T) {
@@ -66,3 +91,137 @@ func TestSecureHeaders(t *testing.T) {
 	assert.Equal(t, string(body), "next handler was called")
 
 }
+
+// TestSecurityPolicy checks that config changes the headers secureHeaders sends.
+func TestSecurityPolicy(t *testing.T) {
+	cfg := defaultConfig()
+	cfg.csp = "default-src 'none'; script-src 'self' cdn.example.com"
+	cfg.cspReportOnly = true
+	cfg.coep = "require-corp"
+	cfg.hstsMaxAge = 365 * 24 * time.Hour
+	cfg.hstsIncludeSubdomains = true
+
+	app := newTestApplication(t)
+	app.securityPolicy = newSecurityPolicy(cfg)
+
+	// two requests must get different nonces
+	var nonces []string
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		app.secureHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nonces = append(nonces, cspNonce(r))
+		})).ServeHTTP(rr, req)
+
+		res := rr.Result()
+		assert.Equal(t, res.Header.Get("Content-Security-Policy"), "")
+		assert.Equal(t, res.Header.Get("Content-Security-Policy-Report-Only"),
+			"default-src 'none'; script-src 'self' cdn.example.com 'nonce-"+nonces[i]+"'; report-uri /csp-report")
+		assert.Equal(t, res.Header.Get("Cross-Origin-Embedder-Policy"), "require-corp")
+		assert.Equal(t, res.Header.Get("Strict-Transport-Security"), "max-age=31536000; includeSubDomains")
+	}
+	if nonces[0] == nonces[1] {
+		t.Error("expected a fresh nonce for each request")
+	}
+}
+
+func TestCSPReport(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{
+			name:     "valid report",
+			body:     `{"csp-report": {"document-uri": "https://example.com/", "violated-directive": "script-src", "blocked-uri": "inline"}}`,
+			wantCode: http.StatusNoContent,
+		},
+		{
+			name:     "not a report",
+			body:     `{"hello": "world"}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "too large",
+			body:     `{"csp-report": {"blocked-uri": "` + strings.Repeat("a", 70<<10) + `"}}`,
+			wantCode: http.StatusRequestEntityTooLarge,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := ts.Client().Post(ts.URL+"/csp-report", "application/csp-report", strings.NewReader(test.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res.Body.Close()
+			assert.Equal(t, res.StatusCode, test.wantCode)
+		})
+	}
+}
+
+// TestCSPReportLog checks that reports can't forge log lines, and that each client can only send so many.
+func TestCSPReportLog(t *testing.T) {
+	app := newTestApplication(t)
+	var infoLog bytes.Buffer
+	app.infoLog = log.New(&infoLog, "", 0)
+	app.rateLimiters.csp = ratelimit.New(ratelimit.Per(1, time.Minute))
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	body := `{"csp-report": {"document-uri": "https://example.com/\nERROR forged", "violated-directive": "script-src", "blocked-uri": "inline", "source-file": "a.js\nERROR forged"}}`
+	for _, wantCode := range []int{http.StatusNoContent, http.StatusTooManyRequests} {
+		res, err := ts.Client().Post(ts.URL+"/csp-report", "application/csp-report", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		assert.Equal(t, res.StatusCode, wantCode)
+	}
+
+	assert.StringContains(t, infoLog.String(), `on "https://example.com/\nERROR forged" ("a.js\nERROR forged":0)`)
+	for _, line := range strings.Split(infoLog.String(), "\n") {
+		if strings.HasPrefix(line, "ERROR") {
+			t.Errorf("forged log line %q", line)
+		}
+	}
+}
+
+// TestCSPNonceInPage checks that the nonce in the header is the one templates put on script tags.
+func TestCSPNonceInPage(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, headers, body := ts.get(t, "/about")
+	matches := regexp.MustCompile(`'nonce-([^']+)'`).FindStringSubmatch(headers.Get("Content-Security-Policy"))
+	if len(matches) < 2 {
+		t.Fatal("no nonce in Content-Security-Policy")
+	}
+	assert.StringContains(t, body, "nonce='"+matches[1]+"'")
+}
+
+func TestLocalRedirect(t *testing.T) {
+	tests := []struct {
+		name string
+		ref  string
+		want string
+	}{
+		{name: "Empty", ref: "", want: "/"},
+		{name: "Path", ref: "/about", want: "/about"},
+		{name: "Absolute URL", ref: "https://snippetbox.example/snippet/view/1?a=b", want: "/snippet/view/1?a=b"},
+		{name: "Protocol-relative", ref: "//evil.example/", want: "/"},
+		{name: "Backslash", ref: "/\\evil.example/", want: "/"},
+		{name: "Relative", ref: "about", want: "/"},
+		{name: "Invalid", ref: "%zz", want: "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, localRedirect(tt.ref), tt.want)
+		})
+	}
+}
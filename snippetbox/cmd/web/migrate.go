@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"snippetbox.audryhsu.com/internal/migrate"
+	"snippetbox.audryhsu.com/internal/models"
+	"snippetbox.audryhsu.com/migrations"
+	"strconv"
+)
+
+const migrateUsage = `usage: snippetbox migrate [flags] up|down [n]|status|create <name>|baseline <version>`
+
+// runMigrate implements the "snippetbox migrate" subcommands using the database settings from cfg and the migrations embedded in migrations.Files.
+func runMigrate(cfg config, out io.Writer) error {
+	if len(cfg.args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	command, args := cfg.args[0], cfg.args[1:]
+
+	// create only writes files, so it doesn't need a database connection. New migrations are created for every driver so the schemas stay in step.
+	if command == "create" {
+		if len(args) != 1 {
+			return errors.New(migrateUsage)
+		}
+		files, err := migrate.Create(cfg.migrationsDir, args[0], models.MySQL, models.SQLite, models.Postgres)
+		for _, file := range files {
+			fmt.Fprintf(out, "created %s\n", file)
+		}
+		return err
+	}
+
+	dialect, err := models.DialectFor(cfg.dbDriver)
+	if err != nil {
+		return err
+	}
+	db, err := openDB(dialect, cfg.dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	migrator, err := migrate.New(db, dialect, migrations.Files)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	switch command {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Fprintf(out, "applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Fprintln(out, "no pending migrations")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 0 {
+			if steps, err = strconv.Atoi(args[0]); err != nil || steps < 1 {
+				return fmt.Errorf("migrate: down takes a positive number of steps, got %q", args[0])
+			}
+		}
+		rolledBack, err := migrator.Down(ctx, steps)
+		for _, m := range rolledBack {
+			fmt.Fprintf(out, "rolled back %04d_%s\n", m.Version, m.Name)
+		}
+		return err
+	case "baseline":
+		// for databases made before migrations were tracked, e.g. "baseline 3" when snippets, users and sessions were created by hand
+		if len(args) != 1 {
+			return errors.New(migrateUsage)
+		}
+		version, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("migrate: baseline takes a migration version, got %q", args[0])
+		}
+		recorded, err := migrator.Baseline(ctx, version)
+		for _, m := range recorded {
+			fmt.Fprintf(out, "recorded %04d_%s as applied\n", m.Version, m.Name)
+		}
+		return err
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied " + s.AppliedAt.UTC().Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(out, "%04d_%-30s %s\n", s.Version, s.Name, state)
+		}
+		return nil
+	default:
+		return fmt.Errorf("migrate: unknown command %q\n%s", command, migrateUsage)
+	}
+}
+
+// autoMigrate applies any pending migrations at startup when -auto-migrate is set.
+func autoMigrate(db *sql.DB, dialect models.Dialect, infoLog *log.Logger) error {
+	migrator, err := migrate.New(db, dialect, migrations.Files)
+	if err != nil {
+		return err
+	}
+	applied, err := migrator.Up(context.Background())
+	for _, m := range applied {
+		infoLog.Printf("applied migration %04d_%s", m.Version, m.Name)
+	}
+	return err
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"snippetbox.audryhsu.com/internal/models"
+	"snippetbox.audryhsu.com/internal/oidc"
+	"strings"
+	"time"
+)
+
+var (
+	// errSSOUnverifiedEmail means the provider doesn't vouch for the user's email address, so it can't be used to find or make their account.
+	errSSOUnverifiedEmail = errors.New("sso: email address not verified")
+	// errSSONoAccount means nobody has the user's email address, and no account is to be made for them.
+	errSSONoAccount = errors.New("sso: no account")
+)
+
+// oidcLogin starts a single sign-on login. The login's secrets are kept in the session until the provider sends the browser back to oidcCallback, and next, where to go afterwards, with them.
+func (app *application) oidcLogin(w http.ResponseWriter, r *http.Request) {
+	req, err := oidc.NewAuthRequest()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.sessionManager.Put(r.Context(), "oidcState", req.State)
+	app.sessionManager.Put(r.Context(), "oidcNonce", req.Nonce)
+	app.sessionManager.Put(r.Context(), "oidcVerifier", req.Verifier)
+	if next := r.URL.Query().Get("next"); next != "" {
+		app.sessionManager.Put(r.Context(), "oidcNext", localRedirect(next))
+	}
+
+	// a logged in user is confirming their identity for a sensitive action, so the provider must ask for their password too, rather than let them through on its own session
+	var extra url.Values
+	if app.isAuthenticated(r) {
+		extra = url.Values{"prompt": {"login"}}
+	}
+	http.Redirect(w, r, app.oidc.AuthCodeURL(req, extra), http.StatusSeeOther)
+}
+
+// oidcCallback finishes a single sign-on login: it swaps the code the provider sent the browser back with for the user's identity, and logs them in the same way as userLoginPost.
+func (app *application) oidcCallback(w http.ResponseWriter, r *http.Request) {
+	// the secrets are for one try only
+	req := &oidc.AuthRequest{
+		State:    app.sessionManager.PopString(r.Context(), "oidcState"),
+		Nonce:    app.sessionManager.PopString(r.Context(), "oidcNonce"),
+		Verifier: app.sessionManager.PopString(r.Context(), "oidcVerifier"),
+	}
+	next := app.sessionManager.PopString(r.Context(), "oidcNext")
+
+	// a state that doesn't match means the login wasn't started in this browser, e.g. someone is trying to log the user in to their own account
+	q := r.URL.Query()
+	if req.State == "" || subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(req.State)) != 1 {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+	// e.g. access_denied, when the user cancels at the provider
+	if code := q.Get("error"); code != "" {
+		app.infoLog.Printf("single sign-on refused by the provider: %s %s", code, q.Get("error_description"))
+		app.oidcFailed(w, r, "flash.sso_failed", next)
+		return
+	}
+	claims, err := app.oidc.Exchange(r.Context(), q.Get("code"), req)
+	if err != nil {
+		app.errorLog.Printf("single sign-on: %v", err)
+		if errors.Is(err, oidc.ErrInvalidToken) {
+			app.audit(r, models.AuditLoginFailed, 0, "(sso, invalid token)")
+		}
+		app.oidcFailed(w, r, "flash.sso_failed", next)
+		return
+	}
+
+	// a logged in user is confirming their identity (see requireRecentAuth), which mustn't make a new account
+	current := app.authenticatedUser(r)
+	id, created, err := app.oidcUser(r.Context(), claims, app.config.oidcAutoCreate && current == nil)
+	switch {
+	case errors.Is(err, errSSOUnverifiedEmail):
+		app.audit(r, models.AuditLoginFailed, 0, claims.Email+" (sso, unverified email)")
+		app.oidcFailed(w, r, "flash.sso_unverified_email", next)
+		return
+	case errors.Is(err, errSSONoAccount) && current != nil:
+		app.audit(r, models.AuditLoginFailed, current.ID, claims.Email+" (sso confirmation)")
+		app.oidcFailed(w, r, "flash.sso_wrong_account", next)
+		return
+	case errors.Is(err, errSSONoAccount):
+		app.audit(r, models.AuditLoginFailed, 0, claims.Email+" (sso, no account)")
+		app.oidcFailed(w, r, "flash.sso_no_account", next)
+		return
+	case err != nil:
+		app.serverError(w, r, err)
+		return
+	}
+	user, err := app.users.Get(r.Context(), id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if created {
+		app.audit(r, models.AuditSignup, id, user.Email+" (sso)")
+	}
+	if user.Disabled {
+		app.audit(r, models.AuditLoginFailed, 0, user.Email+" (sso, account disabled)")
+		app.oidcFailed(w, r, "flash.sso_account_disabled", next)
+		return
+	}
+
+	if current != nil {
+		if current.ID != id {
+			app.audit(r, models.AuditLoginFailed, current.ID, user.Email+" (sso confirmation)")
+			app.oidcFailed(w, r, "flash.sso_wrong_account", next)
+			return
+		}
+		app.sessionManager.Put(r.Context(), "authenticatedAt", time.Now().Unix())
+		app.audit(r, models.AuditReauth, id, "sso")
+		http.Redirect(w, r, localRedirect(next), http.StatusSeeOther)
+		return
+	}
+
+	if err := app.startSession(r, id, false); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.audit(r, models.AuditLogin, id, "sso")
+	if next == "" {
+		next = "/snippet/create"
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// oidcFailed tells the user why single sign-on didn't work and sends them back to where they started it: the login page, or the password confirmation page for a logged in user.
+func (app *application) oidcFailed(w http.ResponseWriter, r *http.Request, flash, next string) {
+	app.sessionManager.Put(r.Context(), "flash", flash)
+	if app.isAuthenticated(r) {
+		http.Redirect(w, r, "/user/confirm?next="+url.QueryEscape(localRedirect(next)), http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// oidcUser returns the ID of the user that claims are about: the user their identity is linked to, or else the user with their email address, who is linked to it from then on. Someone with no account gets one if create is set, and created reports that.
+// Email addresses are only trusted when the provider has verified them, as anyone could otherwise take over an account by giving its address to the provider.
+func (app *application) oidcUser(ctx context.Context, claims *oidc.Claims, create bool) (id int, created bool, err error) {
+	identity, err := app.identities.Get(ctx, claims.Issuer, claims.Subject)
+	if err == nil {
+		return identity.UserID, false, nil
+	} else if !errors.Is(err, models.ErrNoRecord) {
+		return 0, false, err
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		return 0, false, errSSOUnverifiedEmail
+	}
+	user, err := app.users.GetByEmail(ctx, claims.Email)
+	switch {
+	case err == nil:
+		id = user.ID
+	case errors.Is(err, models.ErrNoRecord) && create:
+		if id, err = app.createSSOUser(ctx, claims); err != nil {
+			return 0, false, err
+		}
+		created = true
+	case errors.Is(err, models.ErrNoRecord):
+		return 0, false, errSSONoAccount
+	default:
+		return 0, false, err
+	}
+
+	if err := app.identities.Insert(ctx, id, claims.Issuer, claims.Subject); err != nil && !errors.Is(err, models.ErrDuplicateIdentity) {
+		return 0, false, err
+	}
+	return id, created, nil
+}
+
+// createSSOUser makes an account for a single sign-on user and returns its ID. The account gets a random password nobody knows, so it can only be logged in to with single sign-on.
+func (app *application) createSSOUser(ctx context.Context, claims *oidc.Claims) (int, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return 0, err
+	}
+	name := strings.TrimSpace(claims.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+	// an account made meanwhile, e.g. by a second login in another tab, is used instead
+	if err := app.users.Insert(ctx, name, claims.Email, base64.RawURLEncoding.EncodeToString(b)); err != nil && !errors.Is(err, models.ErrDuplicateEmail) {
+		return 0, err
+	}
+	user, err := app.users.GetByEmail(ctx, claims.Email)
+	if err != nil {
+		return 0, err
+	}
+	return user.ID, nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"snippetbox.audryhsu.com/internal/assert"
+	"snippetbox.audryhsu.com/internal/models"
+	"snippetbox.audryhsu.com/internal/oidc"
+	"snippetbox.audryhsu.com/internal/oidc/oidctest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newSSOTestServer returns a test server for app with single sign-on through a stand-in provider, which is returned too.
+func newSSOTestServer(t *testing.T, app *application) (*testServer, *oidctest.Server) {
+	idp := oidctest.NewServer()
+	t.Cleanup(idp.Close)
+	provider, err := oidc.Discover(context.Background(), oidc.Config{
+		Issuer:       idp.URL,
+		ClientID:     idp.ClientID,
+		ClientSecret: idp.ClientSecret,
+		// the test follows the provider's redirect to the test server itself, see ssoLogin
+		RedirectURL: "https://snippetbox.example.com/user/oidc/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.oidc = provider
+	ts := newTestServer(t, app.routes())
+	t.Cleanup(ts.Close)
+	return ts, idp
+}
+
+// ssoLogin starts single sign-on at urlPath, lets the stand-in provider send the browser back, and returns the callback's response.
+func (ts *testServer) ssoLogin(t *testing.T, idp *oidctest.Server, urlPath string) (int, http.Header, string) {
+	code, header, _ := ts.get(t, urlPath)
+	assert.Equal(t, code, http.StatusSeeOther)
+	callback, err := idp.Authorize(header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, callback.Path, "/user/oidc/callback")
+	return ts.get(t, callback.RequestURI())
+}
+
+func TestSSOLogin(t *testing.T) {
+	tests := []struct {
+		name         string
+		user         oidctest.User
+		autoCreate   bool
+		disabled     bool
+		wantLocation string
+		wantFlash    string
+		// wantUserID is the user the identity is linked to afterwards, 0 for none
+		wantUserID int
+		wantAudit  []models.AuditAction
+	}{
+		{
+			name:         "Linked by verified email",
+			user:         oidctest.User{Subject: "alice-1", Email: "alice@example.com", EmailVerified: true},
+			autoCreate:   true,
+			wantLocation: "/snippet/create",
+			wantUserID:   1,
+			wantAudit:    []models.AuditAction{models.AuditLogin},
+		},
+		{
+			name:         "Created",
+			user:         oidctest.User{Subject: "jane-1", Email: "jane@example.com", EmailVerified: true, Name: "Jane Doe"},
+			autoCreate:   true,
+			wantLocation: "/snippet/create",
+			wantUserID:   2,
+			wantAudit:    []models.AuditAction{models.AuditSignup, models.AuditLogin},
+		},
+		{
+			name:         "No account",
+			user:         oidctest.User{Subject: "jane-1", Email: "jane@example.com", EmailVerified: true},
+			wantLocation: "/user/login",
+			wantFlash:    "There is no account with your email address yet",
+			wantAudit:    []models.AuditAction{models.AuditLoginFailed},
+		},
+		{
+			name:         "Unverified email",
+			user:         oidctest.User{Subject: "mallory-1", Email: "alice@example.com"},
+			autoCreate:   true,
+			wantLocation: "/user/login",
+			wantFlash:    "no verified email address",
+			wantAudit:    []models.AuditAction{models.AuditLoginFailed},
+		},
+		{
+			name:         "Disabled",
+			user:         oidctest.User{Subject: "alice-1", Email: "alice@example.com", EmailVerified: true},
+			autoCreate:   true,
+			disabled:     true,
+			wantLocation: "/user/login",
+			wantFlash:    "Your account has been disabled",
+			wantUserID:   1,
+			wantAudit:    []models.AuditAction{models.AuditLoginFailed},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.config.oidcAutoCreate = tt.autoCreate
+			app.users.SetDisabled(context.Background(), 1, tt.disabled)
+			ts, idp := newSSOTestServer(t, app)
+			idp.User = tt.user
+
+			code, header, _ := ts.ssoLogin(t, idp, "/user/oidc/login")
+			assert.Equal(t, code, http.StatusSeeOther)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+
+			// logged in if sent on to create a snippet, otherwise told why not on the login page
+			code, _, body := ts.get(t, tt.wantLocation)
+			assert.Equal(t, code, http.StatusOK)
+			assert.StringContains(t, body, tt.wantFlash)
+
+			identity, err := app.identities.Get(context.Background(), idp.URL, tt.user.Subject)
+			if tt.wantUserID == 0 {
+				assert.Equal(t, err, models.ErrNoRecord)
+			} else {
+				assert.Equal(t, err, nil)
+				assert.Equal(t, identity.UserID, tt.wantUserID)
+			}
+			var actions []models.AuditAction
+			for _, event := range auditEvents(t, app) {
+				actions = append(actions, event.Action)
+			}
+			assert.Equal(t, len(actions), len(tt.wantAudit))
+			for i := range tt.wantAudit {
+				assert.Equal(t, actions[i], tt.wantAudit[i])
+			}
+		})
+	}
+}
+
+// TestSSOLinkedIdentity checks that a linked identity keeps logging in to the same account, whatever the provider says about the email address later.
+func TestSSOLinkedIdentity(t *testing.T) {
+	app := newTestApplication(t)
+	ts, idp := newSSOTestServer(t, app)
+	idp.User = oidctest.User{Subject: "alice-1", Email: "alice@example.com", EmailVerified: true}
+	ts.ssoLogin(t, idp, "/user/oidc/login")
+
+	idp.User = oidctest.User{Subject: "alice-1", Email: "alice@work.example.com"}
+	browser := ts.newClient(t)
+	code, header, _ := browser.ssoLogin(t, idp, "/user/oidc/login?next=/account")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/account")
+	_, _, body := browser.get(t, "/account")
+	assert.StringContains(t, body, "alice@example.com")
+}
+
+func TestSSOCallbackState(t *testing.T) {
+	app := newTestApplication(t)
+	ts, idp := newSSOTestServer(t, app)
+
+	// a callback for a login that this browser didn't start
+	code, header, _ := ts.newClient(t).get(t, "/user/oidc/login")
+	assert.Equal(t, code, http.StatusSeeOther)
+	callback, err := idp.Authorize(header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, _, _ = ts.get(t, callback.RequestURI())
+	assert.Equal(t, code, http.StatusBadRequest)
+
+	// a forged state
+	code, header, _ = ts.get(t, "/user/oidc/login")
+	assert.Equal(t, code, http.StatusSeeOther)
+	callback, err = idp.Authorize(header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := callback.Query()
+	q.Set("state", "forged")
+	code, _, _ = ts.get(t, callback.Path+"?"+q.Encode())
+	assert.Equal(t, code, http.StatusBadRequest)
+	// and the real one can't be used afterwards, as the secrets are gone
+	code, _, _ = ts.get(t, callback.RequestURI())
+	assert.Equal(t, code, http.StatusBadRequest)
+	assert.Equal(t, len(auditEvents(t, app)), 0)
+
+	// the user cancelled at the provider
+	code, header, _ = ts.get(t, "/user/oidc/login")
+	assert.Equal(t, code, http.StatusSeeOther)
+	authURL, err := url.Parse(header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, header, _ = ts.get(t, "/user/oidc/callback?error=access_denied&state="+url.QueryEscape(authURL.Query().Get("state")))
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+	_, _, body := ts.get(t, "/user/login")
+	assert.StringContains(t, body, "Single sign-on didn&#39;t work")
+}
+
+// TestSSOInvalidToken checks that an ID token that doesn't verify doesn't log anyone in.
+func TestSSOInvalidToken(t *testing.T) {
+	app := newTestApplication(t)
+	ts, idp := newSSOTestServer(t, app)
+	idp.ModifyClaims = func(claims map[string]any) { claims["nonce"] = "replayed" }
+
+	code, header, _ := ts.ssoLogin(t, idp, "/user/oidc/login")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/login")
+	code, _, _ = ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusSeeOther)
+	events := auditEvents(t, app)
+	assert.Equal(t, len(events), 1)
+	assert.Equal(t, events[0].Action, models.AuditLoginFailed)
+}
+
+// TestSSOConfirm checks that single sign-on can confirm a logged in user's identity for sensitive actions, but only as themselves.
+func TestSSOConfirm(t *testing.T) {
+	app := newTestApplication(t)
+	ts, idp := newSSOTestServer(t, app)
+	app.config.reauthAfter = time.Second
+
+	ts.login(t)
+	_, _, body := ts.get(t, "/user/confirm?next=%2Faccount%2Fpassword")
+	assert.StringContains(t, body, "/user/oidc/login?next=%2faccount%2fpassword")
+
+	// the provider is asked to check the password again
+	code, header, _ := ts.get(t, "/user/oidc/login?next=%2Faccount%2Fpassword")
+	assert.Equal(t, code, http.StatusSeeOther)
+	authURL, err := url.Parse(header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, authURL.Query().Get("prompt"), "login")
+
+	time.Sleep(1100 * time.Millisecond)
+	idp.User = oidctest.User{Subject: "jane-1", Email: "jane@example.com", EmailVerified: true}
+	code, header, _ = ts.ssoLogin(t, idp, "/user/oidc/login?next=%2Faccount%2Fpassword")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/user/confirm?next=%2Faccount%2Fpassword")
+	code, _, _ = ts.get(t, "/account/password")
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	idp.User = oidctest.User{Subject: "alice-1", Email: "alice@example.com", EmailVerified: true}
+	code, header, _ = ts.ssoLogin(t, idp, "/user/oidc/login?next=%2Faccount%2Fpassword")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, header.Get("Location"), "/account/password")
+	code, _, _ = ts.get(t, "/account/password")
+	assert.Equal(t, code, http.StatusOK)
+
+	events := auditEvents(t, app)
+	assert.Equal(t, events[len(events)-1].Action, models.AuditReauth)
+	assert.Equal(t, events[len(events)-1].UserID, 1)
+}
+
+// TestSSODisabled checks that single sign-on is only offered when a provider is configured.
+func TestSSODisabled(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, _ := ts.get(t, "/user/oidc/login")
+	assert.Equal(t, code, http.StatusNotFound)
+	_, _, body := ts.get(t, "/user/login")
+	if strings.Contains(body, "/user/oidc/login") {
+		t.Errorf("login page links to single sign-on:\n%s", body)
+	}
+
+	app = newTestApplication(t)
+	ts2, _ := newSSOTestServer(t, app)
+	_, _, body = ts2.get(t, "/user/login")
+	assert.StringContains(t, body, "<a href='/user/oidc/login'>Log in with single sign-on</a>")
+}
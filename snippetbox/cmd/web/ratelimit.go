@@ -0,0 +1,132 @@
+package main
+
+import (
+	"github.com/justinas/alice"
+	"math"
+	"net"
+	"net/http"
+	"snippetbox.audryhsu.com/internal/models"
+	"snippetbox.audryhsu.com/internal/ratelimit"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rateLimitCleanupInterval is how often the buckets of clients that have gone quiet are removed from the rate limiters.
+const rateLimitCleanupInterval = time.Minute
+
+// rateLimiters are the request rate limiters of the routes. A nil limiter doesn't limit.
+type rateLimiters struct {
+	// auth limits logins, signups and password confirmations, where passwords are guessed and fake accounts made
+	auth *ratelimit.Limiter
+	// create limits new snippets
+	create *ratelimit.Limiter
+	// view limits snippet views, enough to slow scrapers down but not readers
+	view *ratelimit.Limiter
+	// csp limits CSP violation reports, which anyone can send and which all end up in the info log
+	csp *ratelimit.Limiter
+}
+
+// newRateLimiters returns the limiters for the -rate-limit-* settings, and starts removing idle buckets from them in the background.
+func newRateLimiters(cfg config) rateLimiters {
+	newLimiter := func(s string) *ratelimit.Limiter {
+		if s == "" {
+			return nil
+		}
+		// validate has checked the limit
+		limit, _ := ratelimit.Parse(s)
+		l := ratelimit.New(limit)
+		// the limiters last as long as the server, so the cleanup is never stopped
+		l.StartCleanup(rateLimitCleanupInterval)
+		return l
+	}
+	return rateLimiters{
+		auth:   newLimiter(cfg.rateLimitAuth),
+		create: newLimiter(cfg.rateLimitCreate),
+		view:   newLimiter(cfg.rateLimitView),
+		csp:    newLimiter(cfg.rateLimitCSP),
+	}
+}
+
+// rateLimit returns middleware that refuses requests over limiter's limit with 429 Too Many Requests, and a Retry-After header saying when to try again.
+// Logged in users are limited per user, so people sharing an address, e.g. in an office, don't share a limit once they log in. Everyone else is limited per IP address.
+// It goes after authenticate in a chain.
+func (app *application) rateLimit(limiter *ratelimit.Limiter) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		if limiter == nil {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := rateLimitKey(r, app.authenticatedUser(r))
+			if ok, retryAfter := limiter.Allow(key); !ok {
+				app.infoLog.Printf("rate limited %s on %s %s (request %s)", key, r.Method, r.URL.Path, requestIDFrom(r))
+				// whole seconds, rounded up so the client doesn't come back too early
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				app.clientError(w, r, http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimitKey returns the key of the request's client in the rate limiters: the user, if one is logged in, or else the IP address.
+// IPv6 clients are limited per /64 network, as a client usually has a whole /64 to pick addresses from.
+func rateLimitKey(r *http.Request, user *models.User) string {
+	if user != nil {
+		return "user:" + strconv.Itoa(user.ID)
+	}
+	ip := net.ParseIP(clientIP(r))
+	if ip == nil {
+		return "ip:" + clientIP(r)
+	}
+	if ip.To4() == nil {
+		ip = ip.Mask(net.CIDRMask(64, 128))
+	}
+	return "ip:" + ip.String()
+}
+
+// realIP sets the request's RemoteAddr to the client's address from X-Forwarded-For, when the request came through one of -trusted-proxies. Otherwise the header is ignored, as anyone can send one; clientIP, and with it the audit log, the session list and the rate limits, would take the made-up address for the client's.
+// Each proxy appends the address it got the request from to the header, so it is read from the right, and the first address that isn't a trusted proxy is the client's.
+func (app *application) realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.isTrustedProxy(clientIP(r)) {
+			if ip := app.forwardedFor(r); ip != "" {
+				r = r.Clone(r.Context())
+				r.RemoteAddr = ip
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedFor returns the client's address from the request's X-Forwarded-For headers, or "" if there is none or it isn't an IP address. If every address is a trusted proxy, the client is the first.
+func (app *application) forwardedFor(r *http.Request) string {
+	addrs := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	var ip string
+	for i := len(addrs) - 1; i >= 0; i-- {
+		addr := net.ParseIP(strings.TrimSpace(addrs[i]))
+		if addr == nil {
+			return ""
+		}
+		ip = addr.String()
+		if !app.isTrustedProxy(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// isTrustedProxy reports whether the IP address ip is one of -trusted-proxies.
+func (app *application) isTrustedProxy(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, proxy := range app.trustedProxies {
+		if proxy.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"snippetbox.audryhsu.com/internal/assert"
+	"snippetbox.audryhsu.com/internal/models"
+	"snippetbox.audryhsu.com/internal/oidc/oidctest"
+	"snippetbox.audryhsu.com/internal/ratelimit"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	app := newTestApplication(t)
+	app.rateLimiters.auth = ratelimit.New(ratelimit.Per(2, time.Minute))
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// visitors are limited by IP address, so a second browser on the same machine shares the limit
+	_, _, body := ts.get(t, "/user/login")
+	form := url.Values{"email": {"alice@example.com"}, "password": {"wrong"}, "csrf_token": {extractCSRFToken(t, body)}}
+	for i := 0; i < 2; i++ {
+		code, _, _ := ts.postForm(t, "/user/login", form)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+	}
+	code, header, body := ts.postForm(t, "/user/login", form)
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, header.Get("Retry-After"), "30")
+	assert.StringContains(t, body, "You&#39;re doing that too often")
+	// the password wasn't checked
+	assert.Equal(t, len(auditEvents(t, app)), 2)
+
+	other := ts.newClient(t)
+	_, _, body = other.get(t, "/user/login")
+	code, _, _ = other.postForm(t, "/user/signup", url.Values{"csrf_token": {extractCSRFToken(t, body)}})
+	assert.Equal(t, code, http.StatusTooManyRequests)
+
+	// routes without a limit aren't affected
+	code, _, _ = ts.get(t, "/snippet/view/1")
+	assert.Equal(t, code, http.StatusOK)
+}
+
+// TestRateLimitSSO checks that the single sign-on callback, which logs users in, shares the auth limit with the other logins.
+func TestRateLimitSSO(t *testing.T) {
+	app := newTestApplication(t)
+	app.rateLimiters.auth = ratelimit.New(ratelimit.Per(2, time.Minute))
+	ts, idp := newSSOTestServer(t, app)
+	idp.User = oidctest.User{Subject: "alice-1", Email: "alice@example.com", EmailVerified: true}
+
+	// the login and its callback take one request each
+	code, _, _ := ts.ssoLogin(t, idp, "/user/oidc/login")
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	// refused before the state is even checked, so the provider isn't asked for a token
+	code, header, _ := ts.newClient(t).get(t, "/user/oidc/callback?code=guess&state=guess")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, header.Get("Retry-After"), "30")
+}
+
+// TestRateLimitUser checks that logged in users are limited by user, wherever they log in from.
+func TestRateLimitUser(t *testing.T) {
+	app := newTestApplication(t)
+	app.rateLimiters.create = ratelimit.New(ratelimit.Per(1, time.Hour))
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	for i, wantCode := range []int{http.StatusSeeOther, http.StatusTooManyRequests} {
+		browser := ts.newClient(t)
+		browser.login(t)
+		_, _, body := browser.get(t, "/snippet/create")
+		form := url.Values{"title": {"Haiku"}, "content": {"An old silent pond"}, "expires": {"7"}, "csrf_token": {extractCSRFToken(t, body)}}
+		code, header, _ := browser.postForm(t, "/snippet/create", form)
+		assert.Equal(t, code, wantCode)
+		if i == 1 {
+			assert.Equal(t, header.Get("Retry-After"), "3600")
+		}
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		user       *models.User
+		want       string
+	}{
+		{name: "IPv4", remoteAddr: "192.0.2.1:1234", want: "ip:192.0.2.1"},
+		{name: "IPv6", remoteAddr: "[2001:db8:1:2:3:4:5:6]:1234", want: "ip:2001:db8:1:2::"},
+		{name: "User", remoteAddr: "192.0.2.1:1234", user: &models.User{ID: 7}, want: "user:7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			assert.Equal(t, rateLimitKey(r, tt.user), tt.want)
+		})
+	}
+}
+
+func TestRealIP(t *testing.T) {
+	proxies, err := parseTrustedProxies("10.0.0.0/8, 192.0.2.10, 2001:db8::1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		trustedProxies bool
+		remoteAddr     string
+		forwardedFor   []string
+		want           string
+	}{
+		{name: "No proxies trusted", remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"203.0.113.7"}, want: "10.0.0.1"},
+		{name: "Untrusted proxy", trustedProxies: true, remoteAddr: "198.51.100.1:1234", forwardedFor: []string{"203.0.113.7"}, want: "198.51.100.1"},
+		{name: "Trusted proxy", trustedProxies: true, remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"203.0.113.7"}, want: "203.0.113.7"},
+		{name: "Trusted proxy address", trustedProxies: true, remoteAddr: "192.0.2.10:1234", forwardedFor: []string{"203.0.113.7"}, want: "203.0.113.7"},
+		{name: "IPv6 proxy", trustedProxies: true, remoteAddr: "[2001:db8::1]:1234", forwardedFor: []string{"2001:db8::7"}, want: "2001:db8::7"},
+		{name: "Made-up address before the proxies", trustedProxies: true, remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"192.0.2.99, 203.0.113.7, 10.0.0.2"}, want: "203.0.113.7"},
+		{name: "Several headers", trustedProxies: true, remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"192.0.2.99", "203.0.113.7"}, want: "203.0.113.7"},
+		{name: "Only proxies", trustedProxies: true, remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"10.0.0.3, 10.0.0.2"}, want: "10.0.0.3"},
+		{name: "Not an address", trustedProxies: true, remoteAddr: "10.0.0.1:1234", forwardedFor: []string{"unknown"}, want: "10.0.0.1"},
+		{name: "No header", trustedProxies: true, remoteAddr: "10.0.0.1:1234", want: "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			if tt.trustedProxies {
+				app.trustedProxies = proxies
+			}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwardedFor {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+
+			var ip string
+			app.realIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ip = clientIP(r)
+			})).ServeHTTP(httptest.NewRecorder(), r)
+			assert.Equal(t, ip, tt.want)
+		})
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/alice"
 	"net/http"
-	"snippetbox.audryhsu.com/ui"
+	"snippetbox.audryhsu.com/internal/models"
 )
 
 // Update signature of routes() method, so it returns a http.Handler instead of *http.ServeMux
@@ -12,44 +12,77 @@ import (
 func (app *application) routes() http.Handler {
 	router := httprouter.New()
 
-	// Create a handler func which wraps our notFound() helper, then assign it as custom handler for 404 Not Found response. Ensures all 404 responses are standardized between notFound() calls and 404's from httprouter when no url pattern is matched.
-	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		app.notFound(w)
-	})
-	router.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		app.methodNotAllowed(w)
-	})
-	// convert ui.Files embedded filesystem and convert it to a http.FS type to satisfy the http.FileSystem interface and create  file server handler.
-	fileServer := http.FileServer(http.FS(ui.Files))
-
-	// Static files are now contained in "static" folder of ui.Files embedded filesystem, so we no longer need to strip the prefix from the request URL. Any requests that start with /static/ can be passed directly to file server. ("static/css/main.css")
-	router.Handler(http.MethodGet, "/static/*filepath", fileServer)
-
 	// add /ping route
 	router.HandlerFunc(http.MethodGet, "/ping", ping)
 
-	// Non-auth routes use "dynamic" middleware chain plus CSRF check middleware
-	dynamic := alice.New(app.sessionManager.LoadAndSave, app.noSurf, app.authenticate)
+	// browsers POST CSP violation reports here; they carry no session or CSRF token, so the route skips the "dynamic" chain, and is rate limited per IP address as anyone can post to it
+	router.Handler(http.MethodPost, cspReportPath, app.rateLimit(app.rateLimiters.csp)(http.HandlerFunc(app.cspReportCollector)))
+
+	// Non-auth routes use "dynamic" middleware chain plus CSRF check middleware. Bodies are capped before noSurf reads the form, and negotiateLocale needs the user that authenticate loads.
+	dynamic := alice.New(app.limitRequestBody, app.sessionManager.LoadAndSave, app.noSurf, app.authenticate, app.negotiateLocale)
+
+	// Create a handler func which wraps our notFound() helper, then assign it as custom handler for 404 Not Found response. Ensures all 404 responses are standardized between notFound() calls and 404's from httprouter when no url pattern is matched.
+	// The handlers go through the session and auth middleware so error pages show the flash message and the right nav links.
+	errorPages := alice.New(app.sessionManager.LoadAndSave, app.noSurfExempt, app.authenticate, app.negotiateLocale)
+	router.NotFound = errorPages.ThenFunc(app.notFound)
+	router.MethodNotAllowed = errorPages.ThenFunc(app.methodNotAllowed)
+
+	// Static files are contained in "static" folder of ui.Files embedded filesystem (or the ui folder on disk in -dev mode). The asset manifest serves them: fingerprinted URLs ("static/css/main.3f2a9c1b0d4e.css") are cached forever, compressed variants are sent when the client accepts them, and directories aren't listed but get the same 404 page as any other missing page.
+	router.Handler(http.MethodGet, "/static/*filepath", app.assets.withNotFound(router.NotFound))
 
 	// httprouter package provides method-based routing, clean URLs, and more robust pattern-matching.
 	// alice ThenFunc() returns http.Handler (instead http.HandlerFunc), so switch to registering the route using router.Handler()
 	router.Handler(http.MethodGet, "/", dynamic.ThenFunc(app.home))
 	router.Handler(http.MethodGet, "/about", dynamic.ThenFunc(app.about))
-	router.Handler(http.MethodGet, "/snippet/view/:id", dynamic.ThenFunc(app.snippetView))
+	// Some routes are rate limited per user, or per IP address for visitors: strictly where passwords are tried and accounts made, less so for new snippets, and only enough to slow scrapers down for viewing them.
+	authLimit := app.rateLimit(app.rateLimiters.auth)
+	router.Handler(http.MethodGet, "/snippet/view/:id", dynamic.Append(app.rateLimit(app.rateLimiters.view)).ThenFunc(app.snippetView))
 
-	router.Handler(http.MethodPost, "/user/signup", dynamic.ThenFunc(app.userSignupPost))
+	router.Handler(http.MethodPost, "/user/signup", dynamic.Append(authLimit).ThenFunc(app.userSignupPost))
 	router.Handler(http.MethodGet, "/user/signup", dynamic.ThenFunc(app.userSignup))
 	router.Handler(http.MethodGet, "/user/login", dynamic.ThenFunc(app.userLogin))
-	router.Handler(http.MethodPost, "/user/login", dynamic.ThenFunc(app.userLoginPost))
+	router.Handler(http.MethodPost, "/user/login", dynamic.Append(authLimit).ThenFunc(app.userLoginPost))
+	router.Handler(http.MethodPost, "/locale", dynamic.ThenFunc(app.setLocale))
+	// single sign-on, when there is a provider to log in with; logged in users come through here to confirm their identity for sensitive actions
+	if app.oidc != nil {
+		router.Handler(http.MethodGet, "/user/oidc/login", dynamic.Append(authLimit).ThenFunc(app.oidcLogin))
+		router.Handler(http.MethodGet, "/user/oidc/callback", dynamic.Append(authLimit).ThenFunc(app.oidcCallback))
+	}
 
 	// Authenticated routes use a "protected" middleware chain that includes requireAuthentication middleware.
 	protected := dynamic.Append(app.requireAuthentication)
 	router.Handler(http.MethodGet, "/snippet/create", protected.ThenFunc(app.snippetCreateForm))
-	router.Handler(http.MethodPost, "/snippet/create", protected.ThenFunc(app.snippetCreatePost))
+	router.Handler(http.MethodPost, "/snippet/create", protected.Append(app.rateLimit(app.rateLimiters.create)).ThenFunc(app.snippetCreatePost))
 	router.Handler(http.MethodPost, "/user/logout", protected.ThenFunc(app.userLogoutPost))
+	router.Handler(http.MethodPost, "/snippet/report/:id", protected.ThenFunc(app.snippetReport))
+	router.Handler(http.MethodGet, "/account", protected.ThenFunc(app.account))
+	router.Handler(http.MethodPost, "/account/sessions/revoke", protected.ThenFunc(app.accountSessionRevoke))
+	router.Handler(http.MethodPost, "/account/sessions/revoke-others", protected.ThenFunc(app.accountSessionRevokeOthers))
+	router.Handler(http.MethodGet, "/user/confirm", protected.ThenFunc(app.userConfirm))
+	router.Handler(http.MethodPost, "/user/confirm", protected.Append(authLimit).ThenFunc(app.userConfirmPost))
+
+	// Sensitive actions use a "sensitive" chain that asks for the password again if the user last entered it a while ago.
+	sensitive := protected.Append(app.requireRecentAuth)
+	router.Handler(http.MethodGet, "/account/password", sensitive.ThenFunc(app.accountPassword))
+	router.Handler(http.MethodPost, "/account/password", sensitive.ThenFunc(app.accountPasswordPost))
+
+	// The admin area. Moderators see the site statistics, work through the moderation queue and can delete any snippet; only admins manage users. Roles are checked against the database on every request (see authenticate).
+	moderator := protected.Append(app.requireRole(models.RoleModerator))
+	admin := protected.Append(app.requireRole(models.RoleAdmin))
+	router.Handler(http.MethodGet, "/admin", moderator.ThenFunc(app.adminDashboard))
+	router.Handler(http.MethodGet, "/admin/moderation", moderator.ThenFunc(app.adminModeration))
+	router.Handler(http.MethodPost, "/admin/snippets/:id/moderate", moderator.ThenFunc(app.adminSnippetModerate))
+	router.Handler(http.MethodPost, "/admin/snippets/:id/delete", moderator.ThenFunc(app.adminSnippetDelete))
+	router.Handler(http.MethodGet, "/admin/users", admin.ThenFunc(app.adminUsers))
+	router.Handler(http.MethodPost, "/admin/users/:id", admin.ThenFunc(app.adminUserUpdate))
+	router.Handler(http.MethodGet, "/admin/audit", admin.ThenFunc(app.adminAudit))
+	router.Handler(http.MethodGet, "/admin/audit/export", admin.ThenFunc(app.adminAuditExport))
 
 	// Create middleware chain containing 'standard' middleware, which is used for every request our app receives
-	standard := alice.New(app.recoverPanic, app.logRequest, secureHeaders)
+	// requestID comes first so that every log line and audit event of the request can carry the id
+	// realIP comes before logRequest so that requests through a trusted proxy are logged with the client's address
+	// compressResponse comes last so the headers set by the others are in place when it decides whether to compress
+	standard := alice.New(app.requestID, app.recoverPanic, app.realIP, app.logRequest, app.secureHeaders, app.compressResponse)
 
 	// Return 'standard' middleware chain, followed by router
 	return standard.Then(router)
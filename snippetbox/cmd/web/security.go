@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// cspReportPath is where browsers POST Content-Security-Policy violation reports.
+const cspReportPath = "/csp-report"
+
+// securityPolicy holds the security headers sent with every response. It is built once from config by newSecurityPolicy.
+type securityPolicy struct {
+	// csp is the configured Content-Security-Policy; a per-request nonce and the report-uri are added to it by cspHeader()
+	csp string
+	// reportOnly sends the CSP as Content-Security-Policy-Report-Only, so violations are reported but nothing is blocked
+	reportOnly        bool
+	referrerPolicy    string
+	permissionsPolicy string
+	// coop and coep are the Cross-Origin-Opener-Policy and Cross-Origin-Embedder-Policy values; empty means the header isn't sent
+	coop string
+	coep string
+	// hsts is the complete Strict-Transport-Security value; empty means the header isn't sent
+	hsts string
+}
+
+// newSecurityPolicy builds the security headers policy from config.
+func newSecurityPolicy(cfg config) securityPolicy {
+	p := securityPolicy{
+		csp:               cfg.csp,
+		reportOnly:        cfg.cspReportOnly,
+		referrerPolicy:    cfg.referrerPolicy,
+		permissionsPolicy: cfg.permissionsPolicy,
+		coop:              cfg.coop,
+		coep:              cfg.coep,
+	}
+	if cfg.hstsMaxAge > 0 {
+		p.hsts = "max-age=" + strconv.Itoa(int(cfg.hstsMaxAge.Seconds()))
+		if cfg.hstsIncludeSubdomains {
+			p.hsts += "; includeSubDomains"
+		}
+	}
+	return p
+}
+
+// cspHeader returns the name and value of the CSP header for a request with the given nonce.
+// The nonce is added to script-src, so templates can mark vetted inline scripts with nonce="{{.CSPNonce}}". If the policy has no script-src, one is added that copies default-src.
+func (p securityPolicy) cspHeader(nonce string) (string, string) {
+	var directives []string
+	var scriptSrc, defaultSrc string
+	for _, d := range strings.Split(p.csp, ";") {
+		d = strings.TrimSpace(d)
+		switch {
+		case d == "":
+			continue
+		case strings.HasPrefix(d, "script-src "):
+			scriptSrc = d
+			continue
+		case strings.HasPrefix(d, "default-src "):
+			defaultSrc = strings.TrimPrefix(d, "default-src ")
+		}
+		directives = append(directives, d)
+	}
+	if scriptSrc == "" {
+		scriptSrc = strings.TrimSpace("script-src " + defaultSrc)
+	}
+	directives = append(directives, scriptSrc+" 'nonce-"+nonce+"'", "report-uri "+cspReportPath)
+
+	name := "Content-Security-Policy"
+	if p.reportOnly {
+		name = "Content-Security-Policy-Report-Only"
+	}
+	return name, strings.Join(directives, "; ")
+}
+
+// newNonce returns a random 128-bit CSP nonce. URL-safe base64 is used so html/template never has to escape it.
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// cspNonce returns the nonce secureHeaders generated for this request, or "" if there is none.
+func cspNonce(r *http.Request) string {
+	nonce, _ := r.Context().Value(cspNonceContextKey).(string)
+	return nonce
+}
+
+// withCSPNonce returns a copy of the request carrying the nonce in its context.
+func withCSPNonce(r *http.Request, nonce string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), cspNonceContextKey, nonce))
+}
+
+// cspReport is the body browsers send to report-uri. Only the fields worth logging are decoded.
+type cspReport struct {
+	Report struct {
+		DocumentURI        string `json:"document-uri"`
+		ViolatedDirective  string `json:"violated-directive"`
+		EffectiveDirective string `json:"effective-directive"`
+		BlockedURI         string `json:"blocked-uri"`
+		SourceFile         string `json:"source-file"`
+		LineNumber         int    `json:"line-number"`
+		Disposition        string `json:"disposition"`
+	} `json:"csp-report"`
+}
+
+// cspReportCollector logs the CSP violations browsers report to cspReportPath and answers 204 No Content.
+func (app *application) cspReportCollector(w http.ResponseWriter, r *http.Request) {
+	// reports are small; don't let anyone stream an unbounded body at us
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 64<<10))
+	if err != nil {
+		app.clientError(w, r, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var report cspReport
+	if err := json.Unmarshal(body, &report); err != nil || report.Report.ViolatedDirective == "" && report.Report.EffectiveDirective == "" {
+		app.clientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	rep := report.Report
+	directive := rep.EffectiveDirective
+	if directive == "" {
+		directive = rep.ViolatedDirective
+	}
+	// every field comes from the client, so they are all quoted, lest a newline in one forge a log line
+	app.infoLog.Printf("CSP violation (%q): %q blocked %q on %q (%q:%d)",
+		rep.Disposition, directive, rep.BlockedURI, rep.DocumentURI, rep.SourceFile, rep.LineNumber)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,23 +1,77 @@
 package main
 
 import (
+	"fmt"
 	"html/template"
 	"io/fs"
 	"path/filepath"
+	"snippetbox.audryhsu.com/internal/i18n"
 	"snippetbox.audryhsu.com/internal/models"
-	"snippetbox.audryhsu.com/ui"
+	"snippetbox.audryhsu.com/internal/validator"
+	"sync"
 	"time"
 )
 
 // templateData is a holding structure for any dynamic data we want to pass to HTML templates.
 type templateData struct {
-	CurrentYear     int
-	Snippet         *models.Snippet
-	Snippets        []*models.Snippet
-	Form            any
+	CurrentYear int
+	Snippet     *models.Snippet
+	Snippets    []*models.Snippet
+	Form        any
+	// Flash is the message key of a one-off message, e.g. "flash.logged_out"; templates translate it with {{t .Flash}}
 	Flash           string
 	IsAuthenticated bool
 	CSRFToken       string
+	Error           *errorData
+	// SSOEnabled shows the single sign-on links, when -oidc-issuer is set
+	SSOEnabled bool
+	// User is the logged in user (nil if nobody is). IsModerator and IsAdmin tell templates whether to show the moderation and admin controls.
+	User        *models.User
+	IsModerator bool
+	IsAdmin     bool
+	// Users, Search and Stats are shown in the admin area. MoreUsers is set when the user list was cut short.
+	Users     []*models.User
+	MoreUsers bool
+	Search    string
+	Stats     *siteStats
+	// Queue is the moderation queue and Decisions the latest entries of the moderation audit trail.
+	Queue     []*models.QueueEntry
+	Decisions []*models.Decision
+	// AuditEvents is a page of the audit log. OlderEvents links to the next page, if there is one, and AuditExports to the downloads of the filtered log, by format.
+	AuditEvents  []*models.AuditEvent
+	AuditActions []models.AuditAction
+	OlderEvents  string
+	AuditExports map[string]string
+	// Sessions are the logged in user's sessions, listed on their account page. CurrentSession is the id of the one the page was requested with.
+	Sessions       []*models.Session
+	CurrentSession int
+	// CSPNonce marks vetted inline scripts as allowed by the Content-Security-Policy: <script nonce="{{.CSPNonce}}">
+	CSPNonce string
+	// Locale is the language the page is rendered in, and Locales the languages the user can switch to
+	Locale  string
+	Locales []localeOption
+}
+
+// siteStats are the statistics on the admin dashboard. Reported is the number of snippets in the moderation queue. Cache is nil when the snippet cache is turned off.
+type siteStats struct {
+	Users    *models.UserCounts
+	Snippets *models.SnippetCounts
+	Reported int
+	Cache    *models.CacheStats
+}
+
+// localeOption is one entry of the language switcher.
+type localeOption struct {
+	Code string
+	Name string
+}
+
+// errorData describes an error response. It is rendered by error.html, or encoded as the body of JSON error responses.
+type errorData struct {
+	Status  int    `json:"status"`
+	Message string `json:"error"`
+	// Trace is the error and stack trace, only set in debug mode
+	Trace string `json:"trace,omitempty"`
 }
 
 // humanDate returns a nicely formatted string of time.Time object
@@ -30,17 +84,54 @@ func humanDate(t time.Time) string {
 }
 
 // Initialize template.FuncMap object and store it in a global variable. This is a lookup between names of custom template funcs and funcs themselves.
+// "t" and "humanDate" are replaced with translating versions for each locale by localeFunctions.
 var functions = template.FuncMap{
 	"humanDate": humanDate,
+	"t":         translate(i18n.Translator{}),
+}
+
+// localeFunctions returns the template functions that depend on the page's language.
+func localeFunctions(tr i18n.Translator) template.FuncMap {
+	return template.FuncMap{
+		"t": translate(tr),
+		"humanDate": func(t time.Time) string {
+			if t.IsZero() {
+				return ""
+			}
+			return t.UTC().Format(tr.T("format.human_date", nil))
+		},
+	}
 }
 
-// NewTemplateCache creates a cache of parsed templates ready for use by handler functions to render dynamic data. Each page (key) has a corresponding set of templates (value).
-func NewTemplateCache() (map[string]*template.Template, error) {
-	// initialize new map
-	cache := map[string]*template.Template{}
+// translate returns the "t" template function. It takes a message key and placeholder names and values in pairs, {{t "view.created" "date" $date}}, or a validation error: {{t .Form.FieldErrors.title}}.
+func translate(tr i18n.Translator) func(key any, params ...any) string {
+	return func(key any, params ...any) string {
+		switch key := key.(type) {
+		case string:
+			return tr.T(key, validator.Msg(key, params...).Params)
+		case validator.Message:
+			return tr.T(key.Key, key.Params)
+		case *validator.Message:
+			return tr.T(key.Key, key.Params)
+		}
+		return fmt.Sprint(key)
+	}
+}
 
-	// Use fs.Glob to get slice of all filepaths in ui.Files embedded fs that match the pattern "./ui/html/pages/*.html" (e.g. all of the "page" templates)
-	pages, err := fs.Glob(ui.Files, "html/pages/*.html")
+// templateCache holds the parsed template set of every page in every locale: cache[locale][page].
+type templateCache map[string]map[string]*template.Template
+
+// NewTemplateCache creates a cache of parsed templates ready for use by handler functions to render dynamic data. Each page in each locale has a corresponding set of templates.
+// fsys is the ui folder: the ui.Files embedded fs in production, or the folder on disk in -dev mode. assets provides the "asset" function that templates use to link to static files, and translations the "t" function.
+func NewTemplateCache(fsys fs.FS, assets *assetManifest, translations *i18n.Bundle) (templateCache, error) {
+	// initialize new map with an entry per locale
+	cache := templateCache{}
+	for _, locale := range translations.Locales() {
+		cache[locale] = map[string]*template.Template{}
+	}
+
+	// Use fs.Glob to get slice of all filepaths in fsys that match the pattern "./ui/html/pages/*.html" (e.g. all of the "page" templates)
+	pages, err := fs.Glob(fsys, "html/pages/*.html")
 	if err != nil {
 		return nil, err
 	}
@@ -54,14 +145,69 @@ func NewTemplateCache() (map[string]*template.Template, error) {
 			"html/partials/*.html",
 			page,
 		}
-		// use ParseFS() instead of ParseFiles() to parse the template files from ui.Files embedded fs
-		ts, err := template.New(name).Funcs(functions).ParseFS(ui.Files, patterns...)
+		// use ParseFS() instead of ParseFiles() to parse the template files from ui.Files embedded fs (or the ui folder on disk)
+		ts, err := template.New(name).Funcs(functions).Funcs(template.FuncMap{"asset": assets.URL}).ParseFS(fsys, patterns...)
 		if err != nil {
 			return nil, err
 		}
 
-		// add template set to map, with page as key
-		cache[name] = ts
+		// each locale gets its own copy of the template set, bound to functions that translate into that locale. The pages are only parsed once.
+		for locale := range cache {
+			clone, err := ts.Clone()
+			if err != nil {
+				return nil, err
+			}
+			cache[locale][name] = clone.Funcs(localeFunctions(translations.Translator(locale)))
+		}
 	}
 	return cache, nil
 }
+
+// templateReloader re-parses the templates in fsys whenever one of them changes. It is used in -dev mode, where fsys is the ui folder on disk, so template edits show up without a rebuild.
+type templateReloader struct {
+	fsys         fs.FS
+	assets       *assetManifest
+	translations *i18n.Bundle
+
+	mu     sync.Mutex
+	cache  templateCache
+	parsed time.Time // newest modification time seen when cache was parsed
+}
+
+// get returns the template cache, re-parsing it first if any file or folder under html/ has changed since the last parse. Parse errors are returned on every call until the template is fixed.
+func (tr *templateReloader) get() (templateCache, error) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	latest, err := latestModTime(tr.fsys, "html")
+	if err != nil {
+		return nil, err
+	}
+	if tr.cache == nil || latest.After(tr.parsed) {
+		cache, err := NewTemplateCache(tr.fsys, tr.assets, tr.translations)
+		if err != nil {
+			return nil, err
+		}
+		tr.cache, tr.parsed = cache, latest
+	}
+	return tr.cache, nil
+}
+
+// latestModTime returns the newest modification time of root and everything below it. Folders are included, so added and deleted files count as changes too.
+func latestModTime(fsys fs.FS, root string) (time.Time, error) {
+	var latest time.Time
+	err := fs.WalkDir(fsys, root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		return nil
+	})
+	return latest, err
+}
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
 	"snippetbox.audryhsu.com/internal/assert"
+	"snippetbox.audryhsu.com/internal/i18n"
+	"snippetbox.audryhsu.com/ui"
 	"testing"
 	"time"
 )
@@ -43,3 +49,70 @@ func TestHumanDate(t *testing.T) {
 		})
 	}
 }
+
+// TestTemplateReloader checks that -dev mode picks up template edits, and reports parse errors until they're fixed.
+func TestTemplateReloader(t *testing.T) {
+	// copy the embedded templates to disk, as if running from a checkout
+	dir := t.TempDir()
+	err := fs.WalkDir(ui.Files, "html", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(dir, path), 0o755)
+		}
+		b, err := fs.ReadFile(ui.Files, path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(dir, path), b, 0o644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	translations, err := i18n.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := &templateReloader{fsys: os.DirFS(dir), assets: &assetManifest{dev: true}, translations: translations}
+	aboutPage := filepath.Join(dir, "html", "pages", "about.html")
+	later := time.Now().Add(time.Minute)
+
+	// edit writes a new about page with a modification time in the future, so the change is seen even on filesystems with coarse timestamps
+	edit := func(contents string) {
+		t.Helper()
+		if err := os.WriteFile(aboutPage, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		later = later.Add(time.Minute)
+		if err := os.Chtimes(aboutPage, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	renderAbout := func() string {
+		t.Helper()
+		cache, err := tr.get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := new(bytes.Buffer)
+		if err := cache[i18n.DefaultLocale]["about.html"].ExecuteTemplate(buf, "main", &templateData{}); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	assert.StringContains(t, renderAbout(), "<h2>About</h2>")
+
+	edit(`{{define "title"}}About{{end}}{{define "main"}}<h2>Edited</h2>{{end}}`)
+	assert.StringContains(t, renderAbout(), "<h2>Edited</h2>")
+
+	edit(`{{define "main"}}{{if}}{{end}}`)
+	if _, err := tr.get(); err == nil {
+		t.Error("expected a parse error")
+	}
+
+	edit(`{{define "title"}}About{{end}}{{define "main"}}<h2>Fixed</h2>{{end}}`)
+	assert.StringContains(t, renderAbout(), "<h2>Fixed</h2>")
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha1"
+	"fmt"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
 	"html"
@@ -12,15 +14,27 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"regexp"
+	"snippetbox.audryhsu.com/internal/i18n"
 	"snippetbox.audryhsu.com/internal/models/mocks"
+	"snippetbox.audryhsu.com/internal/password"
+	"snippetbox.audryhsu.com/internal/secrets"
+	"snippetbox.audryhsu.com/ui"
+	"strings"
 	"testing"
-	"time"
 )
 
 // newTestApplication instantiates a new application struct with mocked errorLog and infoLog methods
 func newTestApplication(t *testing.T) *application {
-	// Create an instance of the template cache.
-	templateCache, err := NewTemplateCache()
+	// Create the static assets manifest and an instance of the template cache.
+	assets, err := newAssetManifest(ui.Files, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	translations, err := i18n.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	templateCache, err := NewTemplateCache(ui.Files, assets, translations)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -28,22 +42,40 @@ func newTestApplication(t *testing.T) *application {
 	formDecoder := form.NewDecoder()
 	// And a session manager instance. Note that we use the same settings as // production, except that we *don't* set a Store for the session manager. // If no store is set, the SCS package will default to using a transient // in-memory store, which is ideal for testing purposes.
 	sessionManager := scs.New()
-	sessionManager.Lifetime = 12 * time.Hour
+	sessionManager.Lifetime = defaultConfig().rememberLifetime
+	sessionManager.Cookie.Persist = false
 	sessionManager.Cookie.Secure = true
 	return &application{
 		errorLog:       log.New(io.Discard, "", 0),
 		infoLog:        log.New(io.Discard, "", 0),
 		snippets:       &mocks.SnippetModel{}, // use mock
 		users:          &mocks.UserModel{},    // use mock
+		moderation:     &mocks.ModerationModel{},
+		auditLog:       &mocks.AuditModel{},
+		sessions:       &mocks.SessionModel{},
+		identities:     &mocks.IdentityModel{},
+		secretScanner:  secrets.New(),
 		templateCache:  templateCache,
+		translations:   translations,
+		assets:         assets,
+		pageVersion:    "test",
 		sessionManager: sessionManager,
 		formDecoder:    formDecoder,
+		config:         defaultConfig(),
+		securityPolicy: newSecurityPolicy(defaultConfig()),
 	}
 }
 
 // define a custom testServer type which embeds a httptest.Server instance
 type testServer struct {
 	*httptest.Server
+	// client is the browser the test drives the server with, see Client
+	client *http.Client
+}
+
+// Client returns the test server's client, which keeps cookies and doesn't follow redirects. It replaces httptest.Server's Client, so that a test can have more than one (see newClient).
+func (ts *testServer) Client() *http.Client {
+	return ts.client
 }
 
 // newTestServer helper initializes and returns a new instance of custom test server type
@@ -52,19 +84,32 @@ func newTestServer(t *testing.T, h http.Handler) *testServer {
 	// starts a https server and listens on randomly-chosen port for the duration of the test.
 	ts := httptest.NewTLSServer(h)
 
+	return &testServer{Server: ts, client: newTestClient(t, ts)}
+}
+
+// newClient returns the same test server driven by a second client, with cookies of its own: a second browser, to be logged in separately.
+func (ts *testServer) newClient(t *testing.T) *testServer {
+	return &testServer{Server: ts.Server, client: newTestClient(t, ts.Server)}
+}
+
+// newTestClient returns a client for ts, with an empty cookie jar.
+func newTestClient(t *testing.T, ts *httptest.Server) *http.Client {
+	// copy the client httptest configured to trust the server's certificate
+	client := *ts.Client()
+
 	// initialize a cookie jar
 	jar, err := cookiejar.New(nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 	// add cookie jar to test server client. any response cookies will be stored and sent with subsequent requests when using this client.
-	ts.Client().Jar = jar
+	client.Jar = jar
 
 	// disable redirect following by setting custom CheckRedirect function. This is called whenever a 3XXX response is received by client. Returning http.ErrUseLastResponse forces client to immediately return the received response
-	ts.Client().CheckRedirect = func(req *http.Request, via []*http.Request) error {
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
 		return http.ErrUseLastResponse
 	}
-	return &testServer{ts}
+	return &client
 }
 
 // get method on custom testServer type makes GET requests to a given URL path using the test server client and returns response status code, headers, and body
@@ -84,6 +129,39 @@ func (ts *testServer) get(t *testing.T, urlPath string) (statusCode int, headers
 	return res.StatusCode, res.Header, string(bytebody)
 }
 
+// getWithHeader makes a GET request like get, with extra request headers (e.g. If-None-Match).
+func (ts *testServer) getWithHeader(t *testing.T, urlPath string, header http.Header) (int, http.Header, string) {
+	req, err := http.NewRequest(http.MethodGet, ts.URL+urlPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, res.Header, string(body)
+}
+
+// login logs the test server's client in as the mock user, so later requests carry an authenticated session cookie.
+func (ts *testServer) login(t *testing.T) {
+	_, _, body := ts.get(t, "/user/login")
+	form := url.Values{}
+	form.Add("email", "alice@example.com")
+	form.Add("password", "pa$$word")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	if code, _, _ := ts.postForm(t, "/user/login", form); code != http.StatusSeeOther {
+		t.Fatalf("login failed with status %d", code)
+	}
+}
+
 // postForm method sends POST requests to test server. url.Values object can contain any form data that you want to send in the request body.
 func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (int, http.Header, string) {
 	rs, err := ts.Client().PostForm(ts.URL+urlPath, form)
@@ -101,8 +179,8 @@ func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (i
 	return rs.StatusCode, rs.Header, string(body)
 }
 
-// regular expression which captures the CSRF token value from the HTML for user sign up
-var csrfTokenRX = regexp.MustCompile(`<input type='hidden' name='csrf_token' value='(.+)'>`)
+// regular expression which captures the CSRF token value from the HTML for user sign up (or the logout form in the nav)
+var csrfTokenRX = regexp.MustCompile(`<input type=['"]hidden['"] name=['"]csrf_token['"] value=['"]([^'"]+)['"]>`)
 
 func extractCSRFToken(t *testing.T, body string) string {
 	// extract token from HTML body. Returns an array with entire matched pattern at i[0], and values of any captured data in subsequent positions
@@ -112,3 +190,16 @@ func extractCSRFToken(t *testing.T, body string) string {
 	}
 	return html.UnescapeString(string(matches[1]))
 }
+
+// breachList returns a breached password list holding passwords.
+func breachList(t *testing.T, passwords ...string) *password.BreachList {
+	var lines strings.Builder
+	for _, p := range passwords {
+		fmt.Fprintf(&lines, "%X\n", sha1.Sum([]byte(p)))
+	}
+	list, err := password.LoadBreachList(strings.NewReader(lines.String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return list
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"snippetbox.audryhsu.com/internal/models"
+)
+
+const userUsage = `usage: snippetbox user [flags] role <email> user|moderator|admin | disable <email> | enable <email>`
+
+// runUser implements the "snippetbox user" subcommands, which manage accounts from the command line using the database settings from cfg.
+// It is how the first admin is made: sign up on the site, then run "snippetbox user role you@example.com admin".
+func runUser(cfg config, out io.Writer) error {
+	if len(cfg.args) < 2 {
+		return errors.New(userUsage)
+	}
+	command, email, args := cfg.args[0], cfg.args[1], cfg.args[2:]
+
+	dialect, err := models.DialectFor(cfg.dbDriver)
+	if err != nil {
+		return err
+	}
+	db, err := openDB(dialect, cfg.dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	store := models.Store{DB: db, Dialect: dialect, QueryTimeout: cfg.queryTimeout}
+	users := &models.UserModel{Store: store}
+	ctx := context.Background()
+	user, err := users.GetByEmail(ctx, email)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			return fmt.Errorf("user: no user with email %s", email)
+		}
+		return err
+	}
+
+	var events []*models.AuditEvent
+	switch command {
+	case "role":
+		if len(args) != 1 || !models.Role(args[0]).Valid() {
+			return errors.New(userUsage)
+		}
+		if err := users.SetRole(ctx, user.ID, models.Role(args[0])); err != nil {
+			return err
+		}
+		events = accessChangeEvents(user, models.Role(args[0]), user.Disabled)
+		fmt.Fprintf(out, "%s is now %s\n", email, args[0])
+	case "disable", "enable":
+		if err := users.SetDisabled(ctx, user.ID, command == "disable"); err != nil {
+			return err
+		}
+		events = accessChangeEvents(user, user.Role, command == "disable")
+		fmt.Fprintf(out, "%sd %s\n", command, email)
+	default:
+		return fmt.Errorf("user: unknown command %q\n%s", command, userUsage)
+	}
+
+	// the changes are audited like an admin's on the site, with no acting user or request, as nobody is logged in
+	auditLog := &models.AuditModel{Store: store}
+	for _, event := range events {
+		event.Details += " (command line)"
+		if err := auditLog.Record(ctx, event); err != nil {
+			return fmt.Errorf("user: recording %s in the audit log: %w", event.Action, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+// Package i18n holds snippetbox's message catalogs and picks the language to show each user.
+//
+// Catalogs are flat JSON objects in locales/<locale>.json mapping message keys to messages, e.g. "validation.max_chars": "This field cannot be more than {max} characters long".
+// {name} placeholders are filled in from the parameters passed to Translator.T.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/text/language"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+// DefaultLocale is used when nothing the user asks for is supported, and its catalog is the fallback for keys missing from other catalogs.
+const DefaultLocale = "en"
+
+//go:embed "locales"
+var files embed.FS
+
+// Bundle holds the catalogs of all supported locales.
+type Bundle struct {
+	catalogs map[string]map[string]string
+	locales  []string
+	matcher  language.Matcher
+}
+
+// New loads the embedded catalogs.
+func New() (*Bundle, error) {
+	return Load(files)
+}
+
+// Load reads every locales/*.json catalog in fsys. A catalog for DefaultLocale is required.
+func Load(fsys fs.FS) (*Bundle, error) {
+	paths, err := fs.Glob(fsys, "locales/*.json")
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bundle{catalogs: map[string]map[string]string{}}
+	for _, p := range paths {
+		locale := strings.TrimSuffix(path.Base(p), ".json")
+		if _, err := language.Parse(locale); err != nil {
+			return nil, fmt.Errorf("i18n: bad locale name %q: %w", p, err)
+		}
+		data, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			return nil, err
+		}
+		catalog := map[string]string{}
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			return nil, fmt.Errorf("i18n: parsing %s: %w", p, err)
+		}
+		b.catalogs[locale] = catalog
+		b.locales = append(b.locales, locale)
+	}
+	if _, ok := b.catalogs[DefaultLocale]; !ok {
+		return nil, fmt.Errorf("i18n: no catalog for the default locale %q", DefaultLocale)
+	}
+
+	// the default locale comes first, so the matcher falls back to it
+	sort.Slice(b.locales, func(i, j int) bool {
+		if b.locales[i] == DefaultLocale || b.locales[j] == DefaultLocale {
+			return b.locales[i] == DefaultLocale
+		}
+		return b.locales[i] < b.locales[j]
+	})
+	tags := make([]language.Tag, len(b.locales))
+	for i, locale := range b.locales {
+		tags[i] = language.MustParse(locale)
+	}
+	b.matcher = language.NewMatcher(tags)
+	return b, nil
+}
+
+// Locales returns the supported locales, default first.
+func (b *Bundle) Locales() []string {
+	return append([]string(nil), b.locales...)
+}
+
+// Supports reports whether there is a catalog for locale.
+func (b *Bundle) Supports(locale string) bool {
+	_, ok := b.catalogs[locale]
+	return ok
+}
+
+// Match returns the supported locale that best fits the preferences, which are tried in order. Each preference is a locale ("es") or an Accept-Language header value ("es-MX,es;q=0.9,en;q=0.8"); empty ones are skipped.
+// For example an explicit user preference can be passed before the browser's Accept-Language header.
+func (b *Bundle) Match(preferences ...string) string {
+	for _, pref := range preferences {
+		if pref == "" {
+			continue
+		}
+		tags, _, err := language.ParseAcceptLanguage(pref)
+		if err != nil || len(tags) == 0 {
+			continue
+		}
+		if _, index, confidence := b.matcher.Match(tags...); confidence != language.No {
+			return b.locales[index]
+		}
+	}
+	return DefaultLocale
+}
+
+// Name returns the name of locale in its own language (the "locale.name" message of its catalog), e.g. "Español" for es.
+func (b *Bundle) Name(locale string) string {
+	if name, ok := b.catalogs[locale]["locale.name"]; ok {
+		return name
+	}
+	return locale
+}
+
+// Translator returns a Translator for locale, which must be supported (see Match).
+func (b *Bundle) Translator(locale string) Translator {
+	if !b.Supports(locale) {
+		locale = DefaultLocale
+	}
+	return Translator{bundle: b, locale: locale}
+}
+
+// Translator translates message keys into one locale. The zero Translator returns keys untranslated.
+type Translator struct {
+	bundle *Bundle
+	locale string
+}
+
+// Locale returns the translator's locale.
+func (t Translator) Locale() string {
+	return t.locale
+}
+
+// T returns the message for key with its {name} placeholders replaced by params. Keys missing from the locale's catalog fall back to the default locale, and keys missing from both are returned as they are.
+func (t Translator) T(key string, params map[string]any) string {
+	if t.bundle == nil {
+		return key
+	}
+	message, ok := t.bundle.catalogs[t.locale][key]
+	if !ok {
+		if message, ok = t.bundle.catalogs[DefaultLocale][key]; !ok {
+			return key
+		}
+	}
+	if len(params) == 0 {
+		return message
+	}
+
+	pairs := make([]string, 0, 2*len(params))
+	for name, value := range params {
+		pairs = append(pairs, "{"+name+"}", fmt.Sprint(value))
+	}
+	return strings.NewReplacer(pairs...).Replace(message)
+}
@@ -0,0 +1,157 @@
+package i18n_test
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"snippetbox.audryhsu.com/internal/assert"
+	"snippetbox.audryhsu.com/internal/i18n"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMatch(t *testing.T) {
+	bundle, err := i18n.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		preferences []string
+		want        string
+	}{
+		{
+			name: "No preferences",
+			want: "en",
+		},
+		{
+			name:        "Accept-Language",
+			preferences: []string{"es-MX,es;q=0.9,en;q=0.8"},
+			want:        "es",
+		},
+		{
+			name:        "Quality values",
+			preferences: []string{"es;q=0.5,en;q=0.9"},
+			want:        "en",
+		},
+		{
+			name:        "Unsupported language",
+			preferences: []string{"fr-FR,fr;q=0.9"},
+			want:        "en",
+		},
+		{
+			name:        "User choice before Accept-Language",
+			preferences: []string{"en", "es"},
+			want:        "en",
+		},
+		{
+			name:        "Empty and invalid preferences are skipped",
+			preferences: []string{"", "not a language!", "es"},
+			want:        "es",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, bundle.Match(tt.preferences...), tt.want)
+		})
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	bundle, err := i18n.Load(fstest.MapFS{
+		"locales/en.json": {Data: []byte(`{"greeting": "Hello {name}, you have {count} messages", "only.en": "English only"}`)},
+		"locales/es.json": {Data: []byte(`{"greeting": "Hola {name}, tienes {count} mensajes"}`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params := map[string]any{"name": "Alice", "count": 3}
+
+	tests := []struct {
+		name       string
+		translator i18n.Translator
+		key        string
+		want       string
+	}{
+		{
+			name:       "Placeholders",
+			translator: bundle.Translator("es"),
+			key:        "greeting",
+			want:       "Hola Alice, tienes 3 mensajes",
+		},
+		{
+			name:       "Falls back to the default locale",
+			translator: bundle.Translator("es"),
+			key:        "only.en",
+			want:       "English only",
+		},
+		{
+			name:       "Unknown key",
+			translator: bundle.Translator("es"),
+			key:        "missing",
+			want:       "missing",
+		},
+		{
+			name:       "Unsupported locale",
+			translator: bundle.Translator("fr"),
+			key:        "greeting",
+			want:       "Hello Alice, you have 3 messages",
+		},
+		{
+			name:       "Zero Translator",
+			translator: i18n.Translator{},
+			key:        "greeting",
+			want:       "greeting",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.translator.T(tt.key, params), tt.want)
+		})
+	}
+}
+
+func TestLoadRequiresDefaultLocale(t *testing.T) {
+	_, err := i18n.Load(fstest.MapFS{
+		"locales/es.json": {Data: []byte(`{}`)},
+	})
+	if err == nil {
+		t.Fatal("expected an error for a missing default catalog")
+	}
+}
+
+// TestCatalogsComplete checks that every catalog has the same keys, so no page falls back to English halfway through.
+func TestCatalogsComplete(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("locales", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := func(path string) []string {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		catalog := map[string]string{}
+		if err := json.Unmarshal(data, &catalog); err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for key := range catalog {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	want := keys(filepath.Join("locales", i18n.DefaultLocale+".json"))
+	for _, path := range paths {
+		assert.Equal(t, strings.Join(keys(path), "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,375 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"snippetbox.audryhsu.com/internal/models"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoMigrations is returned by Down when there is nothing left to roll back.
+var ErrNoMigrations = errors.New("migrate: no applied migrations")
+
+// migrationRX matches migration file names such as 0001_create_snippets.up.sql
+var migrationRX = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// lockName identifies snippetbox's migration lock in MySQL (GET_LOCK) and PostgreSQL (pg_advisory_lock needs a number; 0x736e6970 is "snip" in ASCII).
+const (
+	lockName = "snippetbox_migrate"
+	lockKey  = 0x736e6970
+)
+
+// Migration is one versioned schema change with its up and down SQL.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status describes whether a migration has been applied, and when.
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// Migrator applies the migrations in FS to DB and records them in the schema_migrations table.
+type Migrator struct {
+	DB      *sql.DB
+	Dialect models.Dialect
+	// FS holds the migration files for Dialect, e.g. fs.Sub(migrations.Files, "sqlite")
+	FS fs.FS
+}
+
+// New returns a Migrator for db using the folder named after the dialect in fsys (see migrations.Files).
+func New(db *sql.DB, dialect models.Dialect, fsys fs.FS) (*Migrator, error) {
+	sub, err := fs.Sub(fsys, dialect.Name())
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Dialect: dialect, FS: sub}, nil
+}
+
+// Migrations reads and returns all migrations in FS, ordered by version.
+func (m *Migrator) Migrations() ([]Migration, error) {
+	files, err := fs.Glob(m.FS, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, file := range files {
+		matches := migrationRX.FindStringSubmatch(file)
+		if matches == nil {
+			return nil, fmt.Errorf("migrate: bad migration file name %q", file)
+		}
+		version, _ := strconv.Atoi(matches[1])
+		b, err := fs.ReadFile(m.FS, file)
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = mig
+		} else if mig.Name != matches[2] {
+			return nil, fmt.Errorf("migrate: version %d is used by both %q and %q", version, mig.Name, matches[2])
+		}
+		if matches[3] == "up" {
+			mig.Up = string(b)
+		} else {
+			mig.Down = string(b)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migrate: migration %d_%s has no up file", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Up applies every pending migration in version order and returns the ones it applied.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		statuses, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			if s.Applied {
+				continue
+			}
+			insert := m.Dialect.Rebind(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`)
+			if err := m.run(ctx, conn, s.Up, insert, s.Version, s.Name, time.Now().UTC()); err != nil {
+				return fmt.Errorf("migrate: applying %d_%s: %w", s.Version, s.Name, err)
+			}
+			applied = append(applied, s.Migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down rolls back the most recently applied migrations, up to steps of them, and returns the ones it rolled back.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var rolledBack []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		statuses, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for i := len(statuses) - 1; i >= 0 && len(rolledBack) < steps; i-- {
+			s := statuses[i]
+			if !s.Applied {
+				continue
+			}
+			if s.Down == "" {
+				return fmt.Errorf("migrate: migration %d_%s has no down file", s.Version, s.Name)
+			}
+			remove := m.Dialect.Rebind(`DELETE FROM schema_migrations WHERE version = ?`)
+			if err := m.run(ctx, conn, s.Down, remove, s.Version); err != nil {
+				return fmt.Errorf("migrate: rolling back %d_%s: %w", s.Version, s.Name, err)
+			}
+			rolledBack = append(rolledBack, s.Migration)
+		}
+		if len(rolledBack) == 0 {
+			return ErrNoMigrations
+		}
+		return nil
+	})
+	return rolledBack, err
+}
+
+// Baseline records every migration up to and including version as applied, without running it, and returns the ones it recorded.
+// It adopts a database whose schema was made by hand, or by an older snippetbox, before migrations were tracked: Up would otherwise fail creating tables that already exist.
+func (m *Migrator) Baseline(ctx context.Context, version int) ([]Migration, error) {
+	var recorded []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		statuses, err := m.status(ctx, conn)
+		if err != nil {
+			return err
+		}
+		known := false
+		for _, s := range statuses {
+			if s.Version == version {
+				known = true
+			}
+		}
+		if !known {
+			return fmt.Errorf("migrate: no migration with version %d", version)
+		}
+
+		for _, s := range statuses {
+			if s.Version > version || s.Applied {
+				continue
+			}
+			insert := m.Dialect.Rebind(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`)
+			if _, err := conn.ExecContext(ctx, insert, s.Version, s.Name, time.Now().UTC()); err != nil {
+				return fmt.Errorf("migrate: recording %d_%s: %w", s.Version, s.Name, err)
+			}
+			recorded = append(recorded, s.Migration)
+		}
+		return nil
+	})
+	return recorded, err
+}
+
+// Status returns every known migration and whether it has been applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := m.ensureTable(ctx, conn); err != nil {
+		return nil, err
+	}
+	return m.status(ctx, conn)
+}
+
+// status joins the migration files with the rows of schema_migrations.
+func (m *Migrator) status(ctx context.Context, conn *sql.Conn) ([]Status, error) {
+	migrations, err := m.Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	appliedAt := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		appliedAt[version] = at
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, len(migrations))
+	for i, mig := range migrations {
+		at, ok := appliedAt[mig.Version]
+		statuses[i] = Status{Migration: mig, Applied: ok, AppliedAt: at}
+	}
+	return statuses, nil
+}
+
+// run executes the statements of one migration plus the schema_migrations bookkeeping statement in a single transaction.
+// Note that MySQL commits DDL statements implicitly, so a failing MySQL migration may be left partially applied.
+func (m *Migrator) run(ctx context.Context, conn *sql.Conn, script, bookkeeping string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// Rollback is a no-op once the transaction has been committed
+	defer tx.Rollback()
+
+	for _, stmt := range splitStatements(script) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, bookkeeping, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// withLock runs fn on a dedicated connection while holding the migration lock, so two app instances starting at once don't both migrate.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	// advisory locks belong to a database session, so everything has to happen on one connection rather than the pool
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	switch m.Dialect {
+	case models.MySQL:
+		var ok sql.NullInt64
+		if err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, 60)`, lockName).Scan(&ok); err != nil {
+			return err
+		}
+		if ok.Int64 != 1 {
+			return errors.New("migrate: timed out waiting for the migration lock")
+		}
+		defer conn.ExecContext(context.Background(), `SELECT RELEASE_LOCK(?)`, lockName)
+	case models.Postgres:
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+			return err
+		}
+		defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+	default:
+		// SQLite has no advisory locks. Its database lock serialises the migration transactions, and a second migrator
+		// trying to apply the same version fails on the schema_migrations primary key and rolls back.
+	}
+
+	if err := m.ensureTable(ctx, conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// ensureTable creates the schema_migrations table if it doesn't exist yet.
+func (m *Migrator) ensureTable(ctx context.Context, conn *sql.Conn) error {
+	timestamp := "DATETIME"
+	if m.Dialect == models.Postgres {
+		timestamp = "TIMESTAMPTZ"
+	}
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version BIGINT NOT NULL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    applied_at `+timestamp+` NOT NULL
+)`)
+	return err
+}
+
+// splitStatements splits a migration script on the semicolons that end each statement, dropping any chunk that is only comments.
+// Migrations must not contain semicolons inside string literals.
+func splitStatements(script string) []string {
+	var stmts []string
+	for _, chunk := range strings.Split(script, ";") {
+		stmt := strings.TrimSpace(chunk)
+		code := false
+		for _, line := range strings.Split(stmt, "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "--") {
+				code = true
+				break
+			}
+		}
+		if code {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts
+}
+
+// Create writes empty up and down files for a new migration into each dialect's folder under dir, numbered one after the highest existing version. It returns the paths of the files it created.
+func Create(dir, name string, dialects ...models.Dialect) ([]string, error) {
+	if !regexp.MustCompile(`^\w+$`).MatchString(name) {
+		return nil, fmt.Errorf("migrate: migration name %q must only contain letters, digits and underscores", name)
+	}
+
+	next := 1
+	for _, d := range dialects {
+		files, err := filepath.Glob(filepath.Join(dir, d.Name(), "*.sql"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if matches := migrationRX.FindStringSubmatch(path.Base(filepath.ToSlash(file))); matches != nil {
+				if version, _ := strconv.Atoi(matches[1]); version >= next {
+					next = version + 1
+				}
+			}
+		}
+	}
+
+	var created []string
+	for _, d := range dialects {
+		for _, direction := range []string{"up", "down"} {
+			file := filepath.Join(dir, d.Name(), fmt.Sprintf("%04d_%s.%s.sql", next, name, direction))
+			contents := fmt.Sprintf("-- %04d_%s (%s) for %s\n", next, name, direction, d.Name())
+			// O_EXCL makes sure an existing migration is never overwritten
+			f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+			if err != nil {
+				return created, err
+			}
+			_, err = f.WriteString(contents)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return created, err
+			}
+			created = append(created, file)
+		}
+	}
+	return created, nil
+}
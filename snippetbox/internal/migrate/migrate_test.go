@@ -0,0 +1,174 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"snippetbox.audryhsu.com/internal/assert"
+	"snippetbox.audryhsu.com/internal/models"
+	"snippetbox.audryhsu.com/migrations"
+	"testing"
+)
+
+// newTestMigrator returns a Migrator for a fresh SQLite database, which needs no server so these tests always run.
+func newTestMigrator(t *testing.T) *Migrator {
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_time_format=sqlite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	m, err := New(db, models.SQLite, migrations.Files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestUpDown(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMigrator(t)
+
+	all, err := m.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	applied, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(applied), len(all))
+
+	// running up again is a no-op
+	applied, err = m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(applied), 0)
+
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		assert.Equal(t, s.Applied, true)
+	}
+
+	// the tables exist once migrated
+	var count int
+	if err := m.DB.QueryRow("SELECT COUNT(*) FROM snippets").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+
+	rolledBack, err := m.Down(ctx, len(all))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(rolledBack), len(all))
+	assert.Equal(t, rolledBack[0].Version, all[len(all)-1].Version)
+
+	if _, err := m.Down(ctx, 1); !errors.Is(err, ErrNoMigrations) {
+		t.Errorf("got %v, expected ErrNoMigrations", err)
+	}
+	if err := m.DB.QueryRow("SELECT COUNT(*) FROM snippets").Scan(&count); err == nil {
+		t.Error("snippets table still exists after rolling back every migration")
+	}
+}
+
+// TestBaseline checks that a database whose snippets, users and sessions tables were made by hand can be adopted and brought up to date.
+func TestBaseline(t *testing.T) {
+	ctx := context.Background()
+	m := newTestMigrator(t)
+
+	// the schema the setup instructions had people create before migrations were tracked
+	for _, stmt := range []string{
+		`CREATE TABLE snippets (id INTEGER PRIMARY KEY AUTOINCREMENT, title VARCHAR(100) NOT NULL, content TEXT NOT NULL, created DATETIME NOT NULL, expires DATETIME NOT NULL)`,
+		`CREATE INDEX idx_snippets_created ON snippets(created)`,
+		`CREATE TABLE users (id INTEGER PRIMARY KEY AUTOINCREMENT, name VARCHAR(255) NOT NULL, email VARCHAR(255) NOT NULL, hashed_password CHAR(60) NOT NULL, created DATETIME NOT NULL, CONSTRAINT users_uc_email UNIQUE (email))`,
+		`CREATE TABLE sessions (token TEXT PRIMARY KEY, data BLOB NOT NULL, expiry REAL NOT NULL)`,
+		`CREATE INDEX sessions_expiry_idx ON sessions(expiry)`,
+		`INSERT INTO snippets (title, content, created, expires) VALUES ('Kept', 'An old silent pond', '2022-01-01 00:00:00', '2099-01-01 00:00:00')`,
+	} {
+		if _, err := m.DB.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := m.Up(ctx); err == nil {
+		t.Fatal("expected up to fail on tables that already exist")
+	}
+
+	if _, err := m.Baseline(ctx, 99); err == nil {
+		t.Error("expected an error for an unknown version")
+	}
+	recorded, err := m.Baseline(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(recorded), 3)
+	assert.Equal(t, recorded[2].Name, "create_sessions")
+
+	all, err := m.Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied, err := m.Up(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(applied), len(all)-3)
+
+	// the existing data survives, and the later migrations' columns are there
+	var title string
+	var private bool
+	if err := m.DB.QueryRow("SELECT title, private FROM snippets").Scan(&title, &private); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, title, "Kept")
+	assert.Equal(t, private, false)
+
+	// baselining again records nothing
+	recorded, err = m.Baseline(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(recorded), 0)
+}
+
+// TestDialectsInStep checks that every driver has the same migrations, so the schemas don't drift apart.
+func TestDialectsInStep(t *testing.T) {
+	var want []Migration
+	for _, d := range []models.Dialect{models.MySQL, models.SQLite, models.Postgres} {
+		t.Run(d.Name(), func(t *testing.T) {
+			m, err := New(nil, d, migrations.Files)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := m.Migrations()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want == nil {
+				want = got
+			}
+			assert.Equal(t, len(got), len(want))
+			for i := range got {
+				if i < len(want) {
+					assert.Equal(t, got[i].Version, want[i].Version)
+					assert.Equal(t, got[i].Name, want[i].Name)
+				}
+				if got[i].Down == "" {
+					t.Errorf("%d_%s has no down migration", got[i].Version, got[i].Name)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	stmts := splitStatements("-- a comment\nCREATE TABLE a (id INT);\n\nCREATE INDEX i ON a (id);\n-- trailing comment\n")
+	assert.Equal(t, len(stmts), 2)
+	assert.StringContains(t, stmts[1], "CREATE INDEX")
+}
@@ -0,0 +1,154 @@
+package models
+
+import (
+	"context"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// AuditAction is the kind of a security-relevant event in the audit log.
+type AuditAction string
+
+const (
+	AuditSignup         AuditAction = "signup"
+	AuditLogin          AuditAction = "login"
+	AuditLoginFailed    AuditAction = "login_failed"
+	AuditLogout         AuditAction = "logout"
+	AuditPasswordChange AuditAction = "password_change"
+	AuditSnippetCreate  AuditAction = "snippet_create"
+	AuditSnippetDelete  AuditAction = "snippet_delete"
+	// AuditSessionRejected is recorded when a logged in session stops being accepted because its account was disabled or deleted.
+	AuditSessionRejected AuditAction = "session_rejected"
+	// AuditSessionRevoke is recorded when a user logs out some of their other sessions from their account page.
+	AuditSessionRevoke AuditAction = "session_revoke"
+	// AuditReauth is recorded when a logged in user enters their password again to carry out a sensitive action.
+	AuditReauth AuditAction = "reauth"
+	// AuditRoleChange, AuditUserDisable and AuditUserEnable are recorded when an admin, or the "snippetbox user" command, changes another user's account.
+	AuditRoleChange  AuditAction = "role_change"
+	AuditUserDisable AuditAction = "user_disable"
+	AuditUserEnable  AuditAction = "user_enable"
+)
+
+// AuditActions lists every action, in the order the audit log viewer offers them as filters.
+var AuditActions = []AuditAction{AuditSignup, AuditLogin, AuditLoginFailed, AuditLogout, AuditPasswordChange, AuditSnippetCreate, AuditSnippetDelete, AuditSessionRejected, AuditSessionRevoke, AuditReauth, AuditRoleChange, AuditUserDisable, AuditUserEnable}
+
+// AuditEvent is an entry in the audit log: who did what, when, and from where.
+type AuditEvent struct {
+	ID      int         `json:"id"`
+	Created time.Time   `json:"created"`
+	Action  AuditAction `json:"action"`
+	// UserID is the acting user, or 0 for anonymous requests such as failed logins.
+	UserID    int    `json:"user_id"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	RequestID string `json:"request_id"`
+	// Details say what the action was about, e.g. "snippet 12" or the email address of a failed login.
+	Details string `json:"details"`
+}
+
+// AuditFilter selects audit events for AuditModel.List. Zero fields match every event.
+type AuditFilter struct {
+	Action AuditAction
+	UserID int
+	IP     string
+	// Since and Until bound the events' Created time: Since <= Created < Until.
+	Since time.Time
+	Until time.Time
+	// Before only matches events older than the one with that id, to page through the log.
+	Before int
+	// Limit is the most events to return.
+	Limit int
+}
+
+type AuditModelInterface interface {
+	Record(ctx context.Context, event *AuditEvent) error
+	List(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error)
+}
+
+// AuditModel stores the audit log. It is append-only: events can be recorded and listed, but never changed or removed.
+type AuditModel struct {
+	Store
+}
+
+// Record adds an event to the audit log, timestamped now. Text fields that don't fit their columns are cut short.
+// The event is written even if ctx is cancelled, e.g. because the client went away, so the log doesn't miss the end of a request.
+func (m *AuditModel) Record(ctx context.Context, event *AuditEvent) error {
+	ctx, cancel := withTimeout(detach(ctx), m.QueryTimeout)
+	defer cancel()
+
+	var userID any
+	if event.UserID != 0 {
+		userID = event.UserID
+	}
+	stmt := `INSERT INTO audit_events (created, action, user_id, ip, user_agent, request_id, details) VALUES (?, ?, ?, ?, ?, ?, ?)`
+	_, err := m.DB.ExecContext(ctx, m.rebind(stmt), time.Now().UTC(), string(event.Action), userID,
+		truncate(event.IP, 45), truncate(event.UserAgent, 255), truncate(event.RequestID, 32), truncate(event.Details, 255))
+	return dbError(err)
+}
+
+// List returns the events matching filter, newest first.
+func (m *AuditModel) List(ctx context.Context, filter AuditFilter) ([]*AuditEvent, error) {
+	ctx, cancel := withTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	var where []string
+	var args []any
+	add := func(cond string, arg any) {
+		where = append(where, cond)
+		args = append(args, arg)
+	}
+	if filter.Action != "" {
+		add("action = ?", string(filter.Action))
+	}
+	if filter.UserID != 0 {
+		add("user_id = ?", filter.UserID)
+	}
+	if filter.IP != "" {
+		add("ip = ?", filter.IP)
+	}
+	if !filter.Since.IsZero() {
+		add("created >= ?", filter.Since.UTC())
+	}
+	if !filter.Until.IsZero() {
+		add("created < ?", filter.Until.UTC())
+	}
+	if filter.Before != 0 {
+		add("id < ?", filter.Before)
+	}
+
+	stmt := `SELECT id, created, action, COALESCE(user_id, 0), ip, user_agent, request_id, details FROM audit_events`
+	if len(where) > 0 {
+		stmt += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	stmt += ` ORDER BY id DESC LIMIT ?`
+	args = append(args, filter.Limit)
+
+	rows, err := m.DB.QueryContext(ctx, m.rebind(stmt), args...)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	var events []*AuditEvent
+	for rows.Next() {
+		e := &AuditEvent{}
+		err := rows.Scan(&e.ID, &e.Created, &e.Action, &e.UserID, &e.IP, &e.UserAgent, &e.RequestID, &e.Details)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		events = append(events, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError(err)
+	}
+	return events, nil
+}
+
+// truncate cuts s down to at most n characters.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n])
+}
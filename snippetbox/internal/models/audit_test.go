@@ -0,0 +1,79 @@
+package models_test
+
+import (
+	"context"
+	"snippetbox.audryhsu.com/internal/assert"
+	"snippetbox.audryhsu.com/internal/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditModel(t *testing.T) {
+	db := newTestDB(t)
+	m := &models.AuditModel{Store: db.store()}
+	ctx := context.Background()
+
+	record := []*models.AuditEvent{
+		{Action: models.AuditSignup, IP: "192.0.2.1", UserAgent: "curl/8.0", RequestID: "a1", Details: "alice@example.com"},
+		{Action: models.AuditLoginFailed, IP: "192.0.2.1", Details: "alice@example.com"},
+		{Action: models.AuditLogin, UserID: 1, IP: "192.0.2.1", RequestID: "a3"},
+		{Action: models.AuditSnippetCreate, UserID: 1, IP: "198.51.100.7", Details: "snippet 1"},
+		{Action: models.AuditLogin, UserID: 2, IP: "198.51.100.7", UserAgent: strings.Repeat("x", 300)},
+	}
+	for _, e := range record {
+		if err := m.Record(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all, err := m.List(ctx, models.AuditFilter{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(all), 5)
+	// newest first
+	assert.Equal(t, all[0].UserID, 2)
+	assert.Equal(t, len(all[0].UserAgent), 255)
+	assert.Equal(t, all[4].Action, models.AuditSignup)
+	assert.Equal(t, all[4].UserID, 0)
+	assert.Equal(t, all[4].UserAgent, "curl/8.0")
+	assert.Equal(t, all[4].RequestID, "a1")
+	assert.Equal(t, all[4].Details, "alice@example.com")
+
+	now := time.Now()
+	tests := []struct {
+		name    string
+		filter  models.AuditFilter
+		wantIDs []int
+	}{
+		{name: "action", filter: models.AuditFilter{Action: models.AuditLogin}, wantIDs: []int{all[0].ID, all[2].ID}},
+		{name: "user", filter: models.AuditFilter{UserID: 1}, wantIDs: []int{all[1].ID, all[2].ID}},
+		{name: "ip", filter: models.AuditFilter{IP: "198.51.100.7"}, wantIDs: []int{all[0].ID, all[1].ID}},
+		{name: "action and user", filter: models.AuditFilter{Action: models.AuditLogin, UserID: 1}, wantIDs: []int{all[2].ID}},
+		{name: "before", filter: models.AuditFilter{Before: all[2].ID}, wantIDs: []int{all[3].ID, all[4].ID}},
+		{name: "limit", filter: models.AuditFilter{Limit: 2}, wantIDs: []int{all[0].ID, all[1].ID}},
+		{name: "time range", filter: models.AuditFilter{Since: now.Add(-time.Hour), Until: now.Add(time.Hour)}, wantIDs: []int{all[0].ID, all[1].ID, all[2].ID, all[3].ID, all[4].ID}},
+		{name: "future", filter: models.AuditFilter{Since: now.Add(time.Hour)}},
+		{name: "no match", filter: models.AuditFilter{Action: models.AuditLogout}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.filter.Limit == 0 {
+				test.filter.Limit = 10
+			}
+			events, err := m.List(ctx, test.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []int
+			for _, e := range events {
+				ids = append(ids, e.ID)
+			}
+			assert.Equal(t, len(ids), len(test.wantIDs))
+			for i := range test.wantIDs {
+				assert.Equal(t, ids[i], test.wantIDs[i])
+			}
+		})
+	}
+}
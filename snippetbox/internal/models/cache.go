@@ -0,0 +1,222 @@
+package models
+
+import (
+	"container/list"
+	"context"
+	"golang.org/x/sync/singleflight"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latestKey is the cache key of the Latest() list; snippets are cached under their id.
+const latestKey = "latest"
+
+// CachedSnippetModel is a read-through cache in front of another SnippetModelInterface (usually *SnippetModel). It implements SnippetModelInterface itself, so handlers don't know it's there.
+// Snippets and the Latest() list are kept in an in-process LRU. An entry lives for the TTL, but never past the Expires of the snippets in it, so expired snippets aren't served from the cache.
+// Concurrent misses for the same key share one query. Insert invalidates the Latest() list and Delete the snippet and the list; anything else that changes a snippet must call Invalidate.
+// The cache is per process: with several app instances, another instance's inserts show up in Latest() after at most the TTL.
+type CachedSnippetModel struct {
+	snippets SnippetModelInterface
+	size     int
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	lru     *list.List // front is most recently used; elements hold *cacheEntry
+	entries map[string]*list.Element
+	// generation is bumped by every invalidation, so a fetch that started before it doesn't cache its now stale result
+	generation uint64
+	group      singleflight.Group
+
+	hits, misses atomic.Uint64
+}
+
+// cacheEntry is one cached Get() or Latest() result.
+type cacheEntry struct {
+	key     string
+	value   any // *Snippet or []*Snippet
+	expires time.Time
+}
+
+// CacheStats is a snapshot of a cache's counters.
+type CacheStats struct {
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Size   int    `json:"size"`
+}
+
+// NewCachedSnippetModel returns a cache in front of snippets which holds up to size entries for at most ttl each.
+func NewCachedSnippetModel(snippets SnippetModelInterface, size int, ttl time.Duration) *CachedSnippetModel {
+	return &CachedSnippetModel{
+		snippets: snippets,
+		size:     size,
+		ttl:      ttl,
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+	}
+}
+
+// Insert adds the snippet through the underlying model. The new snippet belongs at the top of Latest(), so the cached list is dropped.
+func (c *CachedSnippetModel) Insert(ctx context.Context, userID int, title, content string, expires int, private bool) (int, error) {
+	id, err := c.snippets.Insert(ctx, userID, title, content, expires, private)
+	if err == nil {
+		c.remove(latestKey)
+	}
+	return id, err
+}
+
+// Get returns the snippet from the cache, or loads and caches it. Errors (including ErrNoRecord) are never cached.
+func (c *CachedSnippetModel) Get(ctx context.Context, id int) (*Snippet, error) {
+	value, err := c.load(ctx, strconv.Itoa(id), func(ctx context.Context) (any, time.Time, error) {
+		snippet, err := c.snippets.Get(ctx, id)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		return snippet, snippet.Expires, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// hand out copies, so a caller changing its snippet can't change the cached one
+	snippet := *value.(*Snippet)
+	return &snippet, nil
+}
+
+// Latest returns the latest snippets from the cache, or loads and caches them. The list is cached until the first of its snippets expires.
+func (c *CachedSnippetModel) Latest(ctx context.Context) ([]*Snippet, error) {
+	value, err := c.load(ctx, latestKey, func(ctx context.Context) (any, time.Time, error) {
+		snippets, err := c.snippets.Latest(ctx)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		var firstExpiry time.Time
+		for _, s := range snippets {
+			if firstExpiry.IsZero() || s.Expires.Before(firstExpiry) {
+				firstExpiry = s.Expires
+			}
+		}
+		return snippets, firstExpiry, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	cached := value.([]*Snippet)
+	snippets := make([]*Snippet, len(cached))
+	for i, s := range cached {
+		snippet := *s
+		snippets[i] = &snippet
+	}
+	return snippets, nil
+}
+
+// Delete removes the snippet through the underlying model and drops it from the cache.
+func (c *CachedSnippetModel) Delete(ctx context.Context, id int) error {
+	err := c.snippets.Delete(ctx, id)
+	c.Invalidate(id)
+	return err
+}
+
+// Counts passes straight through to the underlying model; the dashboard statistics are never cached.
+func (c *CachedSnippetModel) Counts(ctx context.Context) (*SnippetCounts, error) {
+	return c.snippets.Counts(ctx)
+}
+
+// Invalidate drops snippet id and the Latest() list from the cache. Call it after changing or deleting a snippet.
+func (c *CachedSnippetModel) Invalidate(id int) {
+	c.remove(strconv.Itoa(id))
+	c.remove(latestKey)
+}
+
+// Stats returns the cache's hit and miss counts and its current number of entries.
+func (c *CachedSnippetModel) Stats() CacheStats {
+	c.mu.Lock()
+	size := c.lru.Len()
+	c.mu.Unlock()
+	return CacheStats{Hits: c.hits.Load(), Misses: c.misses.Load(), Size: size}
+}
+
+// load returns the cached value for key, or calls fetch and caches its result until the earlier of the TTL and the expiry fetch returns (zero means no expiry of its own).
+// Concurrent misses for the same key wait for a single fetch. The fetch runs with a context detached from the caller's cancellation, so one client going away doesn't fail the others waiting on it; the query timeout still applies.
+func (c *CachedSnippetModel) load(ctx context.Context, key string, fetch func(ctx context.Context) (any, time.Time, error)) (any, error) {
+	if value, ok := c.get(key); ok {
+		c.hits.Add(1)
+		return value, nil
+	}
+	c.misses.Add(1)
+
+	value, err, _ := c.group.Do(key, func() (any, error) {
+		c.mu.Lock()
+		generation := c.generation
+		c.mu.Unlock()
+
+		value, expires, err := fetch(detach(ctx))
+		if err != nil {
+			return nil, err
+		}
+		c.set(key, value, expires, generation)
+		return value, nil
+	})
+	return value, err
+}
+
+// get returns the unexpired entry for key and marks it as recently used.
+func (c *CachedSnippetModel) get(key string) (any, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if !time.Now().Before(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.value, true
+}
+
+// set caches value under key, evicting the least recently used entries if the cache is full. Values that have already expired, or were fetched before the latest invalidation, aren't cached.
+func (c *CachedSnippetModel) set(key string, value any, expires time.Time, generation uint64) {
+	deadline := time.Now().Add(c.ttl)
+	if !expires.IsZero() && expires.Before(deadline) {
+		deadline = expires
+	}
+	if !time.Now().Before(deadline) || c.size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if generation != c.generation {
+		return
+	}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = &cacheEntry{key: key, value: value, expires: deadline}
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, value: value, expires: deadline})
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// remove drops key from the cache. Fetches already in flight won't cache their results, and later callers don't wait for them but start a fresh fetch.
+func (c *CachedSnippetModel) remove(key string) {
+	c.group.Forget(key)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if elem, ok := c.entries[key]; ok {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+	}
+}
@@ -0,0 +1,235 @@
+package models_test
+
+import (
+	"context"
+	"errors"
+	"snippetbox.audryhsu.com/internal/assert"
+	"snippetbox.audryhsu.com/internal/models"
+	"snippetbox.audryhsu.com/internal/models/mocks"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// countingSnippets wraps the mock snippet model, counting the calls that reach it. Get returns the mock snippet under any id below 100 (and ErrNoRecord above), returned snippets expire at expires, and release (if set) holds Get calls until it is closed.
+type countingSnippets struct {
+	mocks.SnippetModel
+	expires time.Time
+	release chan struct{}
+	gets    atomic.Int64
+	latests atomic.Int64
+}
+
+func newCountingSnippets() *countingSnippets {
+	return &countingSnippets{expires: time.Now().Add(time.Hour)}
+}
+
+func (m *countingSnippets) Get(ctx context.Context, id int) (*models.Snippet, error) {
+	m.gets.Add(1)
+	if m.release != nil {
+		<-m.release
+	}
+	if id >= 100 {
+		return nil, models.ErrNoRecord
+	}
+	snippet, err := m.SnippetModel.Get(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+	s := *snippet
+	s.ID = id
+	s.Expires = m.expires
+	return &s, nil
+}
+
+func (m *countingSnippets) Latest(ctx context.Context) ([]*models.Snippet, error) {
+	m.latests.Add(1)
+	snippets, err := m.SnippetModel.Latest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s := *snippets[0]
+	s.Expires = m.expires
+	return []*models.Snippet{&s}, nil
+}
+
+func TestCachedSnippetModelGet(t *testing.T) {
+	ctx := context.Background()
+	backend := newCountingSnippets()
+	cache := models.NewCachedSnippetModel(backend, 10, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		snippet, err := cache.Get(ctx, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, snippet.Title, "An old silent pond")
+	}
+	assert.Equal(t, backend.gets.Load(), int64(1))
+	assert.Equal(t, cache.Stats(), models.CacheStats{Hits: 2, Misses: 1, Size: 1})
+
+	// callers get copies they can't use to change the cache
+	snippet, _ := cache.Get(ctx, 1)
+	snippet.Title = "changed"
+	snippet, _ = cache.Get(ctx, 1)
+	assert.Equal(t, snippet.Title, "An old silent pond")
+
+	// errors are never cached
+	for i := 0; i < 2; i++ {
+		if _, err := cache.Get(ctx, 509); !errors.Is(err, models.ErrNoRecord) {
+			t.Fatalf("got %v; want ErrNoRecord", err)
+		}
+	}
+	assert.Equal(t, backend.gets.Load(), int64(3))
+}
+
+func TestCachedSnippetModelExpiry(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		ttl     time.Duration
+		expires time.Duration // snippet expiry, from now
+	}{
+		{
+			name:    "TTL",
+			ttl:     50 * time.Millisecond,
+			expires: time.Hour,
+		},
+		{
+			name:    "Snippet expires before TTL",
+			ttl:     time.Hour,
+			expires: 50 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := newCountingSnippets()
+			backend.expires = time.Now().Add(tt.expires)
+			cache := models.NewCachedSnippetModel(backend, 10, tt.ttl)
+
+			cache.Get(ctx, 1)
+			cache.Latest(ctx)
+			cache.Get(ctx, 1)
+			cache.Latest(ctx)
+			assert.Equal(t, backend.gets.Load(), int64(1))
+			assert.Equal(t, backend.latests.Load(), int64(1))
+
+			time.Sleep(100 * time.Millisecond)
+			cache.Get(ctx, 1)
+			cache.Latest(ctx)
+			assert.Equal(t, backend.gets.Load(), int64(2))
+			assert.Equal(t, backend.latests.Load(), int64(2))
+		})
+	}
+
+	t.Run("Already expired", func(t *testing.T) {
+		backend := newCountingSnippets()
+		backend.expires = time.Now().Add(-time.Minute)
+		cache := models.NewCachedSnippetModel(backend, 10, time.Hour)
+		cache.Get(ctx, 1)
+		cache.Get(ctx, 1)
+		assert.Equal(t, backend.gets.Load(), int64(2))
+		assert.Equal(t, cache.Stats().Size, 0)
+	})
+}
+
+func TestCachedSnippetModelEviction(t *testing.T) {
+	ctx := context.Background()
+	backend := newCountingSnippets()
+	cache := models.NewCachedSnippetModel(backend, 2, time.Hour)
+
+	cache.Get(ctx, 1)
+	cache.Get(ctx, 2)
+	// touch 1, so 2 is the least recently used when 3 comes in
+	cache.Get(ctx, 1)
+	cache.Get(ctx, 3)
+	assert.Equal(t, cache.Stats().Size, 2)
+	assert.Equal(t, backend.gets.Load(), int64(3))
+
+	cache.Get(ctx, 1)
+	cache.Get(ctx, 3)
+	assert.Equal(t, backend.gets.Load(), int64(3))
+	cache.Get(ctx, 2)
+	assert.Equal(t, backend.gets.Load(), int64(4))
+}
+
+func TestCachedSnippetModelInvalidation(t *testing.T) {
+	ctx := context.Background()
+	backend := newCountingSnippets()
+	cache := models.NewCachedSnippetModel(backend, 10, time.Hour)
+
+	cache.Latest(ctx)
+	cache.Get(ctx, 1)
+
+	// inserting drops the latest list, but not the snippets
+	if _, err := cache.Insert(ctx, 1, "title", "content", 7, false); err != nil {
+		t.Fatal(err)
+	}
+	cache.Latest(ctx)
+	cache.Get(ctx, 1)
+	assert.Equal(t, backend.latests.Load(), int64(2))
+	assert.Equal(t, backend.gets.Load(), int64(1))
+
+	// Invalidate drops both
+	cache.Invalidate(1)
+	cache.Latest(ctx)
+	cache.Get(ctx, 1)
+	assert.Equal(t, backend.latests.Load(), int64(3))
+	assert.Equal(t, backend.gets.Load(), int64(2))
+
+	// so does Delete
+	if err := cache.Delete(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	cache.Latest(ctx)
+	cache.Get(ctx, 1)
+	assert.Equal(t, backend.latests.Load(), int64(4))
+	assert.Equal(t, backend.gets.Load(), int64(3))
+}
+
+func TestCachedSnippetModelSingleflight(t *testing.T) {
+	backend := newCountingSnippets()
+	backend.release = make(chan struct{})
+	cache := models.NewCachedSnippetModel(backend, 10, time.Hour)
+
+	const callers = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cache.Get(context.Background(), 1)
+			errs <- err
+		}()
+	}
+
+	// wait until every caller has missed (and give them a moment to reach the shared query), then let the single query finish
+	for cache.Stats().Misses < callers {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(backend.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	assert.Equal(t, backend.gets.Load(), int64(1))
+}
+
+func TestCachedSnippetModelDisabled(t *testing.T) {
+	ctx := context.Background()
+	backend := newCountingSnippets()
+	cache := models.NewCachedSnippetModel(backend, 0, time.Hour)
+
+	cache.Get(ctx, 1)
+	cache.Get(ctx, 1)
+	assert.Equal(t, backend.gets.Load(), int64(2))
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// DefaultQueryTimeout is the time budget a single query gets when a model's QueryTimeout is not set.
+const DefaultQueryTimeout = 3 * time.Second
+
+// withTimeout derives a context from ctx which is cancelled after the query timeout budget. If ctx is already cancelled (e.g. the client has disconnected), the query is abandoned straight away.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		timeout = DefaultQueryTimeout
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// dbError maps context deadline errors to ErrQueryTimeout and cancellations to ErrQueryCanceled. Any other error is returned unchanged.
+func dbError(err error) error {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrQueryTimeout
+	case errors.Is(err, context.Canceled):
+		return ErrQueryCanceled
+	}
+	return err
+}
+
+// detachedContext keeps the values of its parent but not its deadline or cancellation.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// detach returns a context with the values of ctx that is never cancelled, for work shared between several requests (see CachedSnippetModel). Queries run with it are still bounded by the query timeout.
+func detach(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
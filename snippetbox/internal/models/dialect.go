@@ -0,0 +1,167 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dialect hides the differences between the SQL databases snippetbox can store its data in, so the same model code runs on MySQL, SQLite and PostgreSQL.
+// Queries in this package are written with ? placeholders and take timestamps as arguments (rather than calling UTC_TIMESTAMP() etc.) so they only need rebinding per dialect.
+type Dialect interface {
+	// Name is the name used to select the dialect, e.g. in the -db-driver flag.
+	Name() string
+	// DriverName is the name the database/sql driver is registered under.
+	DriverName() string
+	// Rebind rewrites a query written with ? placeholders into the dialect's placeholder style.
+	Rebind(query string) string
+	// IsDuplicate reports whether err is a violation of the named unique constraint. Constraints are named <table>_uc_<column>, e.g. users_uc_email.
+	IsDuplicate(err error, constraint string) bool
+	// supportsReturning reports whether INSERT ... RETURNING id can be used to get the new row's id.
+	supportsReturning() bool
+}
+
+var (
+	// MySQL uses github.com/go-sql-driver/mysql. DSNs must include parseTime=true.
+	MySQL Dialect = mysqlDialect{}
+	// SQLite uses the pure-Go modernc.org/sqlite driver, so no cgo is required.
+	SQLite Dialect = sqliteDialect{}
+	// Postgres uses github.com/jackc/pgx/v5 through its database/sql adapter.
+	Postgres Dialect = postgresDialect{}
+)
+
+// DialectFor returns the dialect with the given name (mysql, sqlite or postgres).
+func DialectFor(name string) (Dialect, error) {
+	for _, d := range []Dialect{MySQL, SQLite, Postgres} {
+		if d.Name() == name {
+			return d, nil
+		}
+	}
+	return nil, fmt.Errorf("models: unknown database driver %q (want mysql, sqlite or postgres)", name)
+}
+
+// dialectOrDefault lets models built without a Dialect (e.g. &SnippetModel{Store: Store{DB: db}}) keep working against MySQL.
+func dialectOrDefault(d Dialect) Dialect {
+	if d == nil {
+		return MySQL
+	}
+	return d
+}
+
+// Store is the database a model keeps its data in. Each model embeds one.
+type Store struct {
+	DB *sql.DB
+	// Dialect is the SQL dialect of DB. Nil means MySQL.
+	Dialect Dialect
+	// QueryTimeout is the time budget for each query. Zero means DefaultQueryTimeout.
+	QueryTimeout time.Duration
+}
+
+// rebind converts a query to the placeholder style of the store's dialect.
+func (s Store) rebind(query string) string {
+	return dialectOrDefault(s.Dialect).Rebind(query)
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string               { return "mysql" }
+func (mysqlDialect) DriverName() string         { return "mysql" }
+func (mysqlDialect) Rebind(query string) string { return query }
+func (mysqlDialect) supportsReturning() bool    { return false }
+
+// IsDuplicate checks for error 1062 and the constraint name in the message
+// https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html#error_er_dup_entry
+func (mysqlDialect) IsDuplicate(err error, constraint string) bool {
+	var mySQLError *mysql.MySQLError
+	return errors.As(err, &mySQLError) && mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, constraint)
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string               { return "sqlite" }
+func (sqliteDialect) DriverName() string         { return "sqlite" }
+func (sqliteDialect) Rebind(query string) string { return query }
+func (sqliteDialect) supportsReturning() bool    { return true }
+
+// IsDuplicate checks for SQLITE_CONSTRAINT_UNIQUE. SQLite reports the violated columns rather than the constraint name ("UNIQUE constraint failed: users.email", or "... user_identities.issuer, user_identities.subject" for several), so the table and columns are derived from the constraint naming convention, where several columns are joined with "_".
+func (sqliteDialect) IsDuplicate(err error, constraint string) bool {
+	var sqliteError *sqlite.Error
+	if !errors.As(err, &sqliteError) || sqliteError.Code() != sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+		return false
+	}
+	table, columns, ok := strings.Cut(constraint, "_uc_")
+	if !ok {
+		return false
+	}
+	_, failed, ok := strings.Cut(sqliteError.Error(), "UNIQUE constraint failed: ")
+	if !ok {
+		return false
+	}
+	failed, _, _ = strings.Cut(failed, " (")
+	var failedColumns []string
+	for _, column := range strings.Split(failed, ", ") {
+		column, ok := strings.CutPrefix(column, table+".")
+		if !ok {
+			return false
+		}
+		failedColumns = append(failedColumns, column)
+	}
+	return strings.Join(failedColumns, "_") == columns
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string            { return "postgres" }
+func (postgresDialect) DriverName() string      { return "pgx" }
+func (postgresDialect) supportsReturning() bool { return true }
+
+// Rebind replaces each ? with $1, $2, ... Our queries never contain a literal ?, so no quoting rules are needed.
+func (postgresDialect) Rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// IsDuplicate checks for unique_violation (23505) on the named constraint
+// https://www.postgresql.org/docs/current/errcodes-appendix.html
+func (postgresDialect) IsDuplicate(err error, constraint string) bool {
+	var pgError *pgconn.PgError
+	return errors.As(err, &pgError) && pgError.Code == "23505" && pgError.ConstraintName == constraint
+}
+
+// insert runs an INSERT statement and returns the id of the new row. PostgreSQL has no LastInsertId(), so dialects that support it use RETURNING id instead.
+func insert(ctx context.Context, db *sql.DB, d Dialect, stmt string, args ...any) (int, error) {
+	if d.supportsReturning() {
+		var id int
+		err := db.QueryRowContext(ctx, d.Rebind(stmt+" RETURNING id"), args...).Scan(&id)
+		return id, err
+	}
+
+	result, err := db.ExecContext(ctx, d.Rebind(stmt), args...)
+	if err != nil {
+		return 0, err
+	}
+	// get the ID of newly inserted record
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	// cast int64 to int type
+	return int(id), nil
+}
@@ -7,4 +7,14 @@ var (
 	ErrNoRecord           = errors.New("models: no matching record found")
 	ErrInvalidCredentials = errors.New("models: invalid credentials")
 	ErrDuplicateEmail     = errors.New("models: duplicate email")
+	// ErrAccountDisabled is returned by Authenticate for the right password to an account an admin has disabled.
+	ErrAccountDisabled = errors.New("models: account disabled")
+	// ErrDuplicateReport is returned when a user reports a snippet they already have an open report about.
+	ErrDuplicateReport = errors.New("models: duplicate report")
+	// ErrDuplicateIdentity is returned when linking a single sign-on identity that is already linked to a user.
+	ErrDuplicateIdentity = errors.New("models: duplicate identity")
+	// ErrQueryTimeout is returned when a query runs past its timeout budget, so callers can tell an overloaded database apart from other failures.
+	ErrQueryTimeout = errors.New("models: query timed out")
+	// ErrQueryCanceled is returned when the caller's context is cancelled mid-query, usually because the client went away, so there is nobody left to answer.
+	ErrQueryCanceled = errors.New("models: query canceled")
 )
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Identity links a user to their account at an OpenID Connect provider, which knows them as Subject.
+type Identity struct {
+	ID     int
+	UserID int
+	// Issuer identifies the provider, e.g. "https://login.example.com".
+	Issuer  string
+	Subject string
+	Created time.Time
+}
+
+type IdentityModelInterface interface {
+	Get(ctx context.Context, issuer, subject string) (*Identity, error)
+	Insert(ctx context.Context, userID int, issuer, subject string) error
+}
+
+// IdentityModel stores which users single sign-on logins belong to.
+type IdentityModel struct {
+	Store
+}
+
+// Get returns the identity issuer knows as subject, or ErrNoRecord if it isn't linked to a user yet.
+func (m *IdentityModel) Get(ctx context.Context, issuer, subject string) (*Identity, error) {
+	ctx, cancel := withTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	i := &Identity{}
+	stmt := `SELECT id, user_id, issuer, subject, created FROM user_identities WHERE issuer = ? AND subject = ?`
+	err := m.DB.QueryRowContext(ctx, m.rebind(stmt), issuer, subject).Scan(&i.ID, &i.UserID, &i.Issuer, &i.Subject, &i.Created)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, dbError(err)
+	}
+	return i, nil
+}
+
+// Insert links the identity issuer knows as subject to a user. An identity that is already linked is ErrDuplicateIdentity.
+func (m *IdentityModel) Insert(ctx context.Context, userID int, issuer, subject string) error {
+	ctx, cancel := withTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	stmt := `INSERT INTO user_identities (user_id, issuer, subject, created) VALUES (?, ?, ?, ?)`
+	_, err := m.DB.ExecContext(ctx, m.rebind(stmt), userID, issuer, subject, time.Now().UTC())
+	if err != nil {
+		if dialectOrDefault(m.Dialect).IsDuplicate(err, "user_identities_uc_issuer_subject") {
+			return ErrDuplicateIdentity
+		}
+		return dbError(err)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package models_test
+
+import (
+	"context"
+	"errors"
+	"snippetbox.audryhsu.com/internal/assert"
+	"snippetbox.audryhsu.com/internal/models"
+	"testing"
+)
+
+func TestIdentityModel(t *testing.T) {
+	users := newTestUserModel(t)
+	ctx := context.Background()
+	m := &models.IdentityModel{Store: users.Store}
+
+	_, err := m.Get(ctx, "https://idp.example.com", "alice-123")
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+
+	if err := m.Insert(ctx, 1, "https://idp.example.com", "alice-123"); err != nil {
+		t.Fatal(err)
+	}
+	i, err := m.Get(ctx, "https://idp.example.com", "alice-123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, i.UserID, 1)
+	assert.Equal(t, i.Subject, "alice-123")
+
+	// subjects are only unique within their issuer
+	_, err = m.Get(ctx, "https://other.example.com", "alice-123")
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	if err := m.Insert(ctx, 1, "https://other.example.com", "alice-123"); err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Insert(ctx, 1, "https://idp.example.com", "alice-123")
+	assert.Equal(t, errors.Is(err, models.ErrDuplicateIdentity), true)
+}
@@ -0,0 +1,33 @@
+package mocks
+
+import (
+	"context"
+	"snippetbox.audryhsu.com/internal/models"
+	"time"
+)
+
+// AuditModel keeps the events recorded through it in memory.
+type AuditModel struct {
+	events []*models.AuditEvent
+}
+
+func (m *AuditModel) Record(ctx context.Context, event *models.AuditEvent) error {
+	e := *event
+	e.ID = len(m.events) + 1
+	e.Created = time.Now()
+	m.events = append(m.events, &e)
+	return nil
+}
+
+// List supports the Action, UserID and Before filters and Limit.
+func (m *AuditModel) List(ctx context.Context, filter models.AuditFilter) ([]*models.AuditEvent, error) {
+	var events []*models.AuditEvent
+	for i := len(m.events) - 1; i >= 0 && len(events) < filter.Limit; i-- {
+		e := m.events[i]
+		if filter.Action != "" && e.Action != filter.Action || filter.UserID != 0 && e.UserID != filter.UserID || filter.Before != 0 && e.ID >= filter.Before {
+			continue
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
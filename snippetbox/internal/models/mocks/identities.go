@@ -0,0 +1,30 @@
+package mocks
+
+import (
+	"context"
+	"snippetbox.audryhsu.com/internal/models"
+	"time"
+)
+
+// IdentityModel keeps the identities linked through it in memory.
+type IdentityModel struct {
+	identities []*models.Identity
+}
+
+func (m *IdentityModel) Get(ctx context.Context, issuer, subject string) (*models.Identity, error) {
+	for _, i := range m.identities {
+		if i.Issuer == issuer && i.Subject == subject {
+			identity := *i
+			return &identity, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *IdentityModel) Insert(ctx context.Context, userID int, issuer, subject string) error {
+	if _, err := m.Get(ctx, issuer, subject); err == nil {
+		return models.ErrDuplicateIdentity
+	}
+	m.identities = append(m.identities, &models.Identity{ID: len(m.identities) + 1, UserID: userID, Issuer: issuer, Subject: subject, Created: time.Now()})
+	return nil
+}
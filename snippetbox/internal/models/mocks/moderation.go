@@ -0,0 +1,74 @@
+package mocks
+
+import (
+	"context"
+	"snippetbox.audryhsu.com/internal/models"
+	"time"
+)
+
+// ModerationModel keeps the reports and decisions made through it, so tests can follow a snippet from report to decision. Only the mock snippet (id 1) can be reported and decided on.
+type ModerationModel struct {
+	reports   []*models.Report
+	decisions []*models.Decision
+}
+
+func (m *ModerationModel) Report(ctx context.Context, snippetID, reporterID int, reason models.ReportReason, details string) error {
+	if snippetID != mockSnippet.ID {
+		return models.ErrNoRecord
+	}
+	for _, r := range m.reports {
+		if r.ReporterID == reporterID {
+			return models.ErrDuplicateReport
+		}
+	}
+	m.reports = append(m.reports, &models.Report{
+		ID:         len(m.reports) + 1,
+		SnippetID:  snippetID,
+		ReporterID: reporterID,
+		Reporter:   mockUser.Name,
+		Reason:     reason,
+		Details:    details,
+		Created:    time.Now(),
+	})
+	return nil
+}
+
+func (m *ModerationModel) Queue(ctx context.Context, limit int) ([]*models.QueueEntry, error) {
+	if len(m.reports) == 0 || limit < 1 {
+		return nil, nil
+	}
+	return []*models.QueueEntry{{Snippet: mockSnippet, Reports: m.reports}}, nil
+}
+
+func (m *ModerationModel) QueueLength(ctx context.Context) (int, error) {
+	if len(m.reports) == 0 {
+		return 0, nil
+	}
+	return 1, nil
+}
+
+func (m *ModerationModel) Decide(ctx context.Context, snippetID, moderatorID int, action models.ModerationAction) error {
+	if snippetID != mockSnippet.ID {
+		return models.ErrNoRecord
+	}
+	m.decisions = append(m.decisions, &models.Decision{
+		ID:           len(m.decisions) + 1,
+		SnippetID:    snippetID,
+		SnippetTitle: mockSnippet.Title,
+		ModeratorID:  moderatorID,
+		Moderator:    mockUser.Name,
+		Action:       action,
+		Reports:      len(m.reports),
+		Created:      time.Now(),
+	})
+	m.reports = nil
+	return nil
+}
+
+func (m *ModerationModel) Decisions(ctx context.Context, limit int) ([]*models.Decision, error) {
+	var decisions []*models.Decision
+	for i := len(m.decisions) - 1; i >= 0 && len(decisions) < limit; i-- {
+		decisions = append(decisions, m.decisions[i])
+	}
+	return decisions, nil
+}
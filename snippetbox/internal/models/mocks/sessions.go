@@ -0,0 +1,85 @@
+package mocks
+
+import (
+	"context"
+	"snippetbox.audryhsu.com/internal/models"
+	"time"
+)
+
+// SessionModel keeps the sessions tracked through it in memory, so tests can log in from several clients and revoke their sessions.
+type SessionModel struct {
+	sessions []*models.Session
+	nextID   int
+}
+
+func (m *SessionModel) Insert(ctx context.Context, session *models.Session) (int, error) {
+	m.nextID++
+	s := *session
+	s.ID = m.nextID
+	s.Created = time.Now()
+	s.LastSeen = s.Created
+	m.sessions = append(m.sessions, &s)
+	return s.ID, nil
+}
+
+func (m *SessionModel) Get(ctx context.Context, token string) (*models.Session, error) {
+	for _, s := range m.sessions {
+		if s.Token == token {
+			session := *s
+			return &session, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *SessionModel) Touch(ctx context.Context, id int, ip, userAgent string) error {
+	for _, s := range m.sessions {
+		if s.ID == id {
+			s.LastSeen, s.IP, s.UserAgent = time.Now(), ip, userAgent
+		}
+	}
+	return nil
+}
+
+// List returns the user's sessions, newest first.
+func (m *SessionModel) List(ctx context.Context, userID int) ([]*models.Session, error) {
+	var sessions []*models.Session
+	for i := len(m.sessions) - 1; i >= 0; i-- {
+		if s := *m.sessions[i]; s.UserID == userID {
+			sessions = append(sessions, &s)
+		}
+	}
+	return sessions, nil
+}
+
+func (m *SessionModel) Remove(ctx context.Context, token string) error {
+	m.remove(func(s *models.Session) bool { return s.Token == token })
+	return nil
+}
+
+func (m *SessionModel) Revoke(ctx context.Context, userID, id int) (string, error) {
+	tokens := m.remove(func(s *models.Session) bool { return s.UserID == userID && s.ID == id })
+	if len(tokens) == 0 {
+		return "", models.ErrNoRecord
+	}
+	return tokens[0], nil
+}
+
+func (m *SessionModel) RevokeOthers(ctx context.Context, userID int, token string) ([]string, error) {
+	return m.remove(func(s *models.Session) bool { return s.UserID == userID && s.Token != token }), nil
+}
+
+// remove drops the sessions matching match and returns their tokens.
+func (m *SessionModel) remove(match func(*models.Session) bool) []string {
+	var tokens []string
+	kept := m.sessions[:0]
+	for _, s := range m.sessions {
+		if match(s) {
+			tokens = append(tokens, s.Token)
+		} else {
+			kept = append(kept, s)
+		}
+	}
+	m.sessions = kept
+	return tokens
+}
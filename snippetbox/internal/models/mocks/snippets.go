@@ -1,6 +1,7 @@
 package mocks
 
 import (
+	"context"
 	"snippetbox.audryhsu.com/internal/models"
 	"time"
 )
@@ -13,19 +14,48 @@ var mockSnippet = &models.Snippet{
 	Expires: time.Now(),
 }
 
-type SnippetModel struct{}
+// SnippetModel keeps the last snippet inserted through it as snippet 2, so tests can look at what a handler stored.
+type SnippetModel struct {
+	inserted *models.Snippet
+	// GetErr, if set, is returned by Get, so tests can see how handlers deal with database errors.
+	GetErr error
+}
 
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
+func (m *SnippetModel) Insert(ctx context.Context, userID int, title string, content string, expires int, private bool) (int, error) {
+	m.inserted = &models.Snippet{
+		ID:      2,
+		Title:   title,
+		Content: content,
+		Created: time.Now(),
+		Expires: time.Now().AddDate(0, 0, expires),
+		UserID:  userID,
+		Private: private,
+	}
 	return 2, nil
 }
-func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
-	switch id {
-	case 1:
+func (m *SnippetModel) Get(ctx context.Context, id int) (*models.Snippet, error) {
+	switch {
+	case m.GetErr != nil:
+		return nil, m.GetErr
+	case id == 1:
 		return mockSnippet, nil
+	case id == 2 && m.inserted != nil:
+		return m.inserted, nil
 	default:
 		return nil, models.ErrNoRecord
 	}
 }
-func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
+func (m *SnippetModel) Latest(ctx context.Context) ([]*models.Snippet, error) {
 	return []*models.Snippet{mockSnippet}, nil
 }
+func (m *SnippetModel) Delete(ctx context.Context, id int) error {
+	switch id {
+	case 1:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+func (m *SnippetModel) Counts(ctx context.Context) (*models.SnippetCounts, error) {
+	return &models.SnippetCounts{Total: 1, Active: 1}, nil
+}
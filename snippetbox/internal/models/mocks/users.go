@@ -1,31 +1,176 @@
 package mocks
 
-import "snippetbox.audryhsu.com/internal/models"
+import (
+	"context"
+	"snippetbox.audryhsu.com/internal/models"
+	"strings"
+	"time"
+)
 
+// mockUser is the user with id 1, alice@example.com / pa$$word
+var mockUser = &models.User{
+	ID:      1,
+	Name:    "Alice",
+	Email:   "alice@example.com",
+	Created: time.Now(),
+	Role:    models.RoleUser,
+}
+
+// UserModel holds the mock user's settings, so tests can change them through the model's methods (e.g. SetRole to make Alice an admin).
 type UserModel struct {
+	// locale, role, disabled and password are saved for the mock user by SetLocale, SetRole, SetDisabled, SetAccess and SetPassword; an empty role means models.RoleUser, and an empty password "pa$$word"
+	locale   string
+	role     models.Role
+	disabled bool
+	password string
+	// others are the users signed up through Insert, with ids from 2
+	others []*models.User
 }
 
-func (m *UserModel) Insert(name, email, password string) error {
-	switch email {
-	case "dupe@example.com":
+func (m *UserModel) Insert(ctx context.Context, name, email, password string) error {
+	if email == "dupe@example.com" {
 		return models.ErrDuplicateEmail
-	default:
-		return nil
 	}
+	if _, err := m.GetByEmail(ctx, email); err == nil {
+		return models.ErrDuplicateEmail
+	}
+	m.others = append(m.others, &models.User{ID: len(m.others) + 2, Name: name, Email: email, Created: time.Now(), Role: models.RoleUser})
+	return nil
 }
 
-func (m *UserModel) Authenticate(email, password string) (int, error) {
-	if email == "alice@example.com" && password == "pa$$word" {
+func (m *UserModel) Authenticate(ctx context.Context, email, password string) (int, error) {
+	if email == "alice@example.com" && password == m.currentPassword() {
+		if m.disabled {
+			return 0, models.ErrAccountDisabled
+		}
 		return 1, nil
 	}
 	return 0, models.ErrInvalidCredentials
 }
 
-func (m *UserModel) Exists(id int) (bool, error) {
+func (m *UserModel) Exists(ctx context.Context, id int) (bool, error) {
+	_, err := m.Get(ctx, id)
+	return err == nil, nil
+}
+
+func (m *UserModel) Get(ctx context.Context, id int) (*models.User, error) {
+	if id == 1 {
+		return m.user(), nil
+	}
+	for _, user := range m.others {
+		if user.ID == id {
+			other := *user
+			return &other, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *UserModel) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	if email == "alice@example.com" {
+		return m.user(), nil
+	}
+	for _, user := range m.others {
+		if user.Email == email {
+			other := *user
+			return &other, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *UserModel) List(ctx context.Context, search string, limit int) ([]*models.User, error) {
+	user := m.user()
+	search = strings.ToLower(search)
+	if limit < 1 || !strings.Contains(strings.ToLower(user.Name), search) && !strings.Contains(user.Email, search) {
+		return nil, nil
+	}
+	return []*models.User{user}, nil
+}
+
+func (m *UserModel) Counts(ctx context.Context) (*models.UserCounts, error) {
+	counts := &models.UserCounts{Total: 1}
+	switch m.user().Role {
+	case models.RoleModerator:
+		counts.Moderators = 1
+	case models.RoleAdmin:
+		counts.Admins = 1
+	}
+	if m.disabled {
+		counts.Disabled = 1
+	}
+	return counts, nil
+}
+
+func (m *UserModel) SetLocale(ctx context.Context, id int, locale string) error {
+	switch id {
+	case 1:
+		m.locale = locale
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) SetRole(ctx context.Context, id int, role models.Role) error {
 	switch id {
 	case 1:
-		return true, nil
+		m.role = role
+		return nil
 	default:
-		return false, nil
+		return models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) SetDisabled(ctx context.Context, id int, disabled bool) error {
+	switch id {
+	case 1:
+		m.disabled = disabled
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) SetAccess(ctx context.Context, id int, role models.Role, disabled bool) error {
+	if id == 1 {
+		m.role = role
+		m.disabled = disabled
+		return nil
+	}
+	for _, user := range m.others {
+		if user.ID == id {
+			user.Role = role
+			user.Disabled = disabled
+			return nil
+		}
+	}
+	return models.ErrNoRecord
+}
+
+func (m *UserModel) SetPassword(ctx context.Context, id int, password string) error {
+	if id != 1 {
+		return models.ErrNoRecord
+	}
+	m.password = password
+	return nil
+}
+
+// currentPassword returns the mock user's password.
+func (m *UserModel) currentPassword() string {
+	if m.password == "" {
+		return "pa$$word"
+	}
+	return m.password
+}
+
+// user returns a copy of the mock user with the settings saved so far.
+func (m *UserModel) user() *models.User {
+	user := *mockUser
+	user.Locale = m.locale
+	if m.role != "" {
+		user.Role = m.role
 	}
+	user.Disabled = m.disabled
+	return &user
 }
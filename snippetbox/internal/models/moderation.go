@@ -0,0 +1,253 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// ReportReason is why a user reported a snippet.
+type ReportReason string
+
+const (
+	ReasonSpam   ReportReason = "spam"
+	ReasonAbuse  ReportReason = "abuse"
+	ReasonSecret ReportReason = "secret"
+	ReasonOther  ReportReason = "other"
+)
+
+// ReportReasons lists the reasons a user can pick from, in the order the report form shows them.
+var ReportReasons = []ReportReason{ReasonSpam, ReasonAbuse, ReasonSecret, ReasonOther}
+
+// ModerationAction is a moderator's decision on a snippet: hide it from the public pages, delete it outright, or dismiss its reports and leave it up.
+type ModerationAction string
+
+const (
+	ActionHide    ModerationAction = "hide"
+	ActionDelete  ModerationAction = "delete"
+	ActionDismiss ModerationAction = "dismiss"
+)
+
+// Valid reports whether a is one of the three actions.
+func (a ModerationAction) Valid() bool {
+	return a == ActionHide || a == ActionDelete || a == ActionDismiss
+}
+
+// Report is one user's complaint about a snippet. It stays open until a moderator decides on the snippet.
+type Report struct {
+	ID         int
+	SnippetID  int
+	ReporterID int
+	// Reporter is the reporting user's name.
+	Reporter string
+	Reason   ReportReason
+	Details  string
+	Created  time.Time
+}
+
+// QueueEntry is a snippet in the moderation queue, with its open reports from oldest to newest.
+type QueueEntry struct {
+	Snippet *Snippet
+	Reports []*Report
+}
+
+// Decision is an entry in the moderation audit trail. The snippet's title is copied into it, so the entry still makes sense once the snippet is deleted.
+type Decision struct {
+	ID           int
+	SnippetID    int
+	SnippetTitle string
+	ModeratorID  int
+	// Moderator is the moderator's name, or empty if their account has since been deleted.
+	Moderator string
+	Action    ModerationAction
+	// Reports is how many open reports the decision resolved.
+	Reports int
+	Created time.Time
+}
+
+type ModerationModelInterface interface {
+	Report(ctx context.Context, snippetID, reporterID int, reason ReportReason, details string) error
+	Queue(ctx context.Context, limit int) ([]*QueueEntry, error)
+	QueueLength(ctx context.Context) (int, error)
+	Decide(ctx context.Context, snippetID, moderatorID int, action ModerationAction) error
+	Decisions(ctx context.Context, limit int) ([]*Decision, error)
+}
+
+// ModerationModel stores abuse reports and the moderators' decisions on them.
+type ModerationModel struct {
+	Store
+}
+
+// Report files a report about a snippet. Only snippets on the public pages can be reported, others are ErrNoRecord.
+// A user can't have two open reports about the same snippet: the second is ErrDuplicateReport.
+func (m *ModerationModel) Report(ctx context.Context, snippetID, reporterID int, reason ReportReason, details string) error {
+	ctx, cancel := withTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+	var visible, reported bool
+	stmt := `SELECT
+		EXISTS(SELECT true FROM snippets WHERE id = ? AND expires > ? AND NOT hidden AND NOT private),
+		EXISTS(SELECT true FROM reports WHERE snippet_id = ? AND reporter_id = ? AND resolved IS NULL)`
+	err := m.DB.QueryRowContext(ctx, m.rebind(stmt), snippetID, now, snippetID, reporterID).Scan(&visible, &reported)
+	if err != nil {
+		return dbError(err)
+	}
+	if !visible {
+		return ErrNoRecord
+	}
+	if reported {
+		return ErrDuplicateReport
+	}
+
+	stmt = `INSERT INTO reports (snippet_id, reporter_id, reason, details, created) VALUES (?, ?, ?, ?, ?)`
+	if _, err := m.DB.ExecContext(ctx, m.rebind(stmt), snippetID, reporterID, string(reason), details, now); err != nil {
+		return dbError(err)
+	}
+	return nil
+}
+
+// Queue returns up to limit snippets with open reports, the snippet reported first at the front.
+func (m *ModerationModel) Queue(ctx context.Context, limit int) ([]*QueueEntry, error) {
+	ctx, cancel := withTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	// one row per report; they are grouped by snippet below, which keeps the snippets in the order of their oldest report
+	stmt := `SELECT s.id, s.title, s.content, s.created, s.expires, r.id, r.reporter_id, u.name, r.reason, r.details, r.created
+		FROM reports r
+		JOIN snippets s ON s.id = r.snippet_id
+		JOIN users u ON u.id = r.reporter_id
+		WHERE r.resolved IS NULL
+		ORDER BY r.created, r.id`
+	rows, err := m.DB.QueryContext(ctx, m.rebind(stmt))
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	var queue []*QueueEntry
+	entries := map[int]*QueueEntry{}
+	for rows.Next() {
+		s := &Snippet{}
+		r := &Report{}
+		err := rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &r.ID, &r.ReporterID, &r.Reporter, &r.Reason, &r.Details, &r.Created)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		r.SnippetID = s.ID
+
+		entry, ok := entries[s.ID]
+		if !ok {
+			if len(queue) == limit {
+				continue
+			}
+			entry = &QueueEntry{Snippet: s}
+			entries[s.ID] = entry
+			queue = append(queue, entry)
+		}
+		entry.Reports = append(entry.Reports, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError(err)
+	}
+	return queue, nil
+}
+
+// QueueLength returns the number of snippets with open reports.
+func (m *ModerationModel) QueueLength(ctx context.Context) (int, error) {
+	ctx, cancel := withTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	var n int
+	stmt := `SELECT COUNT(DISTINCT snippet_id) FROM reports WHERE resolved IS NULL`
+	if err := m.DB.QueryRowContext(ctx, m.rebind(stmt)).Scan(&n); err != nil {
+		return 0, dbError(err)
+	}
+	return n, nil
+}
+
+// Decide carries out a moderator's decision on a snippet, resolves its open reports and records the decision in the audit trail, all in one transaction.
+// Snippets can be decided on with or without open reports, but must exist: a missing snippet is ErrNoRecord.
+func (m *ModerationModel) Decide(ctx context.Context, snippetID, moderatorID int, action ModerationAction) error {
+	if !action.Valid() {
+		return errors.New("models: invalid moderation action " + string(action))
+	}
+
+	ctx, cancel := withTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return dbError(err)
+	}
+	// a no-op once the transaction is committed
+	defer tx.Rollback()
+
+	var title string
+	err = tx.QueryRowContext(ctx, m.rebind(`SELECT title FROM snippets WHERE id = ?`), snippetID).Scan(&title)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return dbError(err)
+	}
+
+	now := time.Now().UTC()
+	// resolve the reports before a delete, which takes them with it
+	stmt := `UPDATE reports SET resolved = ?, resolution = ? WHERE snippet_id = ? AND resolved IS NULL`
+	result, err := tx.ExecContext(ctx, m.rebind(stmt), now, string(action), snippetID)
+	if err != nil {
+		return dbError(err)
+	}
+	reports, err := result.RowsAffected()
+	if err != nil {
+		return dbError(err)
+	}
+
+	switch action {
+	case ActionHide:
+		_, err = tx.ExecContext(ctx, m.rebind(`UPDATE snippets SET hidden = ? WHERE id = ?`), true, snippetID)
+	case ActionDelete:
+		_, err = tx.ExecContext(ctx, m.rebind(`DELETE FROM snippets WHERE id = ?`), snippetID)
+	}
+	if err != nil {
+		return dbError(err)
+	}
+
+	stmt = `INSERT INTO moderation_log (snippet_id, snippet_title, moderator_id, action, reports, created) VALUES (?, ?, ?, ?, ?, ?)`
+	if _, err := tx.ExecContext(ctx, m.rebind(stmt), snippetID, title, moderatorID, string(action), reports, now); err != nil {
+		return dbError(err)
+	}
+	return dbError(tx.Commit())
+}
+
+// Decisions returns the latest limit entries of the audit trail, newest first.
+func (m *ModerationModel) Decisions(ctx context.Context, limit int) ([]*Decision, error) {
+	ctx, cancel := withTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	stmt := `SELECT l.id, l.snippet_id, l.snippet_title, l.moderator_id, COALESCE(u.name, ''), l.action, l.reports, l.created
+		FROM moderation_log l
+		LEFT JOIN users u ON u.id = l.moderator_id
+		ORDER BY l.id DESC LIMIT ?`
+	rows, err := m.DB.QueryContext(ctx, m.rebind(stmt), limit)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	var decisions []*Decision
+	for rows.Next() {
+		d := &Decision{}
+		err := rows.Scan(&d.ID, &d.SnippetID, &d.SnippetTitle, &d.ModeratorID, &d.Moderator, &d.Action, &d.Reports, &d.Created)
+		if err != nil {
+			return nil, dbError(err)
+		}
+		decisions = append(decisions, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError(err)
+	}
+	return decisions, nil
+}
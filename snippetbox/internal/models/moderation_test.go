@@ -0,0 +1,205 @@
+package models_test
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"snippetbox.audryhsu.com/internal/assert"
+	"snippetbox.audryhsu.com/internal/models"
+	"snippetbox.audryhsu.com/internal/password"
+	"testing"
+	"time"
+)
+
+// moderationFixture is a fresh database with two users, Alice (id 1) and Bob (id 2), and one live snippet.
+type moderationFixture struct {
+	db         *testDB
+	moderation *models.ModerationModel
+	snippets   *models.SnippetModel
+	snippetID  int
+}
+
+func newModerationFixture(t *testing.T) *moderationFixture {
+	t.Helper()
+	db := newTestDB(t)
+	users := &models.UserModel{Store: db.store(), Hasher: &password.Hasher{Target: password.Bcrypt{Cost: bcrypt.MinCost}}}
+	for _, u := range []struct{ name, email string }{{"Alice", "alice@example.com"}, {"Bob", "bob@example.com"}} {
+		if err := users.Insert(context.Background(), u.name, u.email, "pa$$word"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := time.Now()
+	return &moderationFixture{
+		db:         db,
+		moderation: &models.ModerationModel{Store: db.store()},
+		snippets:   &models.SnippetModel{Store: db.store()},
+		snippetID:  db.insertSnippet(t, "reported", now.Add(-time.Hour), now.Add(24*time.Hour)),
+	}
+}
+
+func TestModerationModelReport(t *testing.T) {
+	f := newModerationFixture(t)
+	now := time.Now()
+	expiredID := f.db.insertSnippet(t, "expired", now.Add(-48*time.Hour), now.Add(-time.Hour))
+
+	tests := []struct {
+		name       string
+		snippetID  int
+		reporterID int
+		wantErr    error
+	}{
+		{name: "first report", snippetID: f.snippetID, reporterID: 1},
+		{name: "same reporter again", snippetID: f.snippetID, reporterID: 1, wantErr: models.ErrDuplicateReport},
+		{name: "another reporter", snippetID: f.snippetID, reporterID: 2},
+		{name: "expired snippet", snippetID: expiredID, reporterID: 1, wantErr: models.ErrNoRecord},
+		{name: "non-existent snippet", snippetID: 9999, reporterID: 1, wantErr: models.ErrNoRecord},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := f.moderation.Report(context.Background(), test.snippetID, test.reporterID, models.ReasonSpam, "buy now")
+			assert.Equal(t, errors.Is(err, test.wantErr), true)
+		})
+	}
+
+	queue, err := f.moderation.Queue(context.Background(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(queue), 1)
+	assert.Equal(t, queue[0].Snippet.ID, f.snippetID)
+	assert.Equal(t, len(queue[0].Reports), 2)
+	assert.Equal(t, queue[0].Reports[0].Reporter, "Alice")
+	assert.Equal(t, queue[0].Reports[1].Reporter, "Bob")
+	assert.Equal(t, queue[0].Reports[1].Reason, models.ReasonSpam)
+	assert.Equal(t, queue[0].Reports[1].Details, "buy now")
+
+	n, err := f.moderation.QueueLength(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, n, 1)
+}
+
+func TestModerationModelQueueLimit(t *testing.T) {
+	f := newModerationFixture(t)
+	ctx := context.Background()
+	now := time.Now()
+	laterID := f.db.insertSnippet(t, "reported later", now, now.Add(time.Hour))
+
+	for _, id := range []int{f.snippetID, laterID} {
+		if err := f.moderation.Report(ctx, id, 1, models.ReasonAbuse, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a newer report doesn't move the snippet up the queue
+	if err := f.moderation.Report(ctx, laterID, 2, models.ReasonAbuse, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	queue, err := f.moderation.Queue(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(queue), 1)
+	assert.Equal(t, queue[0].Snippet.ID, f.snippetID)
+}
+
+func TestModerationModelDecide(t *testing.T) {
+	tests := []struct {
+		name        string
+		action      models.ModerationAction
+		wantGetErr  error
+		wantCounts  models.SnippetCounts
+		wantLatest  int
+		wantReports int
+	}{
+		{name: "hide", action: models.ActionHide, wantGetErr: models.ErrNoRecord, wantCounts: models.SnippetCounts{Total: 1, Hidden: 1}},
+		{name: "delete", action: models.ActionDelete, wantGetErr: models.ErrNoRecord, wantCounts: models.SnippetCounts{}},
+		{name: "dismiss", action: models.ActionDismiss, wantCounts: models.SnippetCounts{Total: 1, Active: 1}, wantLatest: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := newModerationFixture(t)
+			ctx := context.Background()
+			for _, reporterID := range []int{1, 2} {
+				if err := f.moderation.Report(ctx, f.snippetID, reporterID, models.ReasonSecret, ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := f.moderation.Decide(ctx, f.snippetID, 1, test.action); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := f.snippets.Get(ctx, f.snippetID)
+			assert.Equal(t, errors.Is(err, test.wantGetErr), true)
+			latest, err := f.snippets.Latest(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, len(latest), test.wantLatest)
+			counts, err := f.snippets.Counts(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, *counts, test.wantCounts)
+
+			// the reports are resolved, and the decision is in the audit trail even if the snippet is gone
+			queue, err := f.moderation.Queue(ctx, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, len(queue), 0)
+			decisions, err := f.moderation.Decisions(ctx, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, len(decisions), 1)
+			assert.Equal(t, decisions[0].Action, test.action)
+			assert.Equal(t, decisions[0].SnippetID, f.snippetID)
+			assert.Equal(t, decisions[0].SnippetTitle, "reported")
+			assert.Equal(t, decisions[0].Moderator, "Alice")
+			assert.Equal(t, decisions[0].Reports, 2)
+		})
+	}
+}
+
+func TestModerationModelDecideErrors(t *testing.T) {
+	f := newModerationFixture(t)
+	ctx := context.Background()
+
+	err := f.moderation.Decide(ctx, 9999, 1, models.ActionHide)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	err = f.moderation.Decide(ctx, f.snippetID, 1, models.ModerationAction("ban"))
+	assert.Equal(t, err != nil, true)
+
+	// failed decisions leave no trace in the audit trail
+	decisions, err := f.moderation.Decisions(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(decisions), 0)
+}
+
+// TestModerationModelReportAfterDismiss checks that a dismissed report doesn't stop its reporter from reporting the snippet again.
+func TestModerationModelReportAfterDismiss(t *testing.T) {
+	f := newModerationFixture(t)
+	ctx := context.Background()
+
+	if err := f.moderation.Report(ctx, f.snippetID, 2, models.ReasonOther, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.moderation.Decide(ctx, f.snippetID, 1, models.ActionDismiss); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.moderation.Report(ctx, f.snippetID, 2, models.ReasonOther, "still spam"); err != nil {
+		t.Fatal(err)
+	}
+	n, err := f.moderation.QueueLength(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, n, 1)
+}
@@ -0,0 +1,179 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Session is one of a user's logged in sessions, e.g. a browser on their laptop.
+type Session struct {
+	// ID identifies the session on the account page. Unlike Token, it can't be used to take the session over.
+	ID     int
+	UserID int
+	// Token is the session's token in the session store.
+	Token    string
+	Created  time.Time
+	LastSeen time.Time
+	Expires  time.Time
+	// IP and UserAgent are where the session was last seen from.
+	IP        string
+	UserAgent string
+	// Remember is set for "remember me" logins.
+	Remember bool
+}
+
+type SessionModelInterface interface {
+	Insert(ctx context.Context, session *Session) (int, error)
+	Get(ctx context.Context, token string) (*Session, error)
+	Touch(ctx context.Context, id int, ip, userAgent string) error
+	List(ctx context.Context, userID int) ([]*Session, error)
+	Remove(ctx context.Context, token string) error
+	Revoke(ctx context.Context, userID, id int) (string, error)
+	RevokeOthers(ctx context.Context, userID int, token string) ([]string, error)
+}
+
+// SessionModel keeps track of which sessions in the session store belong to which user.
+// It doesn't touch the session store itself: the Revoke methods return the tokens of the revoked sessions, for the caller to delete from the store.
+type SessionModel struct {
+	Store
+}
+
+// Insert starts tracking a session, last seen now, and returns its id. Created and LastSeen are ignored.
+func (m *SessionModel) Insert(ctx context.Context, session *Session) (int, error) {
+	ctx, cancel := withTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+	stmt := `INSERT INTO user_sessions (user_id, token, created, last_seen, expires, ip, user_agent, remember) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	id, err := insert(ctx, m.DB, dialectOrDefault(m.Dialect), stmt, session.UserID, session.Token, now, now, session.Expires.UTC(),
+		truncate(session.IP, 45), truncate(session.UserAgent, 255), session.Remember)
+	if err != nil {
+		return 0, dbError(err)
+	}
+	return id, nil
+}
+
+// Get returns the session with the given token, or ErrNoRecord if it isn't tracked, e.g. because it was revoked.
+func (m *SessionModel) Get(ctx context.Context, token string) (*Session, error) {
+	ctx, cancel := withTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	s := &Session{}
+	stmt := `SELECT id, user_id, token, created, last_seen, expires, ip, user_agent, remember FROM user_sessions WHERE token = ?`
+	err := m.DB.QueryRowContext(ctx, m.rebind(stmt), token).Scan(&s.ID, &s.UserID, &s.Token, &s.Created, &s.LastSeen, &s.Expires, &s.IP, &s.UserAgent, &s.Remember)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, dbError(err)
+	}
+	return s, nil
+}
+
+// Touch records that a session was seen now, from ip with userAgent.
+func (m *SessionModel) Touch(ctx context.Context, id int, ip, userAgent string) error {
+	ctx, cancel := withTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	stmt := `UPDATE user_sessions SET last_seen = ?, ip = ?, user_agent = ? WHERE id = ?`
+	_, err := m.DB.ExecContext(ctx, m.rebind(stmt), time.Now().UTC(), truncate(ip, 45), truncate(userAgent, 255), id)
+	return dbError(err)
+}
+
+// List returns a user's sessions, the most recently seen first. Their expired sessions are cleared out on the way.
+func (m *SessionModel) List(ctx context.Context, userID int) ([]*Session, error) {
+	ctx, cancel := withTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+	if _, err := m.DB.ExecContext(ctx, m.rebind(`DELETE FROM user_sessions WHERE user_id = ? AND expires <= ?`), userID, now); err != nil {
+		return nil, dbError(err)
+	}
+
+	stmt := `SELECT id, user_id, token, created, last_seen, expires, ip, user_agent, remember FROM user_sessions WHERE user_id = ? ORDER BY last_seen DESC, id DESC`
+	rows, err := m.DB.QueryContext(ctx, m.rebind(stmt), userID)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		s := &Session{}
+		if err := rows.Scan(&s.ID, &s.UserID, &s.Token, &s.Created, &s.LastSeen, &s.Expires, &s.IP, &s.UserAgent, &s.Remember); err != nil {
+			return nil, dbError(err)
+		}
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, dbError(err)
+	}
+	return sessions, nil
+}
+
+// Remove stops tracking the session with the given token, e.g. because the user logged out of it. An untracked token is not an error.
+func (m *SessionModel) Remove(ctx context.Context, token string) error {
+	ctx, cancel := withTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, m.rebind(`DELETE FROM user_sessions WHERE token = ?`), token)
+	return dbError(err)
+}
+
+// Revoke stops tracking one of a user's sessions and returns its token. A session that isn't the user's is ErrNoRecord.
+func (m *SessionModel) Revoke(ctx context.Context, userID, id int) (string, error) {
+	tokens, err := m.revoke(ctx, `user_id = ? AND id = ?`, userID, id)
+	if err != nil {
+		return "", err
+	}
+	if len(tokens) == 0 {
+		return "", ErrNoRecord
+	}
+	return tokens[0], nil
+}
+
+// RevokeOthers stops tracking all of a user's sessions except the one with the given token, and returns their tokens.
+func (m *SessionModel) RevokeOthers(ctx context.Context, userID int, token string) ([]string, error) {
+	return m.revoke(ctx, `user_id = ? AND token <> ?`, userID, token)
+}
+
+// revoke deletes the sessions matching where and returns their tokens, in one transaction.
+func (m *SessionModel) revoke(ctx context.Context, where string, args ...any) ([]string, error) {
+	ctx, cancel := withTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	// a no-op once the transaction is committed
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, m.rebind(`SELECT token FROM user_sessions WHERE `+where), args...)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	var tokens []string
+	for rows.Next() {
+		var token string
+		if err := rows.Scan(&token); err != nil {
+			rows.Close()
+			return nil, dbError(err)
+		}
+		tokens = append(tokens, token)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, dbError(err)
+	}
+
+	if _, err := tx.ExecContext(ctx, m.rebind(`DELETE FROM user_sessions WHERE `+where), args...); err != nil {
+		return nil, dbError(err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, dbError(err)
+	}
+	return tokens, nil
+}
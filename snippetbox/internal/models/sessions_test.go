@@ -0,0 +1,98 @@
+package models_test
+
+import (
+	"context"
+	"errors"
+	"snippetbox.audryhsu.com/internal/assert"
+	"snippetbox.audryhsu.com/internal/models"
+	"testing"
+	"time"
+)
+
+func TestSessionModel(t *testing.T) {
+	users := newTestUserModel(t)
+	ctx := context.Background()
+	if err := users.Insert(ctx, "Bob", "bob@example.com", "pa$$word"); err != nil {
+		t.Fatal(err)
+	}
+	m := &models.SessionModel{Store: users.Store}
+
+	expires := time.Now().Add(time.Hour)
+	insert := func(userID int, token string, expires time.Time) int {
+		t.Helper()
+		id, err := m.Insert(ctx, &models.Session{UserID: userID, Token: token, Expires: expires, IP: "192.0.2.1", UserAgent: "Firefox"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	laptop := insert(1, "laptop-token", expires)
+	phone := insert(1, "phone-token", expires)
+	insert(1, "tablet-token", expires)
+	insert(1, "expired-token", time.Now().Add(-time.Minute))
+	bobs, err := m.Insert(ctx, &models.Session{UserID: 2, Token: "bob-token", Expires: expires, IP: "203.0.113.9", UserAgent: "Safari", Remember: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := m.Get(ctx, "laptop-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, s.ID, laptop)
+	assert.Equal(t, s.UserID, 1)
+	assert.Equal(t, s.UserAgent, "Firefox")
+	assert.Equal(t, s.Remember, false)
+	_, err = m.Get(ctx, "unknown-token")
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+
+	// the laptop was seen most recently, from a new address; the expired session is gone
+	time.Sleep(time.Second)
+	if err := m.Touch(ctx, laptop, "198.51.100.7", "Firefox 2"); err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := m.List(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(sessions), 3)
+	assert.Equal(t, sessions[0].ID, laptop)
+	assert.Equal(t, sessions[0].IP, "198.51.100.7")
+	assert.Equal(t, sessions[0].UserAgent, "Firefox 2")
+	_, err = m.Get(ctx, "expired-token")
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+
+	// users can only revoke their own sessions
+	_, err = m.Revoke(ctx, 1, bobs)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	token, err := m.Revoke(ctx, 1, phone)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, token, "phone-token")
+
+	tokens, err := m.RevokeOthers(ctx, 1, "laptop-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(tokens), 1)
+	assert.Equal(t, tokens[0], "tablet-token")
+	_, err = m.Get(ctx, "tablet-token")
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+
+	if err := m.Remove(ctx, "laptop-token"); err != nil {
+		t.Fatal(err)
+	}
+	sessions, err = m.List(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(sessions), 0)
+	// Bob's session is untouched
+	sessions, err = m.List(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(sessions), 1)
+	assert.Equal(t, sessions[0].Remember, true)
+}
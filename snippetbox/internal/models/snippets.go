@@ -1,6 +1,7 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"time"
@@ -13,51 +14,66 @@ type Snippet struct {
 	Content string
 	Created time.Time
 	Expires time.Time
+	// UserID is the author's id, or 0 for snippets created before authors were recorded.
+	UserID int
+	// Private snippets are left out of Latest() and only shown to their author.
+	Private bool
 }
 
 // SnippetModelInterface describes the methods that our SnippetModel struct has; created so that our application can expect a type that implements this interface, including our mock.SnippetModel!
+// Every method takes a context (usually r.Context()) so queries are cancelled when the client goes away.
 type SnippetModelInterface interface {
-	Insert(title, content string, expires int) (int, error)
-	Get(id int) (*Snippet, error)
-	Latest() ([]*Snippet, error)
+	Insert(ctx context.Context, userID int, title, content string, expires int, private bool) (int, error)
+	Get(ctx context.Context, id int) (*Snippet, error)
+	Latest(ctx context.Context) ([]*Snippet, error)
+	Delete(ctx context.Context, id int) error
+	Counts(ctx context.Context) (*SnippetCounts, error)
+}
+
+// SnippetCounts are the site statistics about snippets shown on the admin dashboard. Active snippets haven't expired and haven't been hidden by a moderator.
+type SnippetCounts struct {
+	Total  int
+	Active int
+	Hidden int
 }
 
 // SnippetModel Define a SnippetModel type which wraps a sql.DB connection pool
 type SnippetModel struct {
-	DB *sql.DB
+	Store
 }
 
-// Insert a new snippet into the database
-func (m *SnippetModel) Insert(title string, content string, expires int) (int, error) {
-	// SQL statement to execute
-	stmt := `INSERT INTO snippets (title, content, created, expires) VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+// Insert a new snippet by user userID into the database
+func (m *SnippetModel) Insert(ctx context.Context, userID int, title string, content string, expires int, private bool) (int, error) {
+	ctx, cancel := withTimeout(ctx, m.QueryTimeout)
+	defer cancel()
 
-	// Exec() method on connection pool to execute and return some basic info about what happened when statement was executed.
-	result, err := m.DB.Exec(stmt, title, content, expires)
-	if err != nil {
-		return 0, err
-	}
+	// SQL statement to execute. Timestamps are computed here rather than with UTC_TIMESTAMP()/DATE_ADD so the statement works in every dialect.
+	stmt := `INSERT INTO snippets (title, content, created, expires, user_id, private) VALUES(?, ?, ?, ?, ?, ?)`
+	created := time.Now().UTC()
 
-	// get the ID of newly inserted record
-	id, err := result.LastInsertId()
+	// insert() executes the statement and returns the id of the new record
+	id, err := insert(ctx, m.DB, dialectOrDefault(m.Dialect), stmt, title, content, created, created.AddDate(0, 0, expires), userID, private)
 	if err != nil {
-		return 0, err
+		return 0, dbError(err)
 	}
-	// cast int64 to int type
-	return int(id), nil
+	return id, nil
 }
 
-// Get Return a specific snippet based on id
-func (m *SnippetModel) Get(id int) (*Snippet, error) {
-	stmt := `SELECT id, title, content, created, expires FROM snippets WHERE id = ? AND expires > UTC_TIMESTAMP()`
+// Get Return a specific snippet based on id. Expired and hidden snippets are ErrNoRecord; private ones are returned, and it's up to the caller to only show them to their author.
+func (m *SnippetModel) Get(ctx context.Context, id int) (*Snippet, error) {
+	ctx, cancel := withTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	// user_id is NULL for old snippets, which scans into an int as 0
+	stmt := `SELECT id, title, content, created, expires, COALESCE(user_id, 0), private FROM snippets WHERE id = ? AND expires > ? AND NOT hidden`
 	s := &Snippet{} // initialize a pointer to a new zeroed Snippet struct
 
-	// use QueryRow method on connection pool to execute SQL statement. Returns a pointer to a sql.Row object which holds the result from db.
-	row := m.DB.QueryRow(stmt, id)
+	// use QueryRowContext method on connection pool to execute SQL statement. Returns a pointer to a sql.Row object which holds the result from db.
+	row := m.DB.QueryRowContext(ctx, m.rebind(stmt), id, time.Now().UTC())
 
 	// row.Scan() copies query results into our zeroed Snippet instance, which should be POINTERS.
 	// number of args must be exactly same as num of cols returned by SQL statement.
-	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.UserID, &s.Private)
 	if err != nil {
 		// if query returns no rows, row.Scan() returns a sql.ErrNoRows error.
 		// use errors.Is() to check for specific error. If row not found, we return our own ErrNoRecord
@@ -65,19 +81,22 @@ func (m *SnippetModel) Get(id int) (*Snippet, error) {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, ErrNoRecord
 		} else {
-			return nil, err
+			return nil, dbError(err)
 		}
 	}
 	return s, nil
 }
 
-// Latest Return 10 most recently created snippets
-func (m *SnippetModel) Latest() ([]*Snippet, error) {
-	stmt := `SELECT id, title, content, created, expires FROM snippets WHERE expires > UTC_TIMESTAMP() ORDER BY id DESC LIMIT 10`
-	// Query() on the connection pool to exec. SQL statement. Returns sql.Rows resultset.
-	rows, err := m.DB.Query(stmt)
+// Latest Return 10 most recently created public snippets
+func (m *SnippetModel) Latest(ctx context.Context) ([]*Snippet, error) {
+	ctx, cancel := withTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	stmt := `SELECT id, title, content, created, expires, COALESCE(user_id, 0), private FROM snippets WHERE expires > ? AND NOT hidden AND NOT private ORDER BY id DESC LIMIT 10`
+	// QueryContext() on the connection pool to exec. SQL statement. Returns sql.Rows resultset.
+	rows, err := m.DB.QueryContext(ctx, m.rebind(stmt), time.Now().UTC())
 	if err != nil {
-		return nil, err
+		return nil, dbError(err)
 	}
 	// if resultset is open, then underlying db conn remains open... don't use up all our conns!
 	defer rows.Close()
@@ -89,15 +108,50 @@ func (m *SnippetModel) Latest() ([]*Snippet, error) {
 	for rows.Next() {
 		s := &Snippet{}
 		// rows.Scan() copies values from each field in row to new Snippet object.
-		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires, &s.UserID, &s.Private)
 		if err != nil {
-			return nil, err
+			return nil, dbError(err)
 		}
 		snippets = append(snippets, s)
 	}
 	// call rows.Err() to retrieve any error encountered during iteration. Important! Don't assume successful iteration over entire rulseset.
 	if err = rows.Err(); err != nil {
-		return nil, err
+		return nil, dbError(err)
 	}
 	return snippets, nil
 }
+
+// Delete removes a snippet, expired or not. It returns ErrNoRecord if there is no such snippet.
+func (m *SnippetModel) Delete(ctx context.Context, id int) error {
+	ctx, cancel := withTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	stmt := `DELETE FROM snippets WHERE id = ?`
+	result, err := m.DB.ExecContext(ctx, m.rebind(stmt), id)
+	if err != nil {
+		return dbError(err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return dbError(err)
+	} else if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// Counts returns the number of snippets stored, how many of them are still shown and how many moderators have hidden.
+func (m *SnippetModel) Counts(ctx context.Context) (*SnippetCounts, error) {
+	ctx, cancel := withTimeout(ctx, m.QueryTimeout)
+	defer cancel()
+
+	stmt := `SELECT COUNT(*),
+		COALESCE(SUM(CASE WHEN expires > ? AND NOT hidden THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN hidden THEN 1 ELSE 0 END), 0)
+		FROM snippets`
+	counts := &SnippetCounts{}
+	err := m.DB.QueryRowContext(ctx, m.rebind(stmt), time.Now().UTC()).Scan(&counts.Total, &counts.Active, &counts.Hidden)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	return counts, nil
+}
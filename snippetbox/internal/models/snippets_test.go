@@ -0,0 +1,232 @@
+package models_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"snippetbox.audryhsu.com/internal/assert"
+	"snippetbox.audryhsu.com/internal/models"
+	"testing"
+	"time"
+)
+
+func TestSnippetModelInsert(t *testing.T) {
+	db := newTestDB(t)
+	m := &models.SnippetModel{Store: db.store()}
+
+	tests := []struct {
+		name    string
+		title   string
+		content string
+		expires int
+		userID  int
+		private bool
+	}{
+		{name: "one day", title: "An old silent pond", content: "An old silent pond...", expires: 1, userID: 1},
+		{name: "one year", title: "Over the wintry forest", content: "Over the wintry\nforest, winds howl in rage", expires: 365, userID: 2},
+		{name: "private", title: "First autumn morning", content: "First autumn morning", expires: 7, userID: 1, private: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := time.Now().UTC().Add(-time.Second)
+			id, err := m.Insert(context.Background(), test.userID, test.title, test.content, test.expires, test.private)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id < 1 {
+				t.Fatalf("got id %d, expected a positive id", id)
+			}
+
+			s, err := m.Get(context.Background(), id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, s.ID, id)
+			assert.Equal(t, s.Title, test.title)
+			assert.Equal(t, s.Content, test.content)
+			assert.Equal(t, s.UserID, test.userID)
+			assert.Equal(t, s.Private, test.private)
+			assert.Equal(t, s.Created.After(before), true)
+			// expiry is a whole number of days after creation
+			assert.Equal(t, s.Expires.Sub(s.Created).Round(time.Hour), time.Duration(test.expires)*24*time.Hour)
+		})
+	}
+}
+
+func TestSnippetModelGet(t *testing.T) {
+	db := newTestDB(t)
+	m := &models.SnippetModel{Store: db.store()}
+
+	now := time.Now()
+	liveID := db.insertSnippet(t, "live", now.Add(-time.Hour), now.Add(24*time.Hour))
+	expiredID := db.insertSnippet(t, "expired", now.Add(-48*time.Hour), now.Add(-time.Hour))
+	hiddenID := db.insertSnippet(t, "hidden", now.Add(-time.Hour), now.Add(24*time.Hour))
+	db.exec(t, `UPDATE snippets SET hidden = ? WHERE id = ?`, true, hiddenID)
+
+	tests := []struct {
+		name      string
+		id        int
+		wantTitle string
+		wantErr   error
+	}{
+		{name: "valid id", id: liveID, wantTitle: "live"},
+		{name: "expired", id: expiredID, wantErr: models.ErrNoRecord},
+		{name: "hidden by a moderator", id: hiddenID, wantErr: models.ErrNoRecord},
+		{name: "non-existent id", id: 9999, wantErr: models.ErrNoRecord},
+		{name: "zero id", id: 0, wantErr: models.ErrNoRecord},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := m.Get(context.Background(), test.id)
+			assert.Equal(t, errors.Is(err, test.wantErr), true)
+			if test.wantErr == nil {
+				assert.Equal(t, s.Title, test.wantTitle)
+			}
+		})
+	}
+}
+
+func TestSnippetModelLatest(t *testing.T) {
+	tests := []struct {
+		name       string
+		live       int
+		expired    int
+		wantTitles int
+	}{
+		{name: "empty", wantTitles: 0},
+		{name: "expired are hidden", live: 2, expired: 3, wantTitles: 2},
+		{name: "limited to ten", live: 12, expired: 1, wantTitles: 10},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := newTestDB(t)
+			m := &models.SnippetModel{Store: db.store()}
+
+			now := time.Now()
+			for i := 0; i < test.expired; i++ {
+				db.insertSnippet(t, fmt.Sprintf("expired %d", i), now.Add(-48*time.Hour), now.Add(-time.Hour))
+			}
+			for i := 0; i < test.live; i++ {
+				db.insertSnippet(t, fmt.Sprintf("live %d", i), now, now.Add(time.Hour))
+			}
+
+			snippets, err := m.Latest(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, len(snippets), test.wantTitles)
+			for i, s := range snippets {
+				// newest first, and nothing expired
+				assert.Equal(t, s.Expires.After(now), true)
+				if i > 0 {
+					assert.Equal(t, s.ID < snippets[i-1].ID, true)
+				}
+			}
+		})
+	}
+}
+
+// TestSnippetModelLatestPrivate checks that private snippets are left out of Latest() but can still be looked up by their author.
+func TestSnippetModelLatestPrivate(t *testing.T) {
+	db := newTestDB(t)
+	m := &models.SnippetModel{Store: db.store()}
+	ctx := context.Background()
+
+	publicID, err := m.Insert(ctx, 1, "public", "public content", 7, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateID, err := m.Insert(ctx, 1, "private", "private content", 7, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snippets, err := m.Latest(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(snippets), 1)
+	assert.Equal(t, snippets[0].ID, publicID)
+
+	s, err := m.Get(ctx, privateID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, s.Private, true)
+}
+
+func TestSnippetModelQueryTimeout(t *testing.T) {
+	db := newTestDB(t)
+	m := &models.SnippetModel{Store: db.store()}
+
+	// a context that is already past its deadline makes every query fail with the timeout error
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	_, err := m.Latest(ctx)
+	assert.Equal(t, errors.Is(err, models.ErrQueryTimeout), true)
+	_, err = m.Get(ctx, 1)
+	assert.Equal(t, errors.Is(err, models.ErrQueryTimeout), true)
+	_, err = m.Insert(ctx, 1, "title", "content", 1, false)
+	assert.Equal(t, errors.Is(err, models.ErrQueryTimeout), true)
+
+	// a cancelled context, e.g. of a client that went away, is told apart from a timeout
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = m.Latest(ctx)
+	assert.Equal(t, errors.Is(err, models.ErrQueryCanceled), true)
+	_, err = m.Get(ctx, 1)
+	assert.Equal(t, errors.Is(err, models.ErrQueryCanceled), true)
+}
+
+func TestSnippetModelDelete(t *testing.T) {
+	db := newTestDB(t)
+	m := &models.SnippetModel{Store: db.store()}
+
+	now := time.Now()
+	liveID := db.insertSnippet(t, "live", now.Add(-time.Hour), now.Add(24*time.Hour))
+	expiredID := db.insertSnippet(t, "expired", now.Add(-48*time.Hour), now.Add(-time.Hour))
+
+	tests := []struct {
+		name    string
+		id      int
+		wantErr error
+	}{
+		{name: "live", id: liveID},
+		{name: "expired", id: expiredID},
+		{name: "already deleted", id: liveID, wantErr: models.ErrNoRecord},
+		{name: "non-existent id", id: 9999, wantErr: models.ErrNoRecord},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := m.Delete(context.Background(), test.id)
+			assert.Equal(t, errors.Is(err, test.wantErr), true)
+		})
+	}
+}
+
+func TestSnippetModelCounts(t *testing.T) {
+	db := newTestDB(t)
+	m := &models.SnippetModel{Store: db.store()}
+
+	counts, err := m.Counts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, *counts, models.SnippetCounts{})
+
+	now := time.Now()
+	db.insertSnippet(t, "live", now.Add(-time.Hour), now.Add(24*time.Hour))
+	db.insertSnippet(t, "expired", now.Add(-48*time.Hour), now.Add(-time.Hour))
+	hiddenID := db.insertSnippet(t, "hidden", now.Add(-time.Hour), now.Add(24*time.Hour))
+	db.exec(t, `UPDATE snippets SET hidden = ? WHERE id = ?`, true, hiddenID)
+	counts, err = m.Counts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, *counts, models.SnippetCounts{Total: 3, Active: 1, Hidden: 1})
+}
@@ -1,96 +1,340 @@
 package models
 
 import (
+	"context"
 	"database/sql"
 	"errors"
-	"github.com/go-sql-driver/mysql"
-	"golang.org/x/crypto/bcrypt"
+	"fmt"
 	"log"
+	"snippetbox.audryhsu.com/internal/password"
 	"strings"
 	"time"
 )
 
+// Role decides what a user may do on the site. Roles are ordered: moderators can do everything users can, and admins everything moderators can.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+// Roles lists the roles from least to most privileged.
+var Roles = []Role{RoleUser, RoleModerator, RoleAdmin}
+
+// Valid reports whether r is one of Roles.
+func (r Role) Valid() bool {
+	return r.rank() > 0
+}
+
+// AtLeast reports whether r grants everything min does. Unknown roles grant nothing.
+func (r Role) AtLeast(min Role) bool {
+	return r.Valid() && r.rank() >= min.rank()
+}
+
+// rank is r's position in Roles, counting from 1; 0 means r is unknown.
+func (r Role) rank() int {
+	for i, role := range Roles {
+		if role == r {
+			return i + 1
+		}
+	}
+	return 0
+}
+
 type User struct {
 	ID             int
 	Name           string
 	Email          string
 	HashedPassword []byte
 	Created        time.Time
+	// Locale is the language the user chose for the site, e.g. "es". Empty means it is negotiated from the browser's Accept-Language header.
+	Locale string
+	Role   Role
+	// Disabled accounts can't log in, and sessions they already have stop being authenticated.
+	Disabled bool
 }
+
+// UserCounts are the site statistics about users shown on the admin dashboard.
+type UserCounts struct {
+	Total      int
+	Moderators int
+	Admins     int
+	Disabled   int
+}
+
 type UserModelInterface interface {
-	Insert(name, email, password string) error
-	Authenticate(email, password string) (int, error)
-	Exists(id int) (bool, error)
+	Insert(ctx context.Context, name, email, password string) error
+	Authenticate(ctx context.Context, email, password string) (int, error)
+	Exists(ctx context.Context, id int) (bool, error)
+	Get(ctx context.Context, id int) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	List(ctx context.Context, search string, limit int) ([]*User, error)
+	Counts(ctx context.Context) (*UserCounts, error)
+	SetLocale(ctx context.Context, id int, locale string) error
+	SetRole(ctx context.Context, id int, role Role) error
+	SetDisabled(ctx context.Context, id int, disabled bool) error
+	SetAccess(ctx context.Context, id int, role Role, disabled bool) error
+	SetPassword(ctx context.Context, id int, password string) error
 }
 
 // UserModel wraps a sql.DB connection pool
 type UserModel struct {
-	DB *sql.DB
+	Store
+	// Hasher hashes new passwords, and outdated ones on login. Nil means bcrypt at password.DefaultBcryptCost.
+	Hasher *password.Hasher
+	// ErrorLog gets errors that don't fail the call they happen in, such as a failed rehash on login. Nil means the standard logger.
+	ErrorLog *log.Logger
 }
 
 // Insert adds a new record to Users table
-func (u *UserModel) Insert(name, email, password string) error {
-	stmt := `INSERT INTO users (name, email, hashed_password, created) VALUES(?, ?, ?, UTC_TIMESTAMP())`
+func (u *UserModel) Insert(ctx context.Context, name, email, password string) error {
+	stmt := `INSERT INTO users (name, email, hashed_password, created) VALUES(?, ?, ?, ?)`
 
 	// store hashed password
-	hashedPW, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	hashedPW, err := u.Hasher.Hash(password)
 	if err != nil {
 		return err
 	}
 
-	result, err := u.DB.Exec(stmt, name, email, string(hashedPW))
-	if err != nil {
-		// check if error is mysql error, if so, check if matches duplicate error code
-		// https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html#error_er_dup_entry
-		var mySQLError *mysql.MySQLError
-		if errors.As(err, &mySQLError) {
-			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
-				return ErrDuplicateEmail
-			}
-		}
-		return err
-	}
+	// hash before starting the timeout budget, password hashing is deliberately slow
+	ctx, cancel := withTimeout(ctx, u.QueryTimeout)
+	defer cancel()
 
-	// get the ID of newly inserted record
-	_, err = result.LastInsertId()
+	_, err = u.DB.ExecContext(ctx, u.rebind(stmt), name, email, hashedPW, time.Now().UTC())
 	if err != nil {
-		return err
+		// check if error is the database's duplicate key error for the unique email constraint
+		if dialectOrDefault(u.Dialect).IsDuplicate(err, "users_uc_email") {
+			return ErrDuplicateEmail
+		}
+		return dbError(err)
 	}
 	return nil
 }
 
 // Authenticate verifies whether user with email and password exists. Returns userID if valid.
-func (u *UserModel) Authenticate(email, password string) (int, error) {
-	var hashedPassword []byte
+// Disabled accounts get ErrAccountDisabled, but only once the password is right, so the error doesn't reveal which emails have accounts.
+// A password hashed with an outdated algorithm or parameters is rehashed with the Hasher's current ones.
+func (u *UserModel) Authenticate(ctx context.Context, email, password string) (int, error) {
+	var hashedPassword string
 	var id int
+	var disabled bool
+
+	queryCtx, cancel := withTimeout(ctx, u.QueryTimeout)
+	defer cancel()
 
 	// if email doesn't exist in db, return error
-	stmt := `SELECT id, hashed_password FROM users WHERE email = ?`
-	row := u.DB.QueryRow(stmt, email)
-	err := row.Scan(&id, &hashedPassword)
+	stmt := `SELECT id, hashed_password, disabled FROM users WHERE email = ?`
+	row := u.DB.QueryRowContext(queryCtx, u.rebind(stmt), email)
+	err := row.Scan(&id, &hashedPassword, &disabled)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			log.Println("couldn't find email in db")
 			return 0, ErrInvalidCredentials
 		}
-		return 0, err
+		return 0, dbError(err)
 	}
 
 	// if plaintext pw doesn't match hashed pw, return error
-	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	rehash, err := u.Hasher.Verify(hashedPassword, password)
 	if err != nil {
 		log.Println("badd password")
 		return 0, ErrInvalidCredentials
 	}
+	if disabled {
+		return 0, ErrAccountDisabled
+	}
+
+	if rehash {
+		// the old hash still works, so a failure is only logged and the rehash tried again next login
+		if err := u.rehash(ctx, id, hashedPassword, password); err != nil {
+			u.errorLog().Printf("models: rehashing password of user %d: %s", id, err)
+		}
+	}
 
 	log.Println("auth successful!")
 	return id, nil
 }
 
+// errorLog returns ErrorLog, falling back to the standard logger.
+func (u *UserModel) errorLog() *log.Logger {
+	if u.ErrorLog == nil {
+		return log.Default()
+	}
+	return u.ErrorLog
+}
+
 // Exists checks whether a user exists.
-func (u *UserModel) Exists(id int) (bool, error) {
+func (u *UserModel) Exists(ctx context.Context, id int) (bool, error) {
+	ctx, cancel := withTimeout(ctx, u.QueryTimeout)
+	defer cancel()
+
 	stmt := "SELECT EXISTS(SELECT true FROM users WHERE id = ?)"
 	var exists bool
-	err := u.DB.QueryRow(stmt, id).Scan(&exists)
-	return exists, err
+	err := u.DB.QueryRowContext(ctx, u.rebind(stmt), id).Scan(&exists)
+	return exists, dbError(err)
+}
+
+// userColumns are the columns scanned by scanUser. The hashed password is left out.
+const userColumns = `id, name, email, created, locale, role, disabled`
+
+// scanUser copies a row of userColumns into a new User.
+func scanUser(row interface{ Scan(dest ...any) error }) (*User, error) {
+	user := &User{}
+	err := row.Scan(&user.ID, &user.Name, &user.Email, &user.Created, &user.Locale, &user.Role, &user.Disabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		}
+		return nil, dbError(err)
+	}
+	return user, nil
+}
+
+// Get returns the user with the given id. The hashed password is left out.
+func (u *UserModel) Get(ctx context.Context, id int) (*User, error) {
+	ctx, cancel := withTimeout(ctx, u.QueryTimeout)
+	defer cancel()
+
+	stmt := `SELECT ` + userColumns + ` FROM users WHERE id = ?`
+	return scanUser(u.DB.QueryRowContext(ctx, u.rebind(stmt), id))
+}
+
+// GetByEmail returns the user with the given email address. The hashed password is left out.
+func (u *UserModel) GetByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, cancel := withTimeout(ctx, u.QueryTimeout)
+	defer cancel()
+
+	stmt := `SELECT ` + userColumns + ` FROM users WHERE email = ?`
+	return scanUser(u.DB.QueryRowContext(ctx, u.rebind(stmt), email))
+}
+
+// List returns up to limit users, oldest first, whose name or email contains search (ignoring case). An empty search lists every user.
+func (u *UserModel) List(ctx context.Context, search string, limit int) ([]*User, error) {
+	ctx, cancel := withTimeout(ctx, u.QueryTimeout)
+	defer cancel()
+
+	// "!" escapes LIKE wildcards in the search; backslash, the usual choice, is itself an escape character in MySQL string literals
+	pattern := "%" + likeEscaper.Replace(strings.ToLower(search)) + "%"
+	stmt := `SELECT ` + userColumns + ` FROM users WHERE LOWER(name) LIKE ? ESCAPE '!' OR LOWER(email) LIKE ? ESCAPE '!' ORDER BY id LIMIT ?`
+	rows, err := u.DB.QueryContext(ctx, u.rebind(stmt), pattern, pattern, limit)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, dbError(err)
+	}
+	return users, nil
+}
+
+// likeEscaper escapes the LIKE wildcards in a search term, using "!" as the escape character.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
+// Counts returns the number of users in total, with each elevated role, and disabled.
+func (u *UserModel) Counts(ctx context.Context) (*UserCounts, error) {
+	ctx, cancel := withTimeout(ctx, u.QueryTimeout)
+	defer cancel()
+
+	stmt := `SELECT COUNT(*),
+		COALESCE(SUM(CASE WHEN role = ? THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN role = ? THEN 1 ELSE 0 END), 0),
+		COALESCE(SUM(CASE WHEN disabled THEN 1 ELSE 0 END), 0)
+		FROM users`
+	counts := &UserCounts{}
+	err := u.DB.QueryRowContext(ctx, u.rebind(stmt), RoleModerator, RoleAdmin).Scan(&counts.Total, &counts.Moderators, &counts.Admins, &counts.Disabled)
+	if err != nil {
+		return nil, dbError(err)
+	}
+	return counts, nil
+}
+
+// SetLocale saves the user's language preference. An empty locale goes back to negotiating it from the browser.
+func (u *UserModel) SetLocale(ctx context.Context, id int, locale string) error {
+	ctx, cancel := withTimeout(ctx, u.QueryTimeout)
+	defer cancel()
+
+	stmt := `UPDATE users SET locale = ? WHERE id = ?`
+	_, err := u.DB.ExecContext(ctx, u.rebind(stmt), locale, id)
+	return dbError(err)
+}
+
+// SetRole changes the user's role. It returns ErrNoRecord if there is no such user.
+func (u *UserModel) SetRole(ctx context.Context, id int, role Role) error {
+	if !role.Valid() {
+		return fmt.Errorf("models: unknown role %q", role)
+	}
+	return u.update(ctx, id, `UPDATE users SET role = ? WHERE id = ?`, role)
+}
+
+// SetDisabled disables or re-enables the user's account. It returns ErrNoRecord if there is no such user.
+func (u *UserModel) SetDisabled(ctx context.Context, id int, disabled bool) error {
+	return u.update(ctx, id, `UPDATE users SET disabled = ? WHERE id = ?`, disabled)
+}
+
+// SetAccess changes the user's role and disables or re-enables their account in one statement, so either both change or neither does. It returns ErrNoRecord if there is no such user.
+func (u *UserModel) SetAccess(ctx context.Context, id int, role Role, disabled bool) error {
+	if !role.Valid() {
+		return fmt.Errorf("models: unknown role %q", role)
+	}
+	return u.update(ctx, id, `UPDATE users SET role = ?, disabled = ? WHERE id = ?`, role, disabled)
+}
+
+// SetPassword replaces a user's password. Callers check that it is really the user asking first, e.g. by having them re-enter their current password. A missing user is ErrNoRecord.
+func (u *UserModel) SetPassword(ctx context.Context, id int, password string) error {
+	// hash before starting the timeout budget, as in Insert
+	hashedPW, err := u.Hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+	return u.update(ctx, id, `UPDATE users SET hashed_password = ? WHERE id = ?`, hashedPW)
+}
+
+// update runs an UPDATE of the user with the given id (the statement's last placeholder, after values), returning ErrNoRecord if there is no such user.
+// MySQL counts only the rows it actually changed, so a missing row is told apart from an unchanged one with Exists.
+func (u *UserModel) update(ctx context.Context, id int, stmt string, values ...any) error {
+	queryCtx, cancel := withTimeout(ctx, u.QueryTimeout)
+	defer cancel()
+
+	result, err := u.DB.ExecContext(queryCtx, u.rebind(stmt), append(values, id)...)
+	if err != nil {
+		return dbError(err)
+	}
+	if n, err := result.RowsAffected(); err == nil && n > 0 {
+		return nil
+	}
+	exists, err := u.Exists(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// rehash replaces a user's outdated password hash with a new one. It does nothing if the hash changed in the meantime, so a password changed at the same time isn't undone.
+func (u *UserModel) rehash(ctx context.Context, id int, oldHash, password string) error {
+	// hash before starting the timeout budget, as in Insert
+	hashedPW, err := u.Hasher.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := withTimeout(ctx, u.QueryTimeout)
+	defer cancel()
+
+	stmt := `UPDATE users SET hashed_password = ? WHERE id = ? AND hashed_password = ?`
+	_, err = u.DB.ExecContext(ctx, u.rebind(stmt), hashedPW, id, oldHash)
+	return dbError(err)
 }
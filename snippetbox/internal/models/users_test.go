@@ -0,0 +1,391 @@
+package models_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/bcrypt"
+	"snippetbox.audryhsu.com/internal/assert"
+	"snippetbox.audryhsu.com/internal/models"
+	"snippetbox.audryhsu.com/internal/password"
+	"strings"
+	"testing"
+)
+
+// newTestUserModel returns a UserModel with one user, alice@example.com / pa$$word. It uses the minimum bcrypt cost to keep the tests fast.
+func newTestUserModel(t *testing.T) *models.UserModel {
+	db := newTestDB(t)
+	m := &models.UserModel{Store: db.store(), Hasher: &password.Hasher{Target: password.Bcrypt{Cost: bcrypt.MinCost}}}
+	if err := m.Insert(context.Background(), "Alice", "alice@example.com", "pa$$word"); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestUserModelInsert(t *testing.T) {
+	m := newTestUserModel(t)
+
+	tests := []struct {
+		name    string
+		email   string
+		wantErr error
+	}{
+		{name: "new email", email: "bob@example.com"},
+		{name: "duplicate email", email: "alice@example.com", wantErr: models.ErrDuplicateEmail},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := m.Insert(context.Background(), "Bob", test.email, "validPa$$word")
+			if test.wantErr == nil && err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, errors.Is(err, test.wantErr), true)
+		})
+	}
+}
+
+func TestUserModelAuthenticate(t *testing.T) {
+	m := newTestUserModel(t)
+
+	tests := []struct {
+		name     string
+		email    string
+		password string
+		wantID   int
+		wantErr  error
+	}{
+		{name: "valid credentials", email: "alice@example.com", password: "pa$$word", wantID: 1},
+		{name: "wrong password", email: "alice@example.com", password: "password", wantErr: models.ErrInvalidCredentials},
+		{name: "unknown email", email: "nobody@example.com", password: "pa$$word", wantErr: models.ErrInvalidCredentials},
+		{name: "blank", wantErr: models.ErrInvalidCredentials},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			id, err := m.Authenticate(context.Background(), test.email, test.password)
+			assert.Equal(t, errors.Is(err, test.wantErr), true)
+			assert.Equal(t, id, test.wantID)
+		})
+	}
+}
+
+func TestUserModelExists(t *testing.T) {
+	m := newTestUserModel(t)
+
+	tests := []struct {
+		name   string
+		userID int
+		want   bool
+	}{
+		{name: "valid id", userID: 1, want: true},
+		{name: "zero id", userID: 0, want: false},
+		{name: "non-existent id", userID: 2, want: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exists, err := m.Exists(context.Background(), test.userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, exists, test.want)
+		})
+	}
+}
+
+func TestUserModelGet(t *testing.T) {
+	m := newTestUserModel(t)
+
+	tests := []struct {
+		name      string
+		userID    int
+		wantEmail string
+		wantErr   error
+	}{
+		{name: "valid id", userID: 1, wantEmail: "alice@example.com"},
+		{name: "non-existent id", userID: 2, wantErr: models.ErrNoRecord},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			user, err := m.Get(context.Background(), test.userID)
+			assert.Equal(t, errors.Is(err, test.wantErr), true)
+			if test.wantErr == nil {
+				assert.Equal(t, user.Email, test.wantEmail)
+				assert.Equal(t, user.Locale, "")
+			}
+		})
+	}
+}
+
+func TestUserModelSetLocale(t *testing.T) {
+	m := newTestUserModel(t)
+
+	for _, locale := range []string{"es", "es", ""} {
+		if err := m.SetLocale(context.Background(), 1, locale); err != nil {
+			t.Fatal(err)
+		}
+		user, err := m.Get(context.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, user.Locale, locale)
+	}
+}
+
+func TestUserModelRoles(t *testing.T) {
+	m := newTestUserModel(t)
+	ctx := context.Background()
+
+	user, err := m.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Role, models.RoleUser)
+
+	tests := []struct {
+		name    string
+		userID  int
+		role    models.Role
+		wantErr error
+	}{
+		{name: "admin", userID: 1, role: models.RoleAdmin},
+		{name: "unchanged", userID: 1, role: models.RoleAdmin},
+		{name: "moderator", userID: 1, role: models.RoleModerator},
+		{name: "non-existent id", userID: 2, role: models.RoleAdmin, wantErr: models.ErrNoRecord},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := m.SetRole(ctx, test.userID, test.role)
+			assert.Equal(t, errors.Is(err, test.wantErr), true)
+			if test.wantErr == nil {
+				user, err := m.Get(ctx, test.userID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				assert.Equal(t, user.Role, test.role)
+			}
+		})
+	}
+
+	if err := m.SetRole(ctx, 1, "superuser"); err == nil {
+		t.Error("expected an error for an unknown role")
+	}
+}
+
+func TestUserModelSetDisabled(t *testing.T) {
+	m := newTestUserModel(t)
+	ctx := context.Background()
+
+	if err := m.SetDisabled(ctx, 1, true); err != nil {
+		t.Fatal(err)
+	}
+	user, err := m.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Disabled, true)
+
+	// the right password to a disabled account is refused with its own error; a wrong one still looks like any other failed login
+	_, err = m.Authenticate(ctx, "alice@example.com", "pa$$word")
+	assert.Equal(t, errors.Is(err, models.ErrAccountDisabled), true)
+	_, err = m.Authenticate(ctx, "alice@example.com", "password")
+	assert.Equal(t, errors.Is(err, models.ErrInvalidCredentials), true)
+
+	if err := m.SetDisabled(ctx, 1, false); err != nil {
+		t.Fatal(err)
+	}
+	id, err := m.Authenticate(ctx, "alice@example.com", "pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, id, 1)
+
+	assert.Equal(t, errors.Is(m.SetDisabled(ctx, 2, true), models.ErrNoRecord), true)
+}
+
+func TestUserModelSetAccess(t *testing.T) {
+	m := newTestUserModel(t)
+	ctx := context.Background()
+
+	if err := m.SetAccess(ctx, 1, models.RoleModerator, true); err != nil {
+		t.Fatal(err)
+	}
+	user, err := m.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Role, models.RoleModerator)
+	assert.Equal(t, user.Disabled, true)
+
+	// an unknown role changes nothing, not even the disabled flag
+	if err := m.SetAccess(ctx, 1, "superuser", false); err == nil {
+		t.Error("expected an error for an unknown role")
+	}
+	user, err = m.Get(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, user.Disabled, true)
+
+	assert.Equal(t, errors.Is(m.SetAccess(ctx, 2, models.RoleUser, false), models.ErrNoRecord), true)
+}
+
+func TestUserModelSetPassword(t *testing.T) {
+	m := newTestUserModel(t)
+	ctx := context.Background()
+
+	if err := m.SetPassword(ctx, 1, "n3w pa$$word"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := m.Authenticate(ctx, "alice@example.com", "pa$$word")
+	assert.Equal(t, errors.Is(err, models.ErrInvalidCredentials), true)
+	id, err := m.Authenticate(ctx, "alice@example.com", "n3w pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, id, 1)
+
+	assert.Equal(t, errors.Is(m.SetPassword(ctx, 2, "n3w pa$$word"), models.ErrNoRecord), true)
+}
+
+func TestUserModelRehash(t *testing.T) {
+	m := newTestUserModel(t)
+	ctx := context.Background()
+	hash := func() string {
+		var hash string
+		if err := m.DB.QueryRow(m.Dialect.Rebind(`SELECT hashed_password FROM users WHERE id = ?`), 1).Scan(&hash); err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+	authenticate := func() {
+		id, err := m.Authenticate(ctx, "alice@example.com", "pa$$word")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, id, 1)
+	}
+
+	// an up to date hash is left alone
+	before := hash()
+	authenticate()
+	assert.Equal(t, hash(), before)
+
+	// a failed login doesn't rehash
+	m.Hasher = &password.Hasher{Target: password.Argon2id{Memory: 64, Iterations: 1, Parallelism: 1}}
+	_, err := m.Authenticate(ctx, "alice@example.com", "wrong")
+	assert.Equal(t, errors.Is(err, models.ErrInvalidCredentials), true)
+	assert.Equal(t, hash(), before)
+
+	// switching algorithm
+	authenticate()
+	assert.StringContains(t, hash(), "$argon2id$v=19$m=64,t=1,p=1$")
+
+	// changing parameters
+	m.Hasher = &password.Hasher{Target: password.Argon2id{Memory: 64, Iterations: 2, Parallelism: 1}}
+	authenticate()
+	assert.StringContains(t, hash(), "$argon2id$v=19$m=64,t=2,p=1$")
+
+	// and back to bcrypt, at a higher cost
+	m.Hasher = &password.Hasher{Target: password.Bcrypt{Cost: bcrypt.MinCost + 1}}
+	authenticate()
+	cost, err := bcrypt.Cost([]byte(hash()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, cost, bcrypt.MinCost+1)
+	authenticate()
+}
+
+func TestUserModelList(t *testing.T) {
+	m := newTestUserModel(t)
+	ctx := context.Background()
+	for _, u := range []struct{ name, email string }{
+		{"Bob", "bob@example.com"},
+		{"Carol", "carol_100%@example.org"},
+	} {
+		if err := m.Insert(ctx, u.name, u.email, "pa$$word"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		search    string
+		limit     int
+		wantNames string
+	}{
+		{name: "everyone", limit: 10, wantNames: "Alice,Bob,Carol"},
+		{name: "limit", limit: 2, wantNames: "Alice,Bob"},
+		{name: "name ignoring case", search: "bOB", limit: 10, wantNames: "Bob"},
+		{name: "email", search: "example.org", limit: 10, wantNames: "Carol"},
+		{name: "wildcards are literal", search: "_100%", limit: 10, wantNames: "Carol"},
+		{name: "percent only", search: "%", limit: 10, wantNames: "Carol"},
+		{name: "no match", search: "dave", limit: 10, wantNames: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			users, err := m.List(ctx, test.search, test.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, u := range users {
+				names = append(names, u.Name)
+			}
+			assert.Equal(t, strings.Join(names, ","), test.wantNames)
+		})
+	}
+}
+
+func TestUserModelCounts(t *testing.T) {
+	m := newTestUserModel(t)
+	ctx := context.Background()
+	if err := m.Insert(ctx, "Bob", "bob@example.com", "pa$$word"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Insert(ctx, "Carol", "carol@example.com", "pa$$word"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetRole(ctx, 1, models.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetRole(ctx, 2, models.RoleModerator); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetDisabled(ctx, 3, true); err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := m.Counts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, *counts, models.UserCounts{Total: 3, Moderators: 1, Admins: 1, Disabled: 1})
+}
+
+func TestRoleAtLeast(t *testing.T) {
+	tests := []struct {
+		role models.Role
+		min  models.Role
+		want bool
+	}{
+		{models.RoleUser, models.RoleUser, true},
+		{models.RoleUser, models.RoleModerator, false},
+		{models.RoleModerator, models.RoleModerator, true},
+		{models.RoleAdmin, models.RoleModerator, true},
+		{models.RoleModerator, models.RoleAdmin, false},
+		{"", models.RoleUser, false},
+		{"superuser", models.RoleUser, false},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("%s>=%s", test.role, test.min), func(t *testing.T) {
+			assert.Equal(t, test.role.AtLeast(test.min), test.want)
+		})
+	}
+}
@@ -0,0 +1,208 @@
+// Package oidc logs users in with an OpenID Connect provider, using the authorization code flow with PKCE (RFC 7636).
+//
+// A login goes: NewAuthRequest makes the state, nonce and PKCE verifier, which the caller keeps (e.g. in the session) while the browser is sent to AuthCodeURL. The provider sends the browser back to the redirect URL with a code and the state, and Exchange swaps the code for an ID token, verifies it against the provider's published keys and returns the claims about the user.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidToken means the provider's ID token didn't verify: a bad signature, the wrong issuer, audience or nonce, or an expired token.
+var ErrInvalidToken = errors.New("oidc: invalid ID token")
+
+// DefaultScopes are the scopes requested when Config.Scopes is empty. They ask for the user's email address and name as well as their subject.
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+// maxResponseSize caps the responses read from the provider.
+const maxResponseSize = 1 << 20
+
+// Config describes the application's registration with a provider.
+type Config struct {
+	// Issuer is the provider's issuer URL, e.g. "https://login.example.com". Its metadata is at Issuer + "/.well-known/openid-configuration".
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is where the provider sends the browser back to, and must be registered with the provider.
+	RedirectURL string
+	// Scopes are the scopes to request. Nil means DefaultScopes.
+	Scopes []string
+	// HTTPClient makes the requests to the provider. Nil means a client with a 10 second timeout.
+	HTTPClient *http.Client
+}
+
+// metadata is the part of the provider's discovery document that the authorization code flow needs.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an OpenID Connect provider that users log in with. It is safe for concurrent use.
+type Provider struct {
+	config   Config
+	metadata metadata
+	client   *http.Client
+
+	// keys are the provider's signing keys by key ID, fetched from the JWKS URI when a token needs one that isn't known yet
+	mu   sync.Mutex
+	keys map[string]publicKey
+}
+
+// Discover fetches the provider's metadata from its discovery document and returns the Provider.
+func Discover(ctx context.Context, config Config) (*Provider, error) {
+	p := &Provider{config: config, client: config.HTTPClient}
+	if p.client == nil {
+		p.client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if len(p.config.Scopes) == 0 {
+		p.config.Scopes = DefaultScopes
+	}
+
+	if err := p.getJSON(ctx, strings.TrimSuffix(config.Issuer, "/")+"/.well-known/openid-configuration", &p.metadata); err != nil {
+		return nil, fmt.Errorf("oidc: discovery: %w", err)
+	}
+	// the issuer in the document must be the one asked for exactly, or tokens from a different issuer could be accepted (OpenID Connect Discovery 1.0 section 4.3)
+	if p.metadata.Issuer != config.Issuer {
+		return nil, fmt.Errorf("oidc: discovery: issuer %q doesn't match %q", p.metadata.Issuer, config.Issuer)
+	}
+	if p.metadata.AuthorizationEndpoint == "" || p.metadata.TokenEndpoint == "" || p.metadata.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery: the provider's metadata is missing an endpoint")
+	}
+	return p, nil
+}
+
+// Issuer returns the provider's issuer URL, which identifies it in the subjects' Claims.
+func (p *Provider) Issuer() string {
+	return p.metadata.Issuer
+}
+
+// AuthRequest holds the secrets of one login, which the caller keeps until the provider redirects back.
+type AuthRequest struct {
+	// State is sent to the provider and comes back with the code. The callback must check that it is the one it sent, so a code can't be slipped into someone else's browser.
+	State string
+	// Nonce is sent to the provider and comes back in the ID token, tying the token to this login.
+	Nonce string
+	// Verifier is the PKCE code verifier. Only its hash is sent with the user, and the verifier itself with the code, so an intercepted code is useless.
+	Verifier string
+}
+
+// NewAuthRequest returns an AuthRequest with fresh random secrets.
+func NewAuthRequest() (*AuthRequest, error) {
+	var secrets [3]string
+	for i := range secrets {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		secrets[i] = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return &AuthRequest{State: secrets[0], Nonce: secrets[1], Verifier: secrets[2]}, nil
+}
+
+// AuthCodeURL returns the URL of the provider's login page for req. extra are added to its query, e.g. prompt=login to make the user log in again even if they are logged in at the provider.
+func (p *Provider) AuthCodeURL(req *AuthRequest, extra url.Values) string {
+	challenge := sha256.Sum256([]byte(req.Verifier))
+	v := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.config.ClientID},
+		"redirect_uri":          {p.config.RedirectURL},
+		"scope":                 {strings.Join(p.config.Scopes, " ")},
+		"state":                 {req.State},
+		"nonce":                 {req.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	for key, values := range extra {
+		v[key] = values
+	}
+	// the endpoint may have a query of its own
+	sep := "?"
+	if strings.Contains(p.metadata.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.metadata.AuthorizationEndpoint + sep + v.Encode()
+}
+
+// Claims are what the provider says about the user in the ID token.
+type Claims struct {
+	Issuer string
+	// Subject identifies the user at the provider. Unlike the email address, it never changes or gets reused.
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// tokenResponse is the token endpoint's response, successful or not.
+type tokenResponse struct {
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// Exchange swaps the code the provider redirected back with for an ID token, and returns its claims once it has verified the token for req.
+func (p *Provider) Exchange(ctx context.Context, code string, req *AuthRequest) (*Claims, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.config.RedirectURL},
+		"code_verifier": {req.Verifier},
+		"client_id":     {p.config.ClientID},
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, p.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Accept", "application/json")
+	// client_secret_basic, where the id and secret are form-encoded first (RFC 6749 section 2.3.1)
+	r.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	resp, err := p.client.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token request: %w", err)
+	}
+	defer resp.Body.Close()
+	var token tokenResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&token); err != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("oidc: token request: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK || token.Error != "" {
+		return nil, fmt.Errorf("oidc: token request: %s: %s %s", resp.Status, token.Error, token.ErrorDescription)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("oidc: token request: no ID token in the response")
+	}
+	return p.verify(ctx, token.IDToken, req.Nonce)
+}
+
+// getJSON fetches url and decodes its JSON body into dest.
+func (p *Provider) getJSON(ctx context.Context, url string, dest any) error {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(dest)
+}
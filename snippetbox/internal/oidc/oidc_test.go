@@ -0,0 +1,150 @@
+package oidc_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"snippetbox.audryhsu.com/internal/assert"
+	"snippetbox.audryhsu.com/internal/oidc"
+	"snippetbox.audryhsu.com/internal/oidc/oidctest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// discover starts a stand-in provider and discovers it.
+func discover(t *testing.T) (*oidctest.Server, *oidc.Provider) {
+	srv := oidctest.NewServer()
+	t.Cleanup(srv.Close)
+	p, err := oidc.Discover(context.Background(), oidc.Config{
+		Issuer:       srv.URL,
+		ClientID:     srv.ClientID,
+		ClientSecret: srv.ClientSecret,
+		RedirectURL:  "https://snippetbox.example.com/user/oidc/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, p
+}
+
+// login goes through the authorization code flow for req, and returns the result of the exchange.
+func login(t *testing.T, srv *oidctest.Server, p *oidc.Provider, req *oidc.AuthRequest) (*oidc.Claims, error) {
+	callback, err := srv.Authorize(p.AuthCodeURL(req, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, callback.Host, "snippetbox.example.com")
+	assert.Equal(t, callback.Query().Get("state"), req.State)
+	return p.Exchange(context.Background(), callback.Query().Get("code"), req)
+}
+
+func newAuthRequest(t *testing.T) *oidc.AuthRequest {
+	req, err := oidc.NewAuthRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestLogin(t *testing.T) {
+	srv, p := discover(t)
+	assert.Equal(t, p.Issuer(), srv.URL)
+
+	req := newAuthRequest(t)
+	other := newAuthRequest(t)
+	assert.Equal(t, req.State == other.State || req.Nonce == other.Nonce || req.Verifier == other.Verifier, false)
+
+	claims, err := login(t, srv, p, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, *claims, oidc.Claims{Issuer: srv.URL, Subject: srv.User.Subject, Email: srv.User.Email, EmailVerified: true, Name: srv.User.Name})
+
+	// a code can only be redeemed once
+	callback, err := srv.Authorize(p.AuthCodeURL(req, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := callback.Query().Get("code")
+	_, err = p.Exchange(context.Background(), code, req)
+	assert.Equal(t, err, nil)
+	_, err = p.Exchange(context.Background(), code, req)
+	assert.StringContains(t, err.Error(), "invalid_grant")
+
+	// PKCE: the code is useless without the verifier of the request it was issued for
+	callback, err = srv.Authorize(p.AuthCodeURL(req, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = p.Exchange(context.Background(), callback.Query().Get("code"), &oidc.AuthRequest{State: req.State, Nonce: req.Nonce, Verifier: other.Verifier})
+	assert.StringContains(t, err.Error(), "invalid_grant")
+
+	// the provider rotates its key; the new one is fetched when a token is signed with it
+	srv.RotateKey()
+	_, err = login(t, srv, p, newAuthRequest(t))
+	assert.Equal(t, err, nil)
+}
+
+func TestLoginInvalidToken(t *testing.T) {
+	tests := []struct {
+		name         string
+		modifyClaims func(claims map[string]any)
+		modifyToken  func(srv *oidctest.Server, token string) string
+	}{
+		{name: "wrong nonce", modifyClaims: func(c map[string]any) { c["nonce"] = "replayed" }},
+		{name: "wrong audience", modifyClaims: func(c map[string]any) { c["aud"] = "another-app" }},
+		{name: "another authorized party", modifyClaims: func(c map[string]any) { c["aud"] = []string{"snippetbox", "another-app"}; c["azp"] = "another-app" }},
+		{name: "wrong issuer", modifyClaims: func(c map[string]any) { c["iss"] = "https://evil.example.com" }},
+		{name: "expired", modifyClaims: func(c map[string]any) { c["exp"] = time.Now().Add(-2 * time.Minute).Unix() }},
+		{name: "no expiry", modifyClaims: func(c map[string]any) { delete(c, "exp") }},
+		{name: "issued in the future", modifyClaims: func(c map[string]any) { c["iat"] = time.Now().Add(time.Hour).Unix() }},
+		{name: "no subject", modifyClaims: func(c map[string]any) { delete(c, "sub") }},
+		{name: "tampered", modifyToken: func(srv *oidctest.Server, token string) string {
+			parts := strings.Split(token, ".")
+			payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+			payload = []byte(strings.Replace(string(payload), "jane@example.com", "root@example.com", 1))
+			return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+		}},
+		{name: "alg none", modifyToken: func(srv *oidctest.Server, token string) string {
+			parts := strings.Split(token, ".")
+			return base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+		}},
+		{name: "HS256 with the client secret", modifyToken: func(srv *oidctest.Server, token string) string {
+			parts := strings.Split(token, ".")
+			signed := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256"}`)) + "." + parts[1]
+			mac := hmac.New(sha256.New, []byte(srv.ClientSecret))
+			mac.Write([]byte(signed))
+			return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+		}},
+		{name: "unknown key", modifyToken: func(srv *oidctest.Server, token string) string {
+			parts := strings.Split(token, ".")
+			return base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"other"}`)) + "." + parts[1] + "." + parts[2]
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srv, p := discover(t)
+			srv.ModifyClaims = test.modifyClaims
+			if test.modifyToken != nil {
+				srv.ModifyToken = func(token string) string { return test.modifyToken(srv, token) }
+			}
+
+			claims, err := login(t, srv, p, newAuthRequest(t))
+			assert.Equal(t, errors.Is(err, oidc.ErrInvalidToken), true)
+			assert.Equal(t, claims == nil, true)
+		})
+	}
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	srv := oidctest.NewServer()
+	defer srv.Close()
+
+	// the document at the issuer's URL names a different issuer
+	_, err := oidc.Discover(context.Background(), oidc.Config{Issuer: srv.URL + "/", ClientID: srv.ClientID})
+	assert.StringContains(t, err.Error(), "doesn't match")
+}
@@ -0,0 +1,298 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	_ "crypto/sha512" // registers SHA-384 and SHA-512 for RS384, RS512, ES384 and ES512
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// clockSkew is how far the provider's clock may be from ours when checking a token's times.
+const clockSkew = time.Minute
+
+// algorithm is a JWS signature algorithm that ID tokens may be signed with.
+type algorithm struct {
+	hash crypto.Hash
+	// curve is the elliptic curve of ECDSA algorithms; nil means RSA
+	curve elliptic.Curve
+}
+
+// algorithms are the asymmetric algorithms allowed for ID tokens. Anything else, notably "none" and the HMAC algorithms that would use the client secret as the key, is refused.
+var algorithms = map[string]algorithm{
+	"RS256": {hash: crypto.SHA256},
+	"RS384": {hash: crypto.SHA384},
+	"RS512": {hash: crypto.SHA512},
+	"ES256": {hash: crypto.SHA256, curve: elliptic.P256()},
+	"ES384": {hash: crypto.SHA384, curve: elliptic.P384()},
+	"ES512": {hash: crypto.SHA512, curve: elliptic.P521()},
+}
+
+// publicKey is one of the provider's signing keys, an *rsa.PublicKey or *ecdsa.PublicKey.
+type publicKey struct {
+	key crypto.PublicKey
+	// alg is the algorithm the key is restricted to, if the provider says
+	alg string
+}
+
+// invalid returns an ErrInvalidToken saying what is wrong with the token.
+func invalid(format string, args ...any) error {
+	return fmt.Errorf("%w: "+format, append([]any{ErrInvalidToken}, args...)...)
+}
+
+// idTokenClaims are the claims of an ID token that are checked or returned.
+type idTokenClaims struct {
+	Issuer          string       `json:"iss"`
+	Subject         string       `json:"sub"`
+	Audience        audience     `json:"aud"`
+	AuthorizedParty string       `json:"azp"`
+	Expiry          float64      `json:"exp"`
+	IssuedAt        float64      `json:"iat"`
+	Nonce           string       `json:"nonce"`
+	Email           string       `json:"email"`
+	EmailVerified   flexibleBool `json:"email_verified"`
+	Name            string       `json:"name"`
+}
+
+// verify checks the signature and claims of an ID token issued for the login with nonce, as in OpenID Connect Core 1.0 section 3.1.3.7, and returns the claims.
+func (p *Provider) verify(ctx context.Context, token, nonce string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, invalid("not a JWS compact serialization")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, invalid("header: %v", err)
+	}
+	alg, ok := algorithms[header.Alg]
+	if !ok {
+		return nil, invalid("unsupported algorithm %q", header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalid("signature: %v", err)
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if key.alg != "" && key.alg != header.Alg {
+		return nil, invalid("key %q is for %s, not %s", header.Kid, key.alg, header.Alg)
+	}
+	h := alg.hash.New()
+	h.Write([]byte(parts[0] + "." + parts[1]))
+	if !verifySignature(key.key, alg, h.Sum(nil), signature) {
+		return nil, invalid("bad signature")
+	}
+
+	var claims idTokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, invalid("claims: %v", err)
+	}
+	now := time.Now()
+	switch {
+	case claims.Issuer != p.metadata.Issuer:
+		return nil, invalid("issued by %q", claims.Issuer)
+	case !claims.Audience.contains(p.config.ClientID):
+		return nil, invalid("not issued for this client")
+	case claims.AuthorizedParty != "" && claims.AuthorizedParty != p.config.ClientID:
+		return nil, invalid("issued to another client")
+	case claims.Expiry == 0 || now.After(unixTime(claims.Expiry).Add(clockSkew)):
+		return nil, invalid("expired")
+	case now.Add(clockSkew).Before(unixTime(claims.IssuedAt)):
+		return nil, invalid("issued in the future")
+	case subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return nil, invalid("wrong nonce")
+	case claims.Subject == "":
+		return nil, invalid("no subject")
+	}
+	return &Claims{
+		Issuer:        claims.Issuer,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+	}, nil
+}
+
+// verifySignature reports whether signature is key's signature of digest with alg.
+func verifySignature(key crypto.PublicKey, alg algorithm, digest, signature []byte) bool {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return alg.curve == nil && rsa.VerifyPKCS1v15(key, alg.hash, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		if alg.curve == nil || key.Curve != alg.curve {
+			return false
+		}
+		// JWS ECDSA signatures are r and s as fixed-size big-endian integers, one after the other (RFC 7518 section 3.4)
+		size := (alg.curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(key, digest, r, s)
+	}
+	return false
+}
+
+// key returns the provider's signing key with the key ID kid. A token without a key ID can only use the provider's single key.
+// Keys that aren't known yet are looked for in a fresh copy of the provider's key set, as providers publish new keys and start signing with them from time to time. Only tokens from the provider's token endpoint are verified, so the fetches can't be triggered by anyone else.
+func (p *Provider) key(ctx context.Context, kid string) (publicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return publicKey{}, err
+	}
+	p.keys = keys
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return publicKey{}, invalid("unknown key %q", kid)
+}
+
+// lookupKey returns the known key with the key ID kid. The caller holds p.mu.
+func (p *Provider) lookupKey(kid string) (publicKey, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// jwk is a JSON Web Key (RFC 7517). Only the members of RSA and EC public keys are decoded.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// curves are the elliptic curves of EC keys by name.
+var curves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// fetchKeys fetches the provider's signing keys from its JWKS URI. Keys for encryption and of unsupported types are skipped.
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]publicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.metadata.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("oidc: fetching keys: %w", err)
+	}
+	keys := make(map[string]publicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key crypto.PublicKey
+		switch k.Kty {
+		case "RSA":
+			n, errN := decodeInt(k.N)
+			e, errE := decodeInt(k.E)
+			if errN != nil || errE != nil || !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+				return nil, fmt.Errorf("oidc: fetching keys: malformed RSA key %q", k.Kid)
+			}
+			key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			curve, ok := curves[k.Crv]
+			if !ok {
+				continue
+			}
+			x, errX := decodeInt(k.X)
+			y, errY := decodeInt(k.Y)
+			if errX != nil || errY != nil || !curve.IsOnCurve(x, y) {
+				return nil, fmt.Errorf("oidc: fetching keys: malformed EC key %q", k.Kid)
+			}
+			key = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		default:
+			continue
+		}
+		keys[k.Kid] = publicKey{key: key, alg: k.Alg}
+	}
+	return keys, nil
+}
+
+// decodeSegment decodes a base64url-encoded JSON segment of a JWS into dest.
+func decodeSegment(segment string, dest any) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dest)
+}
+
+// decodeInt decodes a base64url-encoded big-endian integer of a JWK.
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("bad integer %q", s)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// unixTime converts a NumericDate, seconds since the epoch, to a time.
+func unixTime(seconds float64) time.Time {
+	return time.Unix(int64(seconds), 0)
+}
+
+// audience is the aud claim, which is either one string or an array of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = audience{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// flexibleBool is a boolean claim that some providers send as the string "true" or "false".
+type flexibleBool bool
+
+func (f *flexibleBool) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*f = s == "true"
+		return nil
+	}
+	return json.Unmarshal(b, (*bool)(f))
+}
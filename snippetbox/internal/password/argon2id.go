@@ -0,0 +1,99 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"strings"
+)
+
+// The argon2id defaults follow the second recommended option of RFC 9106, with less parallelism to suit a small server.
+const (
+	DefaultArgon2Memory      = 64 * 1024
+	DefaultArgon2Iterations  = 3
+	DefaultArgon2Parallelism = 2
+)
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// Argon2id hashes passwords with argon2id. Hashes are in the PHC string format, e.g. "$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>", with the salt and key in unpadded base64.
+// Zero parameters mean the Default ones.
+type Argon2id struct {
+	// Memory is the memory used by each hash, in KiB.
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+func (a Argon2id) Prefixes() []string {
+	return []string{"$argon2id$"}
+}
+
+func (a Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	params := a.params()
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a Argon2id) Verify(hash, password string) error {
+	params, salt, key, err := parseArgon2id(hash)
+	if err != nil {
+		return err
+	}
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return ErrMismatch
+	}
+	return nil
+}
+
+func (a Argon2id) Current(hash string) bool {
+	params, salt, key, err := parseArgon2id(hash)
+	return err == nil && params == a.params() && len(salt) == argon2SaltLength && len(key) == argon2KeyLength
+}
+
+// params returns the configured parameters, falling back to the defaults.
+func (a Argon2id) params() Argon2id {
+	if a.Memory == 0 {
+		a.Memory = DefaultArgon2Memory
+	}
+	if a.Iterations == 0 {
+		a.Iterations = DefaultArgon2Iterations
+	}
+	if a.Parallelism == 0 {
+		a.Parallelism = DefaultArgon2Parallelism
+	}
+	return a
+}
+
+// parseArgon2id splits an argon2id hash into its parameters, salt and key. Hashes of other argon2 versions are ErrUnknownHash.
+func parseArgon2id(hash string) (params Argon2id, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return params, nil, nil, ErrUnknownHash
+	}
+	var extra string
+	n, _ := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d%s", &params.Memory, &params.Iterations, &params.Parallelism, &extra)
+	if n != 3 || params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+		return params, nil, nil, ErrUnknownHash
+	}
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) == 0 {
+		return params, nil, nil, ErrUnknownHash
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrUnknownHash
+	}
+	return params, salt, key, nil
+}
@@ -0,0 +1,51 @@
+package password
+
+import (
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DefaultBcryptCost is the cost snippetbox has always hashed passwords at, two steps above bcrypt.DefaultCost.
+const DefaultBcryptCost = 12
+
+// Bcrypt hashes passwords with bcrypt. Only the first 72 bytes of a password count.
+type Bcrypt struct {
+	// Cost is the log2 of the number of rounds. Zero means DefaultBcryptCost.
+	Cost int
+}
+
+func (b Bcrypt) Prefixes() []string {
+	return []string{"$2a$", "$2b$", "$2y$"}
+}
+
+func (b Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.cost())
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (b Bcrypt) Verify(hash, password string) error {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	switch {
+	case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+		return ErrMismatch
+	case err != nil:
+		return ErrUnknownHash
+	}
+	return nil
+}
+
+func (b Bcrypt) Current(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err == nil && cost == b.cost()
+}
+
+// cost returns the configured cost, falling back to DefaultBcryptCost.
+func (b Bcrypt) cost() int {
+	if b.Cost == 0 {
+		return DefaultBcryptCost
+	}
+	return b.Cost
+}
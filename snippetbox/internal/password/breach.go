@@ -0,0 +1,266 @@
+package password
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// breachPrefixLength is the number of hex digits of a hash that Range looks up, as in the Pwned Passwords range API.
+const breachPrefixLength = 5
+
+// MaxLoadedBreachHashes is the most hashes LoadBreachList reads into memory, about 20MB of them. Bigger lists, up to the full Pwned Passwords list, are searched on disk by OpenBreachList instead.
+const MaxLoadedBreachHashes = 1 << 20
+
+// breachSearchBlock is the span of a sorted file that is read line by line once the binary search has narrowed a range down to it.
+const breachSearchBlock = 4096
+
+// BreachList holds the SHA-1 hashes of passwords leaked in data breaches, which attackers try before anything else.
+//
+// Lookups work like the k-anonymity range API of Have I Been Pwned's Pwned Passwords: Range returns the hashes sharing the first five hex digits of a hash, and Contains looks for the rest of a password's hash among them. Nothing is sent over the network.
+// A small list is held in memory. The full Pwned Passwords list, about a billion hashes, is far too big for that, so OpenBreachList searches it where it lies on disk: either one file sorted by hash, or the folder of range files the Pwned Passwords downloader writes.
+type BreachList struct {
+	// hashes are sorted, so each range is a slice of them; set by LoadBreachList
+	hashes [][sha1.Size]byte
+	// file is a file sorted by hash, binary searched with ReadAt
+	file *os.File
+	size int64
+	// dir is a folder holding a file of hash suffixes for each prefix, e.g. 5BAA6.txt
+	dir string
+}
+
+// OpenBreachList opens the breach list at path, which is searched on disk rather than loaded into memory. path is either a file with one SHA-1 hash in hex per line, in the format read by LoadBreachList, that must be sorted by hash, as the Pwned Passwords downloads ordered by hash are; or a folder of range files named after the first five hex digits of their hashes, e.g. 5BAA6.txt, holding the other 35 digits of each, as written by the Pwned Passwords downloader and served by the range API.
+func OpenBreachList(path string) (*BreachList, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return &BreachList{dir: path}, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	l := &BreachList{file: f, size: info.Size()}
+	// check the format up front, rather than on the first signup
+	if _, err := l.lineAfter(0); err != nil && !errors.Is(err, io.EOF) {
+		f.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Close closes the file of a breach list opened by OpenBreachList.
+func (l *BreachList) Close() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// LoadBreachList reads a BreachList into memory, with one SHA-1 hash in hex per line, optionally followed by ":" and the number of times it was seen, as in the Pwned Passwords downloads. Blank lines and lines starting with # are skipped.
+// Lists of more than MaxLoadedBreachHashes hashes are refused: use OpenBreachList for those.
+func LoadBreachList(r io.Reader) (*BreachList, error) {
+	l := &BreachList{}
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		h, err := parseBreachHash(line)
+		if err != nil {
+			return nil, fmt.Errorf("password: breach list line %d: %w", n, err)
+		}
+		if len(l.hashes) == MaxLoadedBreachHashes {
+			return nil, fmt.Errorf("password: breach list has more than %d hashes, too many to load into memory", MaxLoadedBreachHashes)
+		}
+		l.hashes = append(l.hashes, h)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(l.hashes, func(i, j int) bool { return bytes.Compare(l.hashes[i][:], l.hashes[j][:]) < 0 })
+	return l, nil
+}
+
+// Range returns the last 35 hex digits, in upper case, of the hashes in the list starting with prefix, the first five hex digits of a hash.
+func (l *BreachList) Range(prefix string) ([]string, error) {
+	if l == nil || len(prefix) != breachPrefixLength {
+		return nil, nil
+	}
+	prefix = strings.ToUpper(prefix)
+	switch {
+	case l.dir != "":
+		return l.rangeFile(prefix)
+	case l.file != nil:
+		return l.searchFile(prefix)
+	}
+
+	start := sort.Search(len(l.hashes), func(i int) bool { return hashPrefix(l.hashes[i]) >= prefix })
+	var suffixes []string
+	for _, h := range l.hashes[start:] {
+		if hashPrefix(h) != prefix {
+			break
+		}
+		suffixes = append(suffixes, hashSuffix(h))
+	}
+	return suffixes, nil
+}
+
+// Contains reports whether password is in the list. A nil list contains nothing. The error is about reading a list on disk.
+func (l *BreachList) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	suffixes, err := l.Range(hash[:breachPrefixLength])
+	if err != nil {
+		return false, err
+	}
+	for _, suffix := range suffixes {
+		if suffix == hash[breachPrefixLength:] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// rangeFile reads the suffixes of prefix from its range file in l.dir. A missing file is an empty range.
+func (l *BreachList) rangeFile(prefix string) ([]string, error) {
+	f, err := os.Open(filepath.Join(l.dir, prefix+".txt"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var suffixes []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		h, err := parseBreachHash(prefix + line)
+		if err != nil {
+			return nil, fmt.Errorf("password: breach list %s: %w", f.Name(), err)
+		}
+		suffixes = append(suffixes, hashSuffix(h))
+	}
+	return suffixes, scanner.Err()
+}
+
+// searchFile binary searches l.file, sorted by hash, for the suffixes of prefix.
+func (l *BreachList) searchFile(prefix string) ([]string, error) {
+	// keep the first hash line after lo before the range, and the one after hi in or past it
+	lo, hi := int64(0), l.size
+	for hi-lo > breachSearchBlock {
+		mid := lo + (hi-lo)/2
+		h, err := l.lineAfter(mid)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if errors.Is(err, io.EOF) || hashPrefix(h) >= prefix {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+
+	r, err := l.linesFrom(lo)
+	if err != nil {
+		return nil, err
+	}
+	var suffixes []string
+	for {
+		h, err := readBreachHash(r)
+		if errors.Is(err, io.EOF) {
+			return suffixes, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("password: breach list %s: %w", l.file.Name(), err)
+		}
+		if p := hashPrefix(h); p > prefix {
+			return suffixes, nil
+		} else if p == prefix {
+			suffixes = append(suffixes, hashSuffix(h))
+		}
+	}
+}
+
+// lineAfter returns the hash on the first line of l.file that starts at or after offset, skipping blank lines and comments. It returns io.EOF if there is no such line.
+func (l *BreachList) lineAfter(offset int64) ([sha1.Size]byte, error) {
+	r, err := l.linesFrom(offset)
+	if err != nil {
+		return [sha1.Size]byte{}, err
+	}
+	h, err := readBreachHash(r)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return h, fmt.Errorf("password: breach list %s: %w", l.file.Name(), err)
+	}
+	return h, err
+}
+
+// linesFrom returns a reader of l.file from the first line that starts at or after offset.
+func (l *BreachList) linesFrom(offset int64) (*bufio.Reader, error) {
+	r := bufio.NewReader(io.NewSectionReader(l.file, offset, l.size-offset))
+	if offset == 0 {
+		return r, nil
+	}
+	// a line starts at offset if it follows a newline; otherwise skip the rest of the line offset falls in
+	var b [1]byte
+	if _, err := l.file.ReadAt(b[:], offset-1); err != nil {
+		return nil, err
+	}
+	if b[0] != '\n' {
+		if _, err := r.ReadString('\n'); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// readBreachHash reads the next hash from r, skipping blank lines and comments. It returns io.EOF at the end of r.
+func readBreachHash(r *bufio.Reader) ([sha1.Size]byte, error) {
+	for {
+		line, err := r.ReadString('\n')
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+			return parseBreachHash(line)
+		}
+		if err != nil {
+			return [sha1.Size]byte{}, err
+		}
+	}
+}
+
+// parseBreachHash parses a line of a breach list: a SHA-1 hash in hex, optionally followed by ":" and a count.
+func parseBreachHash(line string) (h [sha1.Size]byte, err error) {
+	hash, _, _ := strings.Cut(line, ":")
+	if len(hash) != hex.EncodedLen(sha1.Size) {
+		return h, errors.New("not a SHA-1 hash")
+	}
+	if _, err := hex.Decode(h[:], []byte(hash)); err != nil {
+		return h, errors.New("not a SHA-1 hash")
+	}
+	return h, nil
+}
+
+// hashPrefix returns the first five hex digits of h, in upper case.
+func hashPrefix(h [sha1.Size]byte) string {
+	return strings.ToUpper(hex.EncodeToString(h[:3]))[:breachPrefixLength]
+}
+
+// hashSuffix returns the last 35 hex digits of h, in upper case.
+func hashSuffix(h [sha1.Size]byte) string {
+	return strings.ToUpper(hex.EncodeToString(h[:]))[breachPrefixLength:]
+}
@@ -0,0 +1,144 @@
+package password_test
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"snippetbox.audryhsu.com/internal/assert"
+	"snippetbox.audryhsu.com/internal/password"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// SHA-1 hashes of "password" and "letmein", and a hash sharing the first five hex digits with "password"'s.
+const breachList = `# top breached passwords
+5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824
+b7a875fc1ea228b9061041b7cec4bd3c52ab3ce3
+
+5BAA6FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF:1
+`
+
+// checkBreachList checks the lookups of a list holding breachList's hashes, however it is stored.
+func checkBreachList(t *testing.T, l *password.BreachList) {
+	t.Helper()
+	for pw, want := range map[string]bool{"password": true, "letmein": true, "Password": false} {
+		got, err := l.Contains(pw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, got, want)
+	}
+
+	suffixes, err := l.Range("5baa6")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, strings.Join(suffixes, ","), "1E4C9B93F3F0682250B6CF8331B7EE68FD8,FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF")
+	suffixes, _ = l.Range("00000")
+	assert.Equal(t, len(suffixes), 0)
+	suffixes, _ = l.Range("5BAA")
+	assert.Equal(t, len(suffixes), 0)
+}
+
+func TestBreachList(t *testing.T) {
+	l, err := password.LoadBreachList(strings.NewReader(breachList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkBreachList(t, l)
+
+	// a nil list, when no file is configured, contains nothing
+	var none *password.BreachList
+	found, err := none.Contains("password")
+	assert.Equal(t, found, false)
+	assert.Equal(t, err, nil)
+}
+
+// TestOpenBreachList checks the lists searched on disk: a file sorted by hash, big enough for the binary search to take several steps, and a folder of range files.
+func TestOpenBreachList(t *testing.T) {
+	dir := t.TempDir()
+
+	// the hashes of breachList among those of 10000 other passwords, sorted as in the Pwned Passwords download ordered by hash
+	lines := []string{"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824", "B7A875FC1EA228B9061041B7CEC4BD3C52AB3CE3:12", "5BAA6FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF:1"}
+	for i := 0; i < 10000; i++ {
+		lines = append(lines, fmt.Sprintf("%X:%d", sha1.Sum([]byte(fmt.Sprintf("filler %d", i))), i))
+	}
+	sort.Strings(lines)
+	sorted := filepath.Join(dir, "pwnedpasswords.txt")
+	if err := os.WriteFile(sorted, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ranges := filepath.Join(dir, "ranges")
+	if err := os.Mkdir(ranges, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for prefix, contents := range map[string]string{
+		"5BAA6": "1E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824\r\nFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF:1\r\n",
+		"B7A87": "5FC1EA228B9061041B7CEC4BD3C52AB3CE3:12\r\n",
+	} {
+		if err := os.WriteFile(filepath.Join(ranges, prefix+".txt"), []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, path := range []string{sorted, ranges} {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			l, err := password.OpenBreachList(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			checkBreachList(t, l)
+		})
+	}
+
+	// every filler hash is found too, wherever it falls in the file
+	l, err := password.OpenBreachList(sorted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for i := 0; i < 10000; i += 97 {
+		found, err := l.Contains(fmt.Sprintf("filler %d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, found, true)
+	}
+}
+
+func TestLoadBreachListInvalid(t *testing.T) {
+	for _, list := range []string{"5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD\n", "password\n", "ZBAA61E4C9B93F3F0682250B6CF8331B7EE68FD8\n"} {
+		_, err := password.LoadBreachList(strings.NewReader(list))
+		assert.Equal(t, err != nil, true)
+
+		path := filepath.Join(t.TempDir(), "list.txt")
+		if err := os.WriteFile(path, []byte(list), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err = password.OpenBreachList(path)
+		assert.Equal(t, err != nil, true)
+	}
+}
+
+// hashLines is an endless breach list, of the same hash over and over.
+type hashLines struct{}
+
+func (hashLines) Read(p []byte) (int, error) {
+	const line = "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8\n"
+	n := 0
+	for n+len(line) <= len(p) {
+		n += copy(p[n:], line)
+	}
+	return n, nil
+}
+
+// TestLoadBreachListTooBig checks that lists too big for memory are refused rather than loaded.
+func TestLoadBreachListTooBig(t *testing.T) {
+	_, err := password.LoadBreachList(io.LimitReader(hashLines{}, 41*(password.MaxLoadedBreachHashes+1)))
+	assert.Equal(t, err != nil, true)
+}
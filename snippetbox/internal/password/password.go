@@ -0,0 +1,75 @@
+// Package password hashes users' passwords and checks passwords against the hashes. It also judges new passwords: Estimate rates how easy one is to guess, and a BreachList holds passwords known from data breaches.
+//
+// Each Encoder implements one hashing algorithm, and the hashes it makes start with a prefix that identifies it: "$2a$" for bcrypt, "$argon2id$" for argon2id.
+// A Hasher hashes new passwords with its target encoder and checks existing hashes with whichever encoder made them, so the algorithm or its parameters can change without locking anyone out.
+// Verify reports hashes that the target encoder would have made differently, so they can be upgraded while the password is at hand, on login.
+package password
+
+import (
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrMismatch means the password doesn't match the hash.
+	ErrMismatch = errors.New("password: password doesn't match hash")
+	// ErrUnknownHash means no encoder recognises the hash's prefix, or the hash is malformed.
+	ErrUnknownHash = errors.New("password: unknown hash format")
+)
+
+// An Encoder hashes passwords with one algorithm and set of parameters.
+type Encoder interface {
+	// Prefixes are the prefixes of the hashes the encoder can verify.
+	Prefixes() []string
+	// Hash returns a hash of password, salted at random.
+	Hash(password string) (string, error)
+	// Verify returns ErrMismatch if password doesn't match hash. The parameters are read from the hash rather than the encoder.
+	Verify(hash, password string) error
+	// Current reports whether hash was made with the encoder's algorithm and parameters.
+	Current(hash string) bool
+}
+
+// encoders can verify every kind of hash, whatever the target.
+var encoders = []Encoder{Bcrypt{}, Argon2id{}}
+
+// Hasher hashes passwords with Target and verifies hashes made by any encoder. The zero Hasher uses bcrypt at DefaultBcryptCost.
+type Hasher struct {
+	Target Encoder
+}
+
+// Hash returns a hash of password made with the target encoder.
+func (h *Hasher) Hash(password string) (string, error) {
+	return h.target().Hash(password)
+}
+
+// Verify checks password against hash, which may have been made by any encoder. If the password matches, rehash reports whether hash is outdated, i.e. the target encoder would make it differently, and should be replaced with a new Hash of password.
+func (h *Hasher) Verify(hash, password string) (rehash bool, err error) {
+	encoder := h.encoderFor(hash)
+	if encoder == nil {
+		return false, ErrUnknownHash
+	}
+	if err := encoder.Verify(hash, password); err != nil {
+		return false, err
+	}
+	return !h.target().Current(hash), nil
+}
+
+// encoderFor returns the encoder that made hash, preferring the target, or nil if none did.
+func (h *Hasher) encoderFor(hash string) Encoder {
+	for _, encoder := range append([]Encoder{h.target()}, encoders...) {
+		for _, prefix := range encoder.Prefixes() {
+			if strings.HasPrefix(hash, prefix) {
+				return encoder
+			}
+		}
+	}
+	return nil
+}
+
+// target returns the target encoder, falling back to bcrypt.
+func (h *Hasher) target() Encoder {
+	if h == nil || h.Target == nil {
+		return Bcrypt{}
+	}
+	return h.Target
+}
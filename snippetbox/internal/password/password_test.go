@@ -0,0 +1,129 @@
+package password_test
+
+import (
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"snippetbox.audryhsu.com/internal/assert"
+	"snippetbox.audryhsu.com/internal/password"
+	"strings"
+	"testing"
+)
+
+// Small parameters keep the tests fast.
+var (
+	fastBcrypt = password.Bcrypt{Cost: bcrypt.MinCost}
+	fastArgon2 = password.Argon2id{Memory: 64, Iterations: 1, Parallelism: 1}
+)
+
+func TestEncoders(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoder password.Encoder
+		prefix  string
+		other   password.Encoder
+	}{
+		{name: "bcrypt", encoder: fastBcrypt, prefix: "$2a$04$", other: password.Bcrypt{Cost: bcrypt.MinCost + 1}},
+		{name: "argon2id", encoder: fastArgon2, prefix: "$argon2id$v=19$m=64,t=1,p=1$", other: password.Argon2id{Memory: 128, Iterations: 1, Parallelism: 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hash, err := test.encoder.Hash("pa$$word")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, strings.HasPrefix(hash, test.prefix), true)
+
+			// salted, so the same password hashes differently each time
+			again, err := test.encoder.Hash("pa$$word")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, again == hash, false)
+
+			assert.Equal(t, test.encoder.Verify(hash, "pa$$word"), nil)
+			assert.Equal(t, errors.Is(test.encoder.Verify(hash, "pa$$worD"), password.ErrMismatch), true)
+			// parameters come from the hash
+			assert.Equal(t, test.other.Verify(hash, "pa$$word"), nil)
+
+			assert.Equal(t, test.encoder.Current(hash), true)
+			assert.Equal(t, test.other.Current(hash), false)
+		})
+	}
+}
+
+func TestArgon2idMalformed(t *testing.T) {
+	hash, err := fastArgon2.Hash("pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		hash string
+	}{
+		{name: "argon2i", hash: strings.Replace(hash, "argon2id", "argon2i", 1)},
+		{name: "old version", hash: strings.Replace(hash, "v=19", "v=16", 1)},
+		{name: "missing parameter", hash: strings.Replace(hash, ",p=1", "", 1)},
+		{name: "zero parameter", hash: strings.Replace(hash, "t=1", "t=0", 1)},
+		{name: "extra parameter", hash: strings.Replace(hash, "p=1", "p=1,k=2", 1)},
+		{name: "bad salt", hash: strings.Replace(hash, "p=1$", "p=1$!", 1)},
+		{name: "missing key", hash: hash[:strings.LastIndex(hash, "$")]},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, errors.Is(fastArgon2.Verify(test.hash, "pa$$word"), password.ErrUnknownHash), true)
+			assert.Equal(t, fastArgon2.Current(test.hash), false)
+		})
+	}
+}
+
+func TestHasher(t *testing.T) {
+	bcryptHash, err := fastBcrypt.Hash("pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	argon2Hash, err := fastArgon2.Hash("pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		hasher     *password.Hasher
+		hash       string
+		password   string
+		wantRehash bool
+		wantErr    error
+	}{
+		{name: "current bcrypt", hasher: &password.Hasher{Target: fastBcrypt}, hash: bcryptHash, password: "pa$$word"},
+		{name: "bcrypt cost raised", hasher: &password.Hasher{Target: password.Bcrypt{Cost: bcrypt.MinCost + 1}}, hash: bcryptHash, password: "pa$$word", wantRehash: true},
+		{name: "bcrypt to argon2id", hasher: &password.Hasher{Target: fastArgon2}, hash: bcryptHash, password: "pa$$word", wantRehash: true},
+		{name: "argon2id to bcrypt", hasher: &password.Hasher{Target: fastBcrypt}, hash: argon2Hash, password: "pa$$word", wantRehash: true},
+		{name: "current argon2id", hasher: &password.Hasher{Target: fastArgon2}, hash: argon2Hash, password: "pa$$word"},
+		{name: "wrong password", hasher: &password.Hasher{Target: fastArgon2}, hash: bcryptHash, password: "wrong", wantErr: password.ErrMismatch},
+		{name: "unknown prefix", hasher: &password.Hasher{Target: fastBcrypt}, hash: "$1$abc$def", password: "pa$$word", wantErr: password.ErrUnknownHash},
+		{name: "zero hasher", hasher: &password.Hasher{}, hash: bcryptHash, password: "pa$$word", wantRehash: true},
+		{name: "nil hasher", hash: argon2Hash, password: "pa$$word", wantRehash: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rehash, err := test.hasher.Verify(test.hash, test.password)
+			assert.Equal(t, errors.Is(err, test.wantErr), true)
+			assert.Equal(t, rehash, test.wantRehash)
+		})
+	}
+
+	// the zero Hasher hashes with bcrypt at DefaultBcryptCost
+	var hasher password.Hasher
+	hash, err := hasher.Hash("pa$$word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, cost, password.DefaultBcryptCost)
+}
@@ -0,0 +1,658 @@
+package password
+
+import (
+	"bufio"
+	"embed"
+	"math"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Weakness is a pattern that makes a password easy to guess.
+type Weakness string
+
+const (
+	// WeaknessCommon is one of the most used passwords, e.g. "qwerty123".
+	WeaknessCommon Weakness = "common"
+	// WeaknessWord is an everyday word.
+	WeaknessWord Weakness = "word"
+	// WeaknessUserInput is part of the user's name or email address.
+	WeaknessUserInput Weakness = "user_input"
+	// WeaknessKeyboard is a row of neighbouring keys, e.g. "asdfgh".
+	WeaknessKeyboard Weakness = "keyboard"
+	// WeaknessRepeat is a repeated character or run, e.g. "aaa" or "abcabc".
+	WeaknessRepeat Weakness = "repeat"
+	// WeaknessSequence is a run of evenly spaced characters, e.g. "abcd" or "1357".
+	WeaknessSequence Weakness = "sequence"
+	// WeaknessDate is a date or a recent year.
+	WeaknessDate Weakness = "date"
+)
+
+// Strength is an estimate of how hard a password is to guess, in the style of Dropbox's zxcvbn.
+type Strength struct {
+	// Guesses is the estimated number of guesses an attacker needs.
+	Guesses float64
+	// Score rates Guesses from 0 (under a thousand) to 4 (over ten billion). 3 is a reasonable minimum for a website.
+	Score int
+	// Weakness is the pattern making up most of the password, or "" if it is mostly random characters.
+	Weakness Weakness
+}
+
+// maxEstimateLength caps how much of a password Estimate looks at, as the matching takes quadratic time. The rest is ignored.
+const maxEstimateLength = 100
+
+//go:embed dictionaries
+var dictionaryFiles embed.FS
+
+// The built-in dictionaries, ranked most common first: passwords map the most used passwords, and words everyday English words.
+var passwords, words = loadDictionary("passwords.txt"), loadDictionary("words.txt")
+
+// loadDictionary returns the rank of each word in an embedded dictionary, counting from 1.
+func loadDictionary(name string) map[string]int {
+	f, err := dictionaryFiles.Open("dictionaries/" + name)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if word := strings.TrimSpace(scanner.Text()); word != "" {
+			if _, ok := ranks[word]; !ok {
+				ranks[word] = len(ranks) + 1
+			}
+		}
+	}
+	return ranks
+}
+
+// Estimate rates how hard password is to guess. userInputs are things about the user an attacker could know, such as their name and email address; passwords containing them are weaker.
+//
+// Like zxcvbn, it looks for the patterns attackers try first (dictionary words, with capitals, l33t substitutions or reversed; keyboard rows; repeats; sequences; dates) and finds the combination of patterns and random characters covering the password that takes the fewest guesses.
+func Estimate(password string, userInputs ...string) Strength {
+	runes := []rune(password)
+	if len(runes) > maxEstimateLength {
+		runes = runes[:maxEstimateLength]
+	}
+	e := &estimator{user: userDictionary(userInputs), repeats: make(map[string]float64)}
+	logGuesses, sequence := e.mostGuessable(runes)
+
+	s := Strength{Guesses: math.Pow(10, logGuesses)}
+	for _, limit := range []float64{3, 6, 8, 10} {
+		if logGuesses >= limit {
+			s.Score++
+		}
+	}
+	// the longest pattern is the one to point out
+	longest := 0
+	for _, m := range sequence {
+		if m.weakness != "" && m.j-m.i+1 > longest {
+			s.Weakness, longest = m.weakness, m.j-m.i+1
+		}
+	}
+	return s
+}
+
+// userDictionary ranks the words in userInputs, e.g. "Alice Smith" and "alice@example.com" give alice, smith, example and com, as well as the inputs as a whole.
+func userDictionary(userInputs []string) map[string]int {
+	ranks := make(map[string]int)
+	add := func(word string) {
+		if _, ok := ranks[word]; !ok && len([]rune(word)) >= minDictionaryMatch {
+			ranks[word] = len(ranks) + 1
+		}
+	}
+	for _, input := range userInputs {
+		input = strings.ToLower(input)
+		add(input)
+		for _, word := range strings.FieldsFunc(input, func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) }) {
+			add(word)
+		}
+	}
+	return ranks
+}
+
+// A match is a pattern found in the password, covering runes i to j inclusive. Matches without a weakness are random characters.
+type match struct {
+	i, j       int
+	logGuesses float64
+	weakness   Weakness
+}
+
+// estimator holds the state of one Estimate.
+type estimator struct {
+	user map[string]int
+	// repeats caches the guesses of the repeated parts of repeat matches, which are estimated as passwords of their own
+	repeats map[string]float64
+}
+
+// mostGuessable returns log10 of the guesses needed for runes and the matches that take them, following zxcvbn's search.
+// An attacker who knows the password is made of l patterns has to try them in l! orders, and tries passwords of fewer patterns first, so a sequence of l matches takes l! times the product of their guesses, plus 10000^(l-1) for the shorter sequences.
+func (e *estimator) mostGuessable(runes []rune) (float64, []match) {
+	n := len(runes)
+	if n == 0 {
+		return 0, nil
+	}
+
+	byEnd := make([][]match, n)
+	for _, m := range e.matches(runes) {
+		byEnd[m.j] = append(byEnd[m.j], m)
+	}
+
+	// best[k][l] is the cheapest sequence of l matches covering runes 0 to k
+	type step struct {
+		logProduct float64
+		logTotal   float64
+		m          match
+	}
+	best := make([]map[int]step, n)
+	for k := range best {
+		best[k] = make(map[int]step)
+	}
+	update := func(m match, l int, logProduct float64) {
+		logGuesses := m.logGuesses
+		// patterns within a longer password count for at least a few guesses, so splitting the password into them isn't cheaper than it should be
+		if m.j-m.i+1 < n {
+			if m.i == m.j {
+				logGuesses = math.Max(logGuesses, 1)
+			} else {
+				logGuesses = math.Max(logGuesses, math.Log10(50))
+			}
+		}
+		logProduct += logGuesses
+		logTotal := logAdd(logFactorial(l)+logProduct, 4*float64(l-1))
+		for other, s := range best[m.j] {
+			if other <= l && s.logTotal <= logTotal {
+				return
+			}
+		}
+		best[m.j][l] = step{logProduct: logProduct, logTotal: logTotal, m: m}
+	}
+	extend := func(m match, random bool) {
+		if m.i == 0 {
+			update(m, 1, 0)
+			return
+		}
+		for l, s := range best[m.i-1] {
+			// two runs of random characters in a row are one run
+			if random && s.m.weakness == "" {
+				continue
+			}
+			update(m, l+1, s.logProduct)
+		}
+	}
+
+	for k := 0; k < n; k++ {
+		for _, m := range byEnd[k] {
+			extend(m, false)
+		}
+		for i := 0; i <= k; i++ {
+			extend(match{i: i, j: k, logGuesses: float64(k - i + 1)}, true)
+		}
+	}
+
+	bestL, logTotal := 0, math.Inf(1)
+	for l, s := range best[n-1] {
+		if s.logTotal < logTotal {
+			bestL, logTotal = l, s.logTotal
+		}
+	}
+	sequence := make([]match, bestL)
+	for k, l := n-1, bestL; l > 0; l-- {
+		m := best[k][l].m
+		sequence[l-1] = m
+		k = m.i - 1
+	}
+	return logTotal, sequence
+}
+
+// matches returns all the patterns found in runes.
+func (e *estimator) matches(runes []rune) []match {
+	var matches []match
+	matches = append(matches, e.dictionaryMatches(runes)...)
+	matches = append(matches, keyboardMatches(runes)...)
+	matches = append(matches, e.repeatMatches(runes)...)
+	matches = append(matches, sequenceMatches(runes)...)
+	matches = append(matches, dateMatches(runes)...)
+	return matches
+}
+
+const (
+	// minDictionaryMatch is the shortest word looked up in the dictionaries; shorter ones are as quick to guess as random characters.
+	minDictionaryMatch = 3
+	// maxDictionaryMatch is the longest word looked up.
+	maxDictionaryMatch = 40
+)
+
+// l33t maps characters commonly substituted for letters to the letters. Some stand for two letters; the second is in l33tAlt.
+var (
+	l33t    = map[rune]rune{'4': 'a', '@': 'a', '8': 'b', '(': 'c', '3': 'e', '6': 'g', '9': 'g', '1': 'i', '!': 'i', '|': 'i', '0': 'o', '$': 's', '5': 's', '7': 't', '+': 't', '%': 'x', '2': 'z'}
+	l33tAlt = map[rune]rune{'1': 'l', '|': 'l', '7': 'l'}
+)
+
+// dictionaryMatches finds words from the dictionaries, as they are, reversed or with l33t substitutions, in any mix of upper and lower case.
+func (e *estimator) dictionaryMatches(runes []rune) []match {
+	dictionaries := []struct {
+		ranks    map[string]int
+		weakness Weakness
+	}{
+		{passwords, WeaknessCommon},
+		{e.user, WeaknessUserInput},
+		{words, WeaknessWord},
+	}
+
+	var matches []match
+	for i := range runes {
+		for j := i + minDictionaryMatch - 1; j < len(runes) && j-i < maxDictionaryMatch; j++ {
+			token := runes[i : j+1]
+			lower := []rune(strings.ToLower(string(token)))
+			caseGuesses := uppercaseVariations(token)
+
+			// each candidate is a way of reading the token and how many variations of the word an attacker tries to find it that way
+			type candidate struct {
+				word       string
+				variations float64
+			}
+			candidates := []candidate{{string(lower), 1}, {string(reversed(lower)), 2}}
+			for _, table := range []map[rune]rune{l33t, l33tAlt} {
+				if word, subs := unl33t(lower, table); subs != nil {
+					candidates = append(candidates, candidate{word, l33tVariations(lower, subs)})
+				}
+			}
+
+			for _, dictionary := range dictionaries {
+				best := math.Inf(1)
+				for _, c := range candidates {
+					if rank, ok := dictionary.ranks[c.word]; ok {
+						best = math.Min(best, float64(rank)*c.variations)
+					}
+				}
+				if !math.IsInf(best, 1) {
+					matches = append(matches, match{i: i, j: j, logGuesses: math.Log10(best * caseGuesses), weakness: dictionary.weakness})
+				}
+			}
+		}
+	}
+	return matches
+}
+
+// uppercaseVariations is the number of ways of capitalising a word an attacker tries before getting to token's: all lower case is the first, capitalising the first or last letter or all of them the next, and then every mix with as many capitals.
+func uppercaseVariations(token []rune) float64 {
+	var upper, lower int
+	for _, r := range token {
+		if unicode.IsUpper(r) {
+			upper++
+		} else if unicode.IsLower(r) {
+			lower++
+		}
+	}
+	if upper == 0 {
+		return 1
+	}
+	if lower == 0 || (upper == 1 && (unicode.IsUpper(token[0]) || unicode.IsUpper(token[len(token)-1]))) {
+		return 2
+	}
+	variations := 0.0
+	for k := 1; k <= upper && k <= lower; k++ {
+		variations += binomial(upper+lower, k)
+	}
+	return variations
+}
+
+// unl33t undoes the substitutions in table and returns the word, and the substitutions made (substitute => letter), or nil if there were none.
+func unl33t(token []rune, table map[rune]rune) (string, map[rune]rune) {
+	var subs map[rune]rune
+	word := make([]rune, len(token))
+	for k, r := range token {
+		if letter, ok := table[r]; ok {
+			if subs == nil {
+				subs = make(map[rune]rune)
+			}
+			subs[r] = letter
+			r = letter
+		}
+		word[k] = r
+	}
+	return string(word), subs
+}
+
+// l33tVariations is the number of ways of substituting a word an attacker tries before getting to token's, counted like uppercaseVariations for each substitution.
+func l33tVariations(token []rune, subs map[rune]rune) float64 {
+	variations := 1.0
+	for sub, letter := range subs {
+		var subbed, unsubbed int
+		for _, r := range token {
+			switch r {
+			case sub:
+				subbed++
+			case letter:
+				unsubbed++
+			}
+		}
+		if unsubbed == 0 {
+			variations *= 2
+			continue
+		}
+		v := 0.0
+		for k := 1; k <= subbed && k <= unsubbed; k++ {
+			v += binomial(subbed+unsubbed, k)
+		}
+		variations *= v
+	}
+	return variations
+}
+
+// keyboardRows are the rows of a US keyboard, unshifted and shifted.
+var keyboardRows = []string{
+	"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./",
+	"~!@#$%^&*()_+", "QWERTYUIOP{}|", "ASDFGHJKL:\"", "ZXCVBNM<>?",
+}
+
+// keyboardMatches finds runs of four or more neighbouring keys along a keyboard row, in either direction and possibly turning back, e.g. "qwerty" or "asdfdsa".
+func keyboardMatches(runes []rune) []match {
+	// keys holds each key's row and column
+	type key struct{ row, col int }
+	keys := make(map[rune]key)
+	for row, keysInRow := range keyboardRows {
+		for col, r := range keysInRow {
+			keys[r] = key{row, col}
+		}
+	}
+	adjacent := func(a, b rune) (direction int, ok bool) {
+		ka, oka := keys[a]
+		kb, okb := keys[b]
+		if !oka || !okb || ka.row%4 != kb.row%4 || (kb.col-ka.col != 1 && ka.col-kb.col != 1) {
+			return 0, false
+		}
+		return kb.col - ka.col, true
+	}
+
+	var matches []match
+	for i := 0; i < len(runes)-1; {
+		j, turns, direction := i, 0, 0
+		for j+1 < len(runes) {
+			d, ok := adjacent(runes[j], runes[j+1])
+			if !ok {
+				break
+			}
+			if direction != 0 && d != direction {
+				turns++
+			}
+			direction = d
+			j++
+		}
+		if j-i+1 >= 4 {
+			shifted := 0
+			for _, r := range runes[i : j+1] {
+				if keys[r].row >= 4 {
+					shifted++
+				}
+			}
+			matches = append(matches, match{i: i, j: j, logGuesses: math.Log10(keyboardGuesses(j-i+1, turns+1, shifted)), weakness: WeaknessKeyboard})
+		}
+		if j > i {
+			i = j
+		} else {
+			i++
+		}
+	}
+	return matches
+}
+
+// keyboardGuesses counts the runs along keyboard rows with up to turns changes of direction (counting the start as one) and length up to length, as zxcvbn does for its keyboard graphs. Mixing shifted and unshifted keys multiplies the count like capitals do.
+func keyboardGuesses(length, turns, shifted int) float64 {
+	const startingKeys, averageNeighbours = 94, 4.6
+	guesses := 0.0
+	for i := 2; i <= length; i++ {
+		for j := 1; j <= turns && j <= i-1; j++ {
+			guesses += binomial(i-1, j-1) * startingKeys * math.Pow(averageNeighbours, float64(j))
+		}
+	}
+	switch unshifted := length - shifted; {
+	case shifted == 0:
+	case unshifted == 0:
+		guesses *= 2
+	default:
+		v := 0.0
+		for k := 1; k <= shifted && k <= unshifted; k++ {
+			v += binomial(length, k)
+		}
+		guesses *= v
+	}
+	return guesses
+}
+
+// repeatMatches finds a character or run repeated at least twice in a row, e.g. "aaa" or "abcabc". They take as many guesses as the repeated run, times the number of repeats.
+func (e *estimator) repeatMatches(runes []rune) []match {
+	var matches []match
+	for i := 0; i < len(runes); {
+		bestLength, bestCount := 0, 0
+		for length := 1; i+2*length <= len(runes); length++ {
+			count := 1
+			for i+(count+1)*length <= len(runes) && string(runes[i+count*length:i+(count+1)*length]) == string(runes[i:i+length]) {
+				count++
+			}
+			// prefer covering more, then the shorter base
+			if count >= 2 && count*length > bestCount*bestLength {
+				bestLength, bestCount = length, count
+			}
+		}
+		if bestCount == 0 {
+			i++
+			continue
+		}
+
+		base := string(runes[i : i+bestLength])
+		logBase, ok := e.repeats[base]
+		if !ok {
+			logBase, _ = e.mostGuessable(runes[i : i+bestLength])
+			e.repeats[base] = logBase
+		}
+		j := i + bestLength*bestCount - 1
+		matches = append(matches, match{i: i, j: j, logGuesses: logBase + math.Log10(float64(bestCount)), weakness: WeaknessRepeat})
+		i = j + 1
+	}
+	return matches
+}
+
+// sequenceMatches finds runs of three or more characters with the same step between them, up or down, e.g. "abcd", "1357" or "zyx".
+func sequenceMatches(runes []rune) []match {
+	var matches []match
+	add := func(i, j int, delta rune) {
+		if j-i+1 < 3 || delta == 0 || delta > 5 || delta < -5 {
+			return
+		}
+		first := runes[i]
+		var base float64
+		switch {
+		case strings.ContainsRune("aAzZ019", first):
+			// the obvious starting points
+			base = 4
+		case unicode.IsDigit(first):
+			base = 10
+		default:
+			base = 26
+		}
+		if delta < 0 {
+			base *= 2
+		}
+		matches = append(matches, match{i: i, j: j, logGuesses: math.Log10(base * float64(j-i+1)), weakness: WeaknessSequence})
+	}
+
+	i, lastDelta := 0, rune(0)
+	for k := 1; k < len(runes); k++ {
+		delta := runes[k] - runes[k-1]
+		if !sameClass(runes[k-1], runes[k]) {
+			// never part of a sequence
+			delta = 0
+		}
+		if k-1 == i {
+			lastDelta = delta
+			continue
+		}
+		if delta != lastDelta {
+			add(i, k-1, lastDelta)
+			// the last character of a sequence can start the next one
+			i, lastDelta = k-1, delta
+		}
+	}
+	if len(runes) > 1 {
+		add(i, len(runes)-1, lastDelta)
+	}
+	return matches
+}
+
+// sameClass reports whether a and b are both lower case letters, both capitals or both digits, which is what sequences are made of.
+func sameClass(a, b rune) bool {
+	class := func(r rune) int {
+		switch {
+		case unicode.IsLower(r):
+			return 1
+		case unicode.IsUpper(r):
+			return 2
+		case unicode.IsDigit(r):
+			return 3
+		}
+		return 0
+	}
+	return class(a) != 0 && class(a) == class(b)
+}
+
+// minYearSpace is the fewest years an attacker is assumed to try around the current one.
+const minYearSpace = 20
+
+// dateMatches finds years from 1900 to 2049 and dates of days, months and years, with or without separators, e.g. "1987", "13/6/87" or "19870613".
+func dateMatches(runes []rune) []match {
+	now := time.Now().Year()
+	yearSpace := func(year int) float64 {
+		return math.Max(math.Abs(float64(year-now)), minYearSpace)
+	}
+
+	var matches []match
+	for i := range runes {
+		for j := i + 3; j < len(runes) && j-i < 10; j++ {
+			token := string(runes[i : j+1])
+			if year, ok := parseYear(token); ok {
+				matches = append(matches, match{i: i, j: j, logGuesses: math.Log10(yearSpace(year)), weakness: WeaknessDate})
+				continue
+			}
+			if year, separated, ok := parseDate(token); ok {
+				guesses := yearSpace(year) * 365
+				if separated {
+					guesses *= 4
+				}
+				matches = append(matches, match{i: i, j: j, logGuesses: math.Log10(guesses), weakness: WeaknessDate})
+			}
+		}
+	}
+	return matches
+}
+
+// parseYear reads a four digit year from 1900 to 2049.
+func parseYear(s string) (int, bool) {
+	if len(s) != 4 || !allDigits(s) {
+		return 0, false
+	}
+	year := atoi(s)
+	return year, year >= 1900 && year <= 2049
+}
+
+// parseDate reads a date as day, month and year in any of the usual orders, with the year in two or four digits, either all digits ("130687") or with the same separator between the parts ("13.6.1987"). It returns the year and whether there were separators.
+func parseDate(s string) (year int, separated bool, ok bool) {
+	var parts []string
+	for _, sep := range " -./\\_" {
+		if p := strings.Split(s, string(sep)); len(p) == 3 {
+			parts, separated = p, true
+			break
+		}
+	}
+	if parts == nil {
+		if len(s) < 4 || len(s) > 8 || !allDigits(s) {
+			return 0, false, false
+		}
+		// try every split into three parts
+		for a := 1; a < len(s)-1; a++ {
+			for b := a + 1; b < len(s); b++ {
+				if year, ok := dayMonthYear(s[:a], s[a:b], s[b:]); ok {
+					return year, false, true
+				}
+			}
+		}
+		return 0, false, false
+	}
+	year, ok = dayMonthYear(parts[0], parts[1], parts[2])
+	return year, separated, ok
+}
+
+// dayMonthYear checks whether three parts make a date as year-month-day, day-month-year or month-day-year, and returns its year.
+func dayMonthYear(a, b, c string) (int, bool) {
+	for _, order := range []struct{ day, month, year string }{{c, b, a}, {a, b, c}, {b, a, c}} {
+		day, month, year := order.day, order.month, order.year
+		if len(day) > 2 || len(month) > 2 || (len(year) != 2 && len(year) != 4) || !allDigits(day+month+year) {
+			continue
+		}
+		d, m, y := atoi(day), atoi(month), atoi(year)
+		if len(year) == 2 {
+			// two digit years are read as the nearest one
+			if y > 50 {
+				y += 1900
+			} else {
+				y += 2000
+			}
+		}
+		if d >= 1 && d <= 31 && m >= 1 && m <= 12 && y >= 1900 && y <= 2049 {
+			return y, true
+		}
+	}
+	return 0, false
+}
+
+func allDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// atoi converts a string of digits, already checked by allDigits.
+func atoi(s string) int {
+	n := 0
+	for _, r := range s {
+		n = n*10 + int(r-'0')
+	}
+	return n
+}
+
+func reversed(runes []rune) []rune {
+	r := make([]rune, len(runes))
+	for k, c := range runes {
+		r[len(runes)-1-k] = c
+	}
+	return r
+}
+
+// binomial returns n choose k.
+func binomial(n, k int) float64 {
+	result := 1.0
+	for i := 1; i <= k; i++ {
+		result = result * float64(n-k+i) / float64(i)
+	}
+	return result
+}
+
+// logFactorial returns log10(n!).
+func logFactorial(n int) float64 {
+	lg, _ := math.Lgamma(float64(n + 1))
+	return lg / math.Ln10
+}
+
+// logAdd returns log10(10^a + 10^b) without overflowing.
+func logAdd(a, b float64) float64 {
+	if a < b {
+		a, b = b, a
+	}
+	return a + math.Log10(1+math.Pow(10, b-a))
+}
@@ -0,0 +1,53 @@
+package password_test
+
+import (
+	"snippetbox.audryhsu.com/internal/assert"
+	"snippetbox.audryhsu.com/internal/password"
+	"testing"
+)
+
+func TestEstimate(t *testing.T) {
+	tests := []struct {
+		name         string
+		password     string
+		wantScore    int
+		wantWeakness password.Weakness
+	}{
+		{name: "Empty", password: "", wantScore: 0},
+		{name: "Common", password: "password", wantScore: 0, wantWeakness: password.WeaknessCommon},
+		{name: "Common with capital and digit", password: "Password1", wantScore: 0, wantWeakness: password.WeaknessCommon},
+		{name: "Common l33t", password: "p@ssw0rd", wantScore: 0, wantWeakness: password.WeaknessCommon},
+		{name: "Common reversed", password: "drowssap", wantScore: 0, wantWeakness: password.WeaknessCommon},
+		{name: "Word", password: "mountain", wantScore: 0, wantWeakness: password.WeaknessWord},
+		{name: "Name", password: "alice", wantScore: 0, wantWeakness: password.WeaknessUserInput},
+		{name: "Email with year", password: "alice@example.com1987", wantScore: 1, wantWeakness: password.WeaknessUserInput},
+		{name: "Keyboard", password: "zxcvbnm,./", wantScore: 1, wantWeakness: password.WeaknessKeyboard},
+		{name: "Keyboard with turns", password: "asdfdsa", wantScore: 1, wantWeakness: password.WeaknessKeyboard},
+		{name: "Repeat", password: "aaaaaaaaaaaa", wantScore: 0, wantWeakness: password.WeaknessRepeat},
+		{name: "Repeated run", password: "xyz!xyz!xyz!", wantScore: 1, wantWeakness: password.WeaknessRepeat},
+		{name: "Sequence", password: "abcdefgh", wantScore: 0, wantWeakness: password.WeaknessSequence},
+		{name: "Odd digits", password: "13579", wantScore: 0, wantWeakness: password.WeaknessSequence},
+		{name: "Date", password: "13/06/1987", wantScore: 1, wantWeakness: password.WeaknessDate},
+		{name: "Date without separators", password: "19870613", wantScore: 1, wantWeakness: password.WeaknessDate},
+		{name: "Random", password: "kX9#mQ2$vL", wantScore: 4},
+		{name: "Passphrase", password: "correct horse battery staple", wantScore: 4, wantWeakness: password.WeaknessWord},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := password.Estimate(test.password, "Alice", "alice@example.com")
+			assert.Equal(t, s.Score, test.wantScore)
+			assert.Equal(t, s.Weakness, test.wantWeakness)
+		})
+	}
+}
+
+func TestEstimateLongPassword(t *testing.T) {
+	long := ""
+	for len(long) < 10000 {
+		long += "kX9#mQ2$vL"
+	}
+	// only the start is looked at, where the repeats don't yet outweigh the randomness
+	s := password.Estimate(long)
+	assert.Equal(t, s.Score, 4)
+}
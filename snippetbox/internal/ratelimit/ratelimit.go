@@ -0,0 +1,138 @@
+// Package ratelimit limits how often something can be done, with a token bucket for each key, such as a client's IP address.
+//
+// A bucket holds up to Burst tokens and refills at Rate tokens a second. Each request takes a token and is refused when there are none left, so a client can make Burst requests at once but no more than Rate a second in the long run.
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit is the rate and burst size of a Limiter.
+type Limit struct {
+	// Rate is the number of requests allowed per second in the long run.
+	Rate float64
+	// Burst is the number of requests allowed at once.
+	Burst int
+}
+
+// Per returns the Limit of n requests per period: n can be made at once, and they are allowed again one by one over the period.
+func Per(n int, period time.Duration) Limit {
+	return Limit{Rate: float64(n) / period.Seconds(), Burst: n}
+}
+
+// Parse parses a Limit written as "<n>/<period>", e.g. "10/1m" or "10/m" for Per(10, time.Minute). The period is a time.Duration, whose number may be left out if it is 1.
+func Parse(s string) (Limit, error) {
+	count, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("ratelimit: %q is not of the form <n>/<period>", s)
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n < 1 {
+		return Limit{}, fmt.Errorf("ratelimit: %q must start with a positive number of requests", s)
+	}
+	if period != "" && (period[0] < '0' || period[0] > '9') {
+		period = "1" + period
+	}
+	d, err := time.ParseDuration(period)
+	if err != nil || d <= 0 {
+		return Limit{}, fmt.Errorf("ratelimit: %q must end with a positive period, such as 1m", s)
+	}
+	return Per(n, d), nil
+}
+
+// Limiter keeps a token bucket for each key. It is safe for concurrent use.
+// Buckets are made on first use, so the number of keys, and the memory used, is up to the clients. Cleanup (or StartCleanup) removes the buckets of clients that have gone quiet.
+type Limiter struct {
+	limit Limit
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// bucket is the token bucket of one key, as of last.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New returns a Limiter that allows each key requests at limit, whose rate and burst must be positive.
+func New(limit Limit) *Limiter {
+	return &Limiter{limit: limit, buckets: make(map[string]*bucket)}
+}
+
+// Allow takes a token from key's bucket, and reports whether there was one. If there wasn't, the request should be refused, and retryAfter is how long until there will be.
+func (l *Limiter) Allow(key string) (ok bool, retryAfter time.Duration) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, found := l.buckets[key]
+	if !found {
+		b = &bucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = l.tokensAt(b, now)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+}
+
+// Cleanup removes the buckets that have refilled, which are no different from the new buckets that would replace them, and returns the number left.
+func (l *Limiter) Cleanup() int {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key, b := range l.buckets {
+		if l.tokensAt(b, now) >= float64(l.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	return len(l.buckets)
+}
+
+// StartCleanup calls Cleanup every interval in a new goroutine, until stop is called.
+func (l *Limiter) StartCleanup(interval time.Duration) (stop func()) {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ticker.C:
+				l.Cleanup()
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			ticker.Stop()
+			close(done)
+		})
+	}
+}
+
+// Len returns the number of buckets.
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+// tokensAt returns the number of tokens in b at now, once it has refilled since b.last. The caller holds l.mu.
+func (l *Limiter) tokensAt(b *bucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.last).Seconds()*l.limit.Rate
+	if tokens > float64(l.limit.Burst) {
+		return float64(l.limit.Burst)
+	}
+	return tokens
+}
@@ -0,0 +1,85 @@
+package ratelimit_test
+
+import (
+	"snippetbox.audryhsu.com/internal/assert"
+	"snippetbox.audryhsu.com/internal/ratelimit"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		limit   string
+		want    ratelimit.Limit
+		wantErr bool
+	}{
+		{limit: "10/1m", want: ratelimit.Limit{Rate: 10.0 / 60, Burst: 10}},
+		{limit: "10/m", want: ratelimit.Limit{Rate: 10.0 / 60, Burst: 10}},
+		{limit: "3/h", want: ratelimit.Limit{Rate: 3.0 / 3600, Burst: 3}},
+		{limit: "100/10s", want: ratelimit.Limit{Rate: 10, Burst: 100}},
+		{limit: "10", wantErr: true},
+		{limit: "0/m", wantErr: true},
+		{limit: "-1/m", wantErr: true},
+		{limit: "ten/m", wantErr: true},
+		{limit: "10/", wantErr: true},
+		{limit: "10/0s", wantErr: true},
+		{limit: "10/-1m", wantErr: true},
+		{limit: "10/fortnight", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.limit, func(t *testing.T) {
+			limit, err := ratelimit.Parse(test.limit)
+			assert.Equal(t, err != nil, test.wantErr)
+			assert.Equal(t, limit, test.want)
+		})
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	// a burst of 3, then one request every 100ms
+	l := ratelimit.New(ratelimit.Per(3, 300*time.Millisecond))
+
+	for i := 0; i < 3; i++ {
+		ok, retryAfter := l.Allow("alice")
+		assert.Equal(t, ok, true)
+		assert.Equal(t, retryAfter, time.Duration(0))
+	}
+	ok, retryAfter := l.Allow("alice")
+	assert.Equal(t, ok, false)
+	assert.Equal(t, retryAfter > 90*time.Millisecond && retryAfter <= 100*time.Millisecond, true)
+
+	// each key has a bucket of its own
+	ok, _ = l.Allow("bob")
+	assert.Equal(t, ok, true)
+
+	// refused requests don't take tokens, so one is back after retryAfter
+	time.Sleep(retryAfter)
+	ok, _ = l.Allow("alice")
+	assert.Equal(t, ok, true)
+	ok, _ = l.Allow("alice")
+	assert.Equal(t, ok, false)
+}
+
+func TestLimiterCleanup(t *testing.T) {
+	l := ratelimit.New(ratelimit.Per(2, 100*time.Millisecond))
+	l.Allow("alice")
+	l.Allow("alice")
+	l.Allow("bob")
+	assert.Equal(t, l.Len(), 2)
+
+	// bob's bucket is full again after 50ms, alice's after 100ms
+	time.Sleep(60 * time.Millisecond)
+	assert.Equal(t, l.Cleanup(), 1)
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, l.Cleanup(), 0)
+
+	// in the background
+	stop := l.StartCleanup(20 * time.Millisecond)
+	defer stop()
+	l.Allow("alice")
+	assert.Equal(t, l.Len(), 1)
+	time.Sleep(150 * time.Millisecond)
+	assert.Equal(t, l.Len(), 0)
+	stop()
+}
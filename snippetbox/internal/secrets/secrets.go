@@ -0,0 +1,152 @@
+// Package secrets finds credentials, such as cloud access keys, private keys and API tokens, pasted into text.
+//
+// A Scanner runs a list of Rules over the text. The built-in rules (see DefaultRules) match well-known key formats with regular expressions, plus a catch-all that flags long random-looking strings by their entropy.
+// Findings only hold where a secret is and which rule found it, never the secret itself, so they are safe to log.
+package secrets
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// A Rule finds one kind of secret.
+type Rule interface {
+	// Name identifies the rule in findings and logs, e.g. "aws_access_key".
+	Name() string
+	// Find returns the byte ranges {start, end} of the secrets in text, like regexp's FindAllStringIndex.
+	Find(text string) [][]int
+}
+
+// Finding is a secret found in a text: bytes [Start, End) on line Line (counting from 1).
+type Finding struct {
+	Rule  string
+	Start int
+	End   int
+	Line  int
+}
+
+// Scanner looks for secrets with a list of rules. The zero Scanner finds nothing.
+type Scanner struct {
+	Rules []Rule
+}
+
+// New returns a Scanner using rules, or DefaultRules if none are given.
+func New(rules ...Rule) *Scanner {
+	if len(rules) == 0 {
+		rules = DefaultRules()
+	}
+	return &Scanner{Rules: rules}
+}
+
+// Scan returns the secrets in text, in the order they appear. Where rules overlap the findings are merged into one, named after the rule listed first, so put specific rules before catch-alls.
+func (s *Scanner) Scan(text string) []Finding {
+	var findings []Finding
+	for _, rule := range s.Rules {
+		for _, loc := range rule.Find(text) {
+			findings = append(findings, Finding{Rule: rule.Name(), Start: loc[0], End: loc[1]})
+		}
+	}
+	// stable, so a tie goes to the earlier rule
+	sort.SliceStable(findings, func(i, j int) bool { return findings[i].Start < findings[j].Start })
+
+	var merged []Finding
+	for _, f := range findings {
+		if n := len(merged); n > 0 && f.Start < merged[n-1].End {
+			if f.End > merged[n-1].End {
+				merged[n-1].End = f.End
+			}
+			continue
+		}
+		f.Line = strings.Count(text[:f.Start], "\n") + 1
+		merged = append(merged, f)
+	}
+	return merged
+}
+
+// Redact replaces each finding in text with "[REDACTED]". findings must come from Scan(text).
+func Redact(text string, findings []Finding) string {
+	var b strings.Builder
+	last := 0
+	for _, f := range findings {
+		b.WriteString(text[last:f.Start])
+		b.WriteString("[REDACTED]")
+		last = f.End
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// RegexRule finds the secrets matching a regular expression.
+type RegexRule struct {
+	ID      string
+	Pattern *regexp.Regexp
+}
+
+func (r RegexRule) Name() string { return r.ID }
+
+func (r RegexRule) Find(text string) [][]int {
+	return r.Pattern.FindAllStringIndex(text, -1)
+}
+
+// EntropyRule finds random-looking strings: runs of at least MinLength token characters (letters, digits and +/=_-) mixing letters and digits, whose Shannon entropy is at least MinEntropy bits per character.
+// Words, identifiers and paths rarely get above 3.5 bits per character; random base64 of 20 or more characters is usually above 4.
+type EntropyRule struct {
+	ID         string
+	MinLength  int
+	MinEntropy float64
+}
+
+// tokenRX matches the runs of characters that keys and tokens are usually made of.
+var tokenRX = regexp.MustCompile(`[A-Za-z0-9+/=_\-]+`)
+
+func (r EntropyRule) Name() string { return r.ID }
+
+func (r EntropyRule) Find(text string) [][]int {
+	var found [][]int
+	for _, loc := range tokenRX.FindAllStringIndex(text, -1) {
+		token := text[loc[0]:loc[1]]
+		if len(token) >= r.MinLength && strings.ContainsAny(token, "0123456789") && strings.IndexFunc(token, isLetter) >= 0 && Entropy(token) >= r.MinEntropy {
+			found = append(found, loc)
+		}
+	}
+	return found
+}
+
+func isLetter(r rune) bool {
+	return 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z'
+}
+
+// Entropy returns the Shannon entropy of s in bits per byte.
+func Entropy(s string) float64 {
+	if s == "" {
+		return 0
+	}
+	var counts [256]int
+	for i := 0; i < len(s); i++ {
+		counts[s[i]]++
+	}
+	var h float64
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / float64(len(s))
+			h -= p * math.Log2(p)
+		}
+	}
+	return h
+}
+
+// DefaultRules returns the built-in rules: AWS access key ids, PEM private keys, GitHub tokens, JSON Web Tokens, and a catch-all for high-entropy strings.
+func DefaultRules() []Rule {
+	return []Rule{
+		// long-term (AKIA) and temporary (ASIA) access key ids
+		RegexRule{ID: "aws_access_key", Pattern: regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+		// the whole key when it is complete, or else from the header on
+		RegexRule{ID: "private_key", Pattern: regexp.MustCompile(`-----BEGIN[A-Z ]*PRIVATE KEY(?: BLOCK)?-----(?:[\s\S]*?-----END[A-Z ]*PRIVATE KEY(?: BLOCK)?-----|[\s\S]*)`)},
+		// classic, OAuth, user-to-server, server-to-server and refresh tokens, and fine-grained personal access tokens
+		RegexRule{ID: "github_token", Pattern: regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,255}|github_pat_[A-Za-z0-9_]{22,255})\b`)},
+		RegexRule{ID: "jwt", Pattern: regexp.MustCompile(`\beyJ[A-Za-z0-9_\-]+\.eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]+`)},
+		EntropyRule{ID: "high_entropy", MinLength: 20, MinEntropy: 4},
+	}
+}
@@ -0,0 +1,149 @@
+package validator
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// A Rule checks a single form field. value is the field's value and param the text after "=" in the tag (e.g. "100" in `validate:"max=100"`, empty for rules without one).
+// It returns ok == false and the error to show if the value breaks the rule.
+type Rule func(value any, param string) (msg Message, ok bool)
+
+var (
+	rulesMu sync.RWMutex
+	rules   = map[string]Rule{
+		"required": required,
+		"max":      maxRule,
+		"min":      minRule,
+		"oneof":    oneOf,
+		"email":    email,
+	}
+)
+
+// RegisterRule makes a custom rule available to `validate` tags under name, replacing any rule already registered under it. Register rules at start up, before any forms are validated.
+func RegisterRule(name string, rule Rule) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	rules[name] = rule
+}
+
+// lookupRule returns the rule registered under name.
+func lookupRule(name string) (Rule, bool) {
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+	rule, ok := rules[name]
+	return rule, ok
+}
+
+// Validate checks the fields of form (a struct, or a pointer to one) against the rules in their `validate` tags, e.g. `validate:"required,max=100"`, and adds an error to FieldErrors for each field that breaks one.
+// Fields are checked in the order they are declared, and each field's rules in the order they are listed; a field's first broken rule is the one reported. FieldErrors are keyed by the field's `form` tag name, or its lower-cased name without one.
+// Tags naming an unregistered rule, or with a parameter the rule can't use, are programming errors and panic, like passing form something other than a struct.
+func (v *Validator) Validate(form any) {
+	rv := reflect.Indirect(reflect.ValueOf(form))
+	if rv.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("validator: Validate needs a struct, got %T", form))
+	}
+
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" || tag == "-" || !field.IsExported() {
+			continue
+		}
+
+		key := fieldKey(field)
+		value := rv.Field(i).Interface()
+		for _, spec := range strings.Split(tag, ",") {
+			name, param, _ := strings.Cut(strings.TrimSpace(spec), "=")
+			rule, ok := lookupRule(name)
+			if !ok {
+				panic(fmt.Sprintf("validator: unknown rule %q on field %s.%s", name, rt.Name(), field.Name))
+			}
+			if msg, ok := rule(value, param); !ok {
+				v.AddFieldError(key, msg)
+				break
+			}
+		}
+	}
+}
+
+// fieldKey returns the FieldErrors key of a struct field: the name in its `form` tag, so errors line up with the form's inputs, or else its lower-cased name.
+func fieldKey(field reflect.StructField) string {
+	if name, _, _ := strings.Cut(field.Tag.Get("form"), ","); name != "" && name != "-" {
+		return name
+	}
+	return strings.ToLower(field.Name)
+}
+
+// required fails strings that are blank (only whitespace counts as blank too) and other values that are their type's zero value.
+func required(value any, _ string) (Message, bool) {
+	if s, ok := value.(string); ok {
+		return Msg("validation.blank"), strings.TrimSpace(s) != ""
+	}
+	return Msg("validation.blank"), !reflect.ValueOf(value).IsZero()
+}
+
+// maxRule limits strings to at most param characters, and numbers to at most param.
+func maxRule(value any, param string) (Message, bool) {
+	n := intParam("max", param)
+	if s, ok := value.(string); ok {
+		return Msg("validation.max_chars", "max", n), utf8.RuneCountInString(s) <= n
+	}
+	return Msg("validation.max_value", "max", n), number("max", value) <= float64(n)
+}
+
+// minRule requires strings to have at least param characters, and numbers to be at least param.
+func minRule(value any, param string) (Message, bool) {
+	n := intParam("min", param)
+	if s, ok := value.(string); ok {
+		return Msg("validation.min_chars", "min", n), utf8.RuneCountInString(s) >= n
+	}
+	return Msg("validation.min_value", "min", n), number("min", value) >= float64(n)
+}
+
+// oneOf requires the value to be one of the space-separated values in param, e.g. `validate:"oneof=1 7 365"`.
+func oneOf(value any, param string) (Message, bool) {
+	permitted := strings.Fields(param)
+	if len(permitted) == 0 {
+		panic("validator: oneof needs a list of values")
+	}
+	msg := Msg("validation.permitted", "values", strings.Join(permitted, ", "))
+	return msg, PermittedValue(fmt.Sprint(value), permitted...)
+}
+
+// email requires a string that looks like an email address (see EmailRX).
+func email(value any, _ string) (Message, bool) {
+	s, ok := value.(string)
+	if !ok {
+		panic(fmt.Sprintf("validator: email needs a string, got %T", value))
+	}
+	return Msg("validation.email"), EmailRX.MatchString(s)
+}
+
+// intParam parses the number in a max=n or min=n tag.
+func intParam(rule, param string) int {
+	n, err := strconv.Atoi(param)
+	if err != nil {
+		panic(fmt.Sprintf("validator: %s needs a number, got %q", rule, param))
+	}
+	return n
+}
+
+// number returns the value of an int, uint or float field for the min and max rules.
+func number(rule string, value any) float64 {
+	rv := reflect.ValueOf(value)
+	switch {
+	case rv.CanInt():
+		return float64(rv.Int())
+	case rv.CanUint():
+		return float64(rv.Uint())
+	case rv.CanFloat():
+		return rv.Float()
+	}
+	panic(fmt.Sprintf("validator: %s needs a string or number, got %T", rule, value))
+}
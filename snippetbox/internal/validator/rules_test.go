@@ -0,0 +1,142 @@
+package validator_test
+
+import (
+	"snippetbox.audryhsu.com/internal/assert"
+	"snippetbox.audryhsu.com/internal/validator"
+	"strings"
+	"testing"
+)
+
+type testForm struct {
+	Title               string `form:"title" validate:"required,max=10"`
+	Password            string `form:"password" validate:"required,min=8"`
+	Email               string `form:"email" validate:"required,email"`
+	Expires             int    `form:"expires" validate:"oneof=1 7 365"`
+	Count               int    `validate:"min=1,max=5"`
+	Notes               string
+	validator.Validator `form:"-"`
+}
+
+func validForm() testForm {
+	return testForm{Title: "Title", Password: "pa$$word", Email: "alice@example.com", Expires: 7, Count: 3}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(f *testForm)
+		field  string
+		want   validator.Message
+	}{
+		{
+			name:   "Valid",
+			modify: func(f *testForm) {},
+		},
+		{
+			name:   "Blank",
+			modify: func(f *testForm) { f.Title = "   " },
+			field:  "title",
+			want:   validator.Msg("validation.blank"),
+		},
+		{
+			name:   "Too long",
+			modify: func(f *testForm) { f.Title = "ああああああああああああ" },
+			field:  "title",
+			want:   validator.Msg("validation.max_chars", "max", 10),
+		},
+		{
+			name:   "Required is reported before min",
+			modify: func(f *testForm) { f.Password = "" },
+			field:  "password",
+			want:   validator.Msg("validation.blank"),
+		},
+		{
+			name:   "Too short",
+			modify: func(f *testForm) { f.Password = "pass" },
+			field:  "password",
+			want:   validator.Msg("validation.min_chars", "min", 8),
+		},
+		{
+			name:   "Invalid email",
+			modify: func(f *testForm) { f.Email = "alice@" },
+			field:  "email",
+			want:   validator.Msg("validation.email"),
+		},
+		{
+			name:   "Not one of",
+			modify: func(f *testForm) { f.Expires = 0 },
+			field:  "expires",
+			want:   validator.Msg("validation.permitted", "values", "1, 7, 365"),
+		},
+		{
+			name:   "Number too big",
+			modify: func(f *testForm) { f.Count = 6 },
+			field:  "count",
+			want:   validator.Msg("validation.max_value", "max", 5),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := validForm()
+			tt.modify(&form)
+			form.Validate(form)
+
+			if tt.field == "" {
+				assert.Equal(t, form.Valid(), true)
+				return
+			}
+			assert.Equal(t, len(form.FieldErrors), 1)
+			got := form.FieldErrors[tt.field]
+			if got == nil {
+				t.Fatalf("no error for %s: %v", tt.field, form.FieldErrors)
+			}
+			assert.Equal(t, got.Key, tt.want.Key)
+			for name, value := range tt.want.Params {
+				assert.Equal(t, got.Params[name], value)
+			}
+		})
+	}
+}
+
+// TestValidateKeepsImperativeErrors checks that tag rules and CheckField can be mixed, with the first error for a field winning.
+func TestValidateKeepsImperativeErrors(t *testing.T) {
+	form := validForm()
+	form.Title = ""
+	form.CheckField(false, "title", validator.Msg("custom"))
+	form.Validate(&form)
+	assert.Equal(t, form.FieldErrors["title"].Key, "custom")
+}
+
+func TestRegisterRule(t *testing.T) {
+	validator.RegisterRule("prefix", func(value any, param string) (validator.Message, bool) {
+		return validator.Msg("validation.prefix", "prefix", param), strings.HasPrefix(value.(string), param)
+	})
+
+	var form struct {
+		Code string `form:"code" validate:"required,prefix=SB-"`
+		validator.Validator
+	}
+	form.Code = "XX-1"
+	form.Validate(form)
+	assert.Equal(t, form.FieldErrors["code"].Key, "validation.prefix")
+	assert.Equal(t, form.FieldErrors["code"].Params["prefix"], any("SB-"))
+
+	form.Validator = validator.Validator{}
+	form.Code = "SB-1"
+	form.Validate(form)
+	assert.Equal(t, form.Valid(), true)
+}
+
+func TestValidateUnknownRule(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+	var form struct {
+		Name string `validate:"nonsense"`
+		validator.Validator
+	}
+	form.Validate(form)
+}
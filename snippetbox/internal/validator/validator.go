@@ -1,14 +1,35 @@
 package validator
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 	"unicode/utf8"
 )
 
+// Validator collects validation errors. Errors are Messages rather than text, so they can be translated into the user's language when the form is rendered.
+// FieldErrors holds pointers so that a field without an error is nil, which templates treat as false: {{with .Form.FieldErrors.title}}.
 type Validator struct {
-	FieldErrors    map[string]string
-	NonFieldErrors []string
+	FieldErrors    map[string]*Message
+	NonFieldErrors []Message
+}
+
+// Message is a validation error: the key of a message in the i18n catalogs plus the values of its {name} placeholders.
+type Message struct {
+	Key    string
+	Params map[string]any
+}
+
+// Msg returns a Message for key. params are placeholder names and values in pairs, e.g. Msg("validation.max_chars", "max", 100).
+func Msg(key string, params ...any) Message {
+	m := Message{Key: key}
+	for i := 0; i+1 < len(params); i += 2 {
+		if m.Params == nil {
+			m.Params = make(map[string]any, len(params)/2)
+		}
+		m.Params[fmt.Sprint(params[i])] = params[i+1]
+	}
+	return m
 }
 
 // var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+\\/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$/")
@@ -20,22 +41,22 @@ func (v *Validator) Valid() bool {
 }
 
 // AddNonFieldError adds a validation error that isn't specific to a form field to the list of validation errors
-func (v *Validator) AddNonFieldError(message string) {
+func (v *Validator) AddNonFieldError(message Message) {
 	v.NonFieldErrors = append(v.NonFieldErrors, message)
 }
 
 // AddFieldError adds an error message to FieldErrors map, as long as no entry already exists for given key
-func (v *Validator) AddFieldError(key, message string) {
+func (v *Validator) AddFieldError(key string, message Message) {
 	if v.FieldErrors == nil {
-		v.FieldErrors = make(map[string]string)
+		v.FieldErrors = make(map[string]*Message)
 	}
 	if _, exists := v.FieldErrors[key]; !exists {
-		v.FieldErrors[key] = message
+		v.FieldErrors[key] = &message
 	}
 }
 
 // CheckField adds an error message to FieldErrors map only if validation check is not 'ok'
-func (v *Validator) CheckField(ok bool, key, message string) {
+func (v *Validator) CheckField(ok bool, key string, message Message) {
 	if !ok {
 		v.AddFieldError(key, message)
 	}
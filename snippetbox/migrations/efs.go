@@ -0,0 +1,11 @@
+package migrations
+
+import (
+	"embed"
+)
+
+// comment directive to instruct Go to store the versioned SQL migrations for each database in an embed.FS filesystem referenced by the global variable Files (the same way ui.Files embeds the templates)
+// Each folder holds <version>_<name>.up.sql and <version>_<name>.down.sql files for one -db-driver.
+//
+//go:embed "mysql" "sqlite" "postgres"
+var Files embed.FS
@@ -0,0 +1,3 @@
+DROP TABLE moderation_log;
+DROP TABLE reports;
+ALTER TABLE snippets DROP COLUMN hidden;
@@ -0,0 +1,4 @@
+-- private snippets are left out of the public pages and only shown to their author, identified by user_id
+-- user_id is NULL for snippets created before authors were recorded, and may name an account that has since been deleted: it has no foreign key, so snippets outlive their authors, as moderation_log entries do.
+ALTER TABLE snippets ADD COLUMN user_id INTEGER NULL;
+ALTER TABLE snippets ADD COLUMN private BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,2 @@
+-- remember is set for "remember me" logins, which last longer and have no idle timeout
+ALTER TABLE user_sessions ADD COLUMN remember BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,11 @@
+-- user_identities links users to their accounts at an OpenID Connect provider, so single sign-on logins find the same user even if their email address changes
+-- subject is the provider's id for the user, unique within its issuer
+CREATE TABLE user_identities (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER NOT NULL,
+    issuer VARCHAR(255) NOT NULL,
+    subject VARCHAR(255) NOT NULL,
+    created DATETIME NOT NULL,
+    CONSTRAINT user_identities_uc_issuer_subject UNIQUE (issuer, subject),
+    CONSTRAINT user_identities_fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
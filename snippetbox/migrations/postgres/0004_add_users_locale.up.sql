@@ -0,0 +1,2 @@
+-- the language users chose for the site (empty means it is negotiated from the browser's Accept-Language header)
+ALTER TABLE users ADD COLUMN locale VARCHAR(16) NOT NULL DEFAULT '';
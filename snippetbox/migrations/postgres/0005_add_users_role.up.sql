@@ -0,0 +1,3 @@
+-- role decides what a user may do (user, moderator or admin), and disabled accounts can't log in
+ALTER TABLE users ADD COLUMN role VARCHAR(16) NOT NULL DEFAULT 'user';
+ALTER TABLE users ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;
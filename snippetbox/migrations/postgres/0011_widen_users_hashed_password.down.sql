@@ -0,0 +1,2 @@
+-- fails while any password is hashed with argon2id, as those don't fit
+ALTER TABLE users ALTER COLUMN hashed_password TYPE CHAR(60);
@@ -0,0 +1,31 @@
+-- hidden snippets were taken down by a moderator and are left out of every public page
+ALTER TABLE snippets ADD COLUMN hidden BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- reports are open until a moderator decides on the snippet, when resolved and resolution (hide, delete or dismiss) are set
+CREATE TABLE reports (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    snippet_id INTEGER NOT NULL,
+    reporter_id INTEGER NOT NULL,
+    reason VARCHAR(16) NOT NULL,
+    details VARCHAR(500) NOT NULL,
+    created DATETIME NOT NULL,
+    resolved DATETIME NULL,
+    resolution VARCHAR(16) NULL,
+    CONSTRAINT reports_fk_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE,
+    CONSTRAINT reports_fk_reporter FOREIGN KEY (reporter_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_reports_snippet_resolved ON reports(snippet_id, resolved);
+
+-- the audit trail of moderation decisions. It has no foreign keys, so entries outlive the snippets and moderators they are about.
+CREATE TABLE moderation_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    snippet_id INTEGER NOT NULL,
+    snippet_title VARCHAR(100) NOT NULL,
+    moderator_id INTEGER NOT NULL,
+    action VARCHAR(16) NOT NULL,
+    reports INTEGER NOT NULL,
+    created DATETIME NOT NULL
+);
+
+CREATE INDEX idx_moderation_log_created ON moderation_log(created);
@@ -0,0 +1,15 @@
+-- audit_events is append-only: the application inserts and reads events, but never updates or deletes them
+-- user_id is the acting user, NULL for anonymous requests such as failed logins. It has no foreign key, so events outlive the accounts they are about.
+CREATE TABLE audit_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created DATETIME NOT NULL,
+    action VARCHAR(32) NOT NULL,
+    user_id INTEGER NULL,
+    ip VARCHAR(45) NOT NULL,
+    user_agent VARCHAR(255) NOT NULL,
+    request_id VARCHAR(32) NOT NULL,
+    details VARCHAR(255) NOT NULL
+);
+
+CREATE INDEX idx_audit_events_created ON audit_events(created);
+CREATE INDEX idx_audit_events_user_id ON audit_events(user_id);
@@ -0,0 +1,17 @@
+-- user_sessions tracks the logged in sessions of each user, so they can be listed on the account page and revoked
+-- token is the session's token in the sessions table. It is never shown to users, who see the id instead.
+-- Rows are removed on logout and revocation, and expired rows are cleared out as the user's sessions are listed.
+CREATE TABLE user_sessions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    token VARCHAR(43) NOT NULL,
+    created DATETIME NOT NULL,
+    last_seen DATETIME NOT NULL,
+    expires DATETIME NOT NULL,
+    ip VARCHAR(45) NOT NULL,
+    user_agent VARCHAR(255) NOT NULL,
+    CONSTRAINT user_sessions_uc_token UNIQUE (token),
+    CONSTRAINT user_sessions_fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_user_sessions_user_id ON user_sessions(user_id);
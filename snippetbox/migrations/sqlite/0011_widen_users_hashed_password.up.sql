@@ -0,0 +1 @@
+-- argon2id hashes are longer than the 60 characters of a bcrypt hash, but SQLite doesn't enforce column lengths, so there is nothing to change